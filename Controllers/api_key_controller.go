@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"Products/tenant"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// NewAPIKey generates and stores a key. Every key is bound to a tenant
+// except platform-admin keys, which never are. The returned record carries
+// the plaintext key, which cannot be recovered later.
+func NewAPIKey(db *sql.DB, apiKey models.APIKey) (models.APIKey, error) {
+	apiKey.Name = strings.TrimSpace(apiKey.Name)
+	if apiKey.Name == "" {
+		return apiKey, badRequest("name is required")
+	}
+	if apiKey.Roles == nil {
+		apiKey.Roles = []string{}
+	}
+	platformAdmin := slices.Contains(apiKey.Roles, auth.RolePlatformAdmin)
+	switch {
+	case apiKey.Tenant == nil && !platformAdmin:
+		return apiKey, badRequest("tenant is required")
+	case apiKey.Tenant != nil && platformAdmin:
+		return apiKey, badRequest("%s keys cannot be bound to a tenant", auth.RolePlatformAdmin)
+	case apiKey.Tenant != nil && !tenant.Valid(*apiKey.Tenant):
+		return apiKey, badRequest("invalid tenant")
+	}
+
+	key, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return apiKey, err
+	}
+	apiKey.Key = key
+	apiKey.Prefix = key[:len(auth.APIKeyPrefix)+6]
+
+	err = db.QueryRow(`INSERT INTO api_key (name, key_hash, key_prefix, roles, expires_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`, apiKey.Name, hash, apiKey.Prefix, pq.Array(apiKey.Roles), apiKey.ExpiresAt, apiKey.Tenant).
+		Scan(&apiKey.ID, &apiKey.CreatedAt)
+	return apiKey, err
+}
+
+// principalTenant is the tenant the caller is bound to, or "" for platform
+// admins, who manage the keys of every tenant.
+func principalTenant(r *http.Request) string {
+	p, _ := auth.FromContext(r.Context())
+	return p.Tenant
+}
+
+// GetAPIKeys lists the API keys that have not been revoked. Callers bound to
+// a tenant only see that tenant's keys.
+func GetAPIKeys(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query("SELECT id, name, key_prefix, roles, tenant_id, created_at, expires_at, revoked_at FROM api_key WHERE revoked_at IS NULL AND ($1 = '' OR tenant_id = $1) ORDER BY id", principalTenant(r))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		keys := []models.APIKey{}
+		for rows.Next() {
+			var key models.APIKey
+			if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Roles), &key.Tenant, &key.CreatedAt, &key.ExpiresAt, &key.RevokedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			keys = append(keys, key)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// CreateAPIKey creates a key. Callers bound to a tenant can only create keys
+// for that tenant.
+func CreateAPIKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var apiKey models.APIKey
+		if err := json.NewDecoder(r.Body).Decode(&apiKey); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if bound := principalTenant(r); bound != "" {
+			apiKey.Tenant = &bound
+		}
+
+		apiKey, err := NewAPIKey(db, apiKey)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(apiKey)
+	}
+}
+
+// RevokeAPIKey stops a key from authenticating. Revoked keys are kept for
+// auditing.
+func RevokeAPIKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.Exec("UPDATE api_key SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL AND ($2 = '' OR tenant_id = $2)", id, principalTenant(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"Products/approval"
+	"Products/auth"
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const approvalRuleColumns = `id, name, metric, operator, threshold, active, tenant_id, created_at, updated_at, deleted_at`
+
+const offerApprovalColumns = `id, offer_id, status, matches, requested_by, decided_by, comment, created_at, decided_at`
+
+func scanApprovalRule(row interface{ Scan(...interface{}) error }, rule *models.ApprovalRule) error {
+	return row.Scan(&rule.ID, &rule.Name, &rule.Metric, &rule.Operator, &rule.Threshold, &rule.Active, &rule.TenantID, &rule.CreatedAt, &rule.UpdatedAt, &rule.DeletedAt)
+}
+
+func scanOfferApproval(row interface{ Scan(...interface{}) error }, a *models.OfferApproval) error {
+	var matches []byte
+	if err := row.Scan(&a.ID, &a.OfferID, &a.Status, &matches, &a.RequestedBy, &a.DecidedBy, &a.Comment, &a.CreatedAt, &a.DecidedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal(matches, &a.Matches)
+}
+
+// evaluateApprovalRules runs the tenant's active approval rules against the
+// offer's lines. Lines are priced against the list price of their material,
+// the same price new lines default to.
+func evaluateApprovalRules(ctx context.Context, q queryer, offerID int) ([]models.ApprovalMatch, error) {
+	tenantID := tenant.FromContext(ctx)
+	rows, err := q.QueryContext(ctx, "SELECT "+approvalRuleColumns+" FROM approval_rule WHERE tenant_id = $1 AND active AND deleted_at IS NULL ORDER BY id", tenantID)
+	if err != nil {
+		return nil, err
+	}
+	rules := []models.ApprovalRule{}
+	for rows.Next() {
+		var rule models.ApprovalRule
+		if err := scanApprovalRule(rows, &rule); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return []models.ApprovalMatch{}, nil
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT om.id, om.quantity, om.unit_price,
+			COALESCE((SELECT ms.purchase_price FROM material_supplier ms JOIN supplier s ON s.id = ms.supplier_id
+				WHERE ms.material_id = om.material_id AND ms.tenant_id = om.tenant_id AND ms.preferred AND ms.deleted_at IS NULL AND s.deleted_at IS NULL), 0)
+		FROM offer_material om
+		WHERE om.offer_id = $1 AND om.tenant_id = $2 AND om.deleted_at IS NULL
+		ORDER BY om.id`, offerID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offer approval.Offer
+	for rows.Next() {
+		var (
+			line approval.Line
+			cost float64
+		)
+		if err := rows.Scan(&line.OfferMaterialID, &line.Quantity, &line.UnitPrice, &cost); err != nil {
+			return nil, err
+		}
+		if cost > 0 {
+			line.ListPrice = listPrice(cost)
+		}
+		offer.Lines = append(offer.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return approval.Evaluate(rules, offer), nil
+}
+
+// requestApproval records a pending approval for the offer.
+func requestApproval(ctx context.Context, tx *sql.Tx, offerID int, matches []models.ApprovalMatch) (models.OfferApproval, error) {
+	p, _ := auth.FromContext(ctx)
+	request := models.OfferApproval{OfferID: offerID, Status: models.ApprovalPending, Matches: matches, RequestedBy: p.ID}
+	encoded, err := json.Marshal(matches)
+	if err != nil {
+		return request, err
+	}
+	err = tx.QueryRowContext(ctx, "INSERT INTO offer_approval (offer_id, matches, requested_by, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		offerID, encoded, request.RequestedBy, tenant.FromContext(ctx)).Scan(&request.ID, &request.CreatedAt)
+	return request, err
+}
+
+// decideOfferApproval approves or rejects the pending approval of an
+// offer. Approved offers are sent, rejected ones go back to draft.
+func decideOfferApproval(ctx context.Context, db *sql.DB, offerID int, status, comment string) (models.OfferApproval, error) {
+	var decided models.OfferApproval
+	tenantID := tenant.FromContext(ctx)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return decided, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", offerID, tenantID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return decided, notFound("Offer not found")
+	}
+	if err != nil {
+		return decided, err
+	}
+	if current != models.OfferPendingApproval {
+		return decided, conflict("offer is %s, not pending approval", current)
+	}
+
+	p, _ := auth.FromContext(ctx)
+	err = scanOfferApproval(tx.QueryRowContext(ctx, `UPDATE offer_approval SET status = $1, decided_by = $2, comment = $3, decided_at = CURRENT_TIMESTAMP
+		WHERE offer_id = $4 AND tenant_id = $5 AND status = $6
+		RETURNING `+offerApprovalColumns, status, p.ID, comment, offerID, tenantID, models.ApprovalPending), &decided)
+	if err == sql.ErrNoRows {
+		return decided, conflict("offer has no pending approval")
+	}
+	if err != nil {
+		return decided, err
+	}
+
+	next := models.OfferDraft
+	if status == models.ApprovalApproved {
+		next = models.OfferSent
+	}
+	var validUntil *time.Time
+	err = tx.QueryRowContext(ctx, "UPDATE offer SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND tenant_id = $3 RETURNING valid_until", next, offerID, tenantID).Scan(&validUntil)
+	if err != nil {
+		return decided, err
+	}
+	err = enqueueEvent(ctx, tx, tenantID, models.EntityOffer, offerID, models.EventOfferStatusChanged, models.OfferStatusChangedEvent{OfferID: offerID, From: current, To: next, ValidUntil: validUntil})
+	if err != nil {
+		return decided, err
+	}
+	return decided, tx.Commit()
+}
+
+func decideOfferApprovalHandler(db *sql.DB, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var decision models.ApprovalDecision
+		if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		decision.Comment = strings.TrimSpace(decision.Comment)
+		if status == models.ApprovalRejected && decision.Comment == "" {
+			http.Error(w, "comment is required", http.StatusBadRequest)
+			return
+		}
+
+		decided, err := decideOfferApproval(r.Context(), db, id, status, decision.Comment)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(decided)
+	}
+}
+
+// ApproveOffer approves an offer pending approval, which sends it.
+func ApproveOffer(db *sql.DB) http.HandlerFunc {
+	return decideOfferApprovalHandler(db, models.ApprovalApproved)
+}
+
+// RejectOfferApproval sends an offer pending approval back to draft. A
+// comment explaining why is required.
+func RejectOfferApproval(db *sql.DB) http.HandlerFunc {
+	return decideOfferApprovalHandler(db, models.ApprovalRejected)
+}
+
+// GetOfferApprovals lists the approval requests of an offer, newest first.
+func GetOfferApprovals(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT "+offerApprovalColumns+" FROM offer_approval WHERE offer_id = $1 AND tenant_id = $2 ORDER BY id DESC", id, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		approvals := []models.OfferApproval{}
+		for rows.Next() {
+			var a models.OfferApproval
+			if err := scanOfferApproval(rows, &a); err != nil {
+				writeError(w, err)
+				return
+			}
+			approvals = append(approvals, a)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(approvals)
+	}
+}
+
+func GetApprovalRules(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT "+approvalRuleColumns+" FROM approval_rule WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id", tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		rules := []models.ApprovalRule{}
+		for rows.Next() {
+			var rule models.ApprovalRule
+			if err := scanApprovalRule(rows, &rule); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			rules = append(rules, rule)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	}
+}
+
+// decodeApprovalRule reads and validates a rule. Rules are active unless
+// the body says otherwise.
+func decodeApprovalRule(r *http.Request) (models.ApprovalRule, error) {
+	rule := models.ApprovalRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		return rule, badRequest("%s", err.Error())
+	}
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return rule, badRequest("name is required")
+	}
+	if err := approval.Validate(rule); err != nil {
+		return rule, badRequest("%s", err.Error())
+	}
+	rule.TenantID = tenant.FromContext(r.Context())
+	return rule, nil
+}
+
+func CreateApprovalRule(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule, err := decodeApprovalRule(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), `INSERT INTO approval_rule (name, metric, operator, threshold, active, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`,
+			rule.Name, rule.Metric, rule.Operator, rule.Threshold, rule.Active, rule.TenantID).
+			Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+func UpdateApprovalRule(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		rule, err := decodeApprovalRule(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		rule.ID = id
+
+		err = db.QueryRowContext(r.Context(), `UPDATE approval_rule SET name = $1, metric = $2, operator = $3, threshold = $4, active = $5, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $6 AND tenant_id = $7 AND deleted_at IS NULL RETURNING created_at, updated_at`,
+			rule.Name, rule.Metric, rule.Operator, rule.Threshold, rule.Active, id, rule.TenantID).
+			Scan(&rule.CreatedAt, &rule.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Approval rule not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+func DeleteApprovalRule(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE approval_rule SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Approval rule not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
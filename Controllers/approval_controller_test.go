@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"Products/approval"
+	"Products/auth"
+	"Products/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	approvalRules           = regexp.QuoteMeta(`FROM approval_rule WHERE tenant_id = $1 AND active AND deleted_at IS NULL ORDER BY id`)
+	approvalRuleRowColumns  = []string{"id", "name", "metric", "operator", "threshold", "active", "tenant_id", "created_at", "updated_at", "deleted_at"}
+	offerApprovalRowColumns = []string{"id", "offer_id", "status", "matches", "requested_by", "decided_by", "comment", "created_at", "decided_at"}
+)
+
+func TestApprovalEvaluate(t *testing.T) {
+	rule := func(id int, metric, operator string, threshold float64) models.ApprovalRule {
+		return models.ApprovalRule{ID: id, Name: metric, Metric: metric, Operator: operator, Threshold: threshold, Active: true}
+	}
+	offer := approval.Offer{Lines: []approval.Line{
+		{OfferMaterialID: 1, Quantity: 10, UnitPrice: 80, ListPrice: 100},
+		{OfferMaterialID: 2, Quantity: 5, UnitPrice: 100, ListPrice: 100},
+		{OfferMaterialID: 3, Quantity: 1, UnitPrice: 50},
+	}}
+
+	testCases := []struct {
+		name   string
+		rules  []models.ApprovalRule
+		values []float64
+		lines  []int
+	}{
+		{name: "total above threshold", rules: []models.ApprovalRule{rule(1, models.MetricTotal, ">", 1000)}, values: []float64{1350}},
+		{name: "total below threshold", rules: []models.ApprovalRule{rule(1, models.MetricTotal, ">", 5000)}},
+		{name: "offer discount weighted by quantity", rules: []models.ApprovalRule{rule(1, models.MetricDiscountPercent, ">=", 10)}, values: []float64{13.33}},
+		{name: "line discount matches per line", rules: []models.ApprovalRule{rule(1, models.MetricLineDiscountPercent, ">=", 20)}, values: []float64{20}, lines: []int{1}},
+		{name: "inactive rules are skipped", rules: []models.ApprovalRule{{ID: 1, Metric: models.MetricTotal, Operator: ">", Threshold: 0}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matches := approval.Evaluate(tc.rules, offer)
+			values := []float64{}
+			lines := []int{}
+			for _, match := range matches {
+				values = append(values, match.Value)
+				if match.OfferMaterialID != nil {
+					lines = append(lines, *match.OfferMaterialID)
+				}
+			}
+			if tc.values == nil {
+				tc.values = []float64{}
+			}
+			if tc.lines == nil {
+				tc.lines = []int{}
+			}
+			assert.Equal(t, tc.values, values)
+			assert.Equal(t, tc.lines, lines)
+		})
+	}
+}
+
+func TestSendOfferNeedsApproval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT status, owner_id, customer_id FROM offer`)).WithArgs(1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("draft", nil, 7))
+	mock.ExpectQuery(approvalRules).WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(approvalRuleRowColumns).AddRow(4, "Large offers", "total", ">", 1000.0, true, "acme", time.Now(), time.Now(), nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT om.id, om.quantity, om.unit_price,`)).WithArgs(1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quantity", "unit_price", "cost"}).AddRow(10, 20.0, 75.0, 0.0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO offer_approval (offer_id, matches, requested_by, tenant_id)`)).
+		WithArgs(1, sqlmock.AnyArg(), "user-1", "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE offer SET status = $1, valid_until = COALESCE($2, valid_until), updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 RETURNING valid_until`)).
+		WithArgs(models.OfferPendingApproval, nil, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"valid_until"}).AddRow(nil))
+	expectEvent(mock, "offer", "1", models.EventOfferStatusChanged)
+	mock.ExpectCommit()
+
+	req := asPrincipal(httptest.NewRequest("POST", "/offers/1/status", strings.NewReader(`{"status": "sent"}`)), "user-1", auth.RoleSales)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	handler := UpdateOfferStatus(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var request models.OfferApproval
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&request))
+	assert.Equal(t, models.ApprovalPending, request.Status)
+	assert.Len(t, request.Matches, 1)
+	assert.Equal(t, 1500.0, request.Matches[0].Value)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDecideOfferApproval(t *testing.T) {
+	lockOffer := regexp.QuoteMeta(`SELECT status FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`)
+	decide := regexp.QuoteMeta(`UPDATE offer_approval SET status = $1, decided_by = $2, comment = $3, decided_at = CURRENT_TIMESTAMP`)
+	updateOffer := regexp.QuoteMeta(`UPDATE offer SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND tenant_id = $3 RETURNING valid_until`)
+	decidedRow := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows(offerApprovalRowColumns).
+			AddRow(2, 1, status, []byte(`[{"rule_id": 4, "metric": "total", "value": 1500}]`), "user-1", "boss", "ok", time.Now(), time.Now())
+	}
+
+	testCases := []struct {
+		name         string
+		handler      func(db *sql.DB) http.HandlerFunc
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - approving sends the offer",
+			handler:      ApproveOffer,
+			requestBody:  `{"comment": "ok"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending_approval"))
+				mock.ExpectQuery(decide).WithArgs("approved", "boss", "ok", 1, "acme", "pending").WillReturnRows(decidedRow("approved"))
+				mock.ExpectQuery(updateOffer).WithArgs("sent", 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"valid_until"}).AddRow(nil))
+				expectEvent(mock, "offer", "1", models.EventOfferStatusChanged)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - rejecting returns the offer to draft",
+			handler:      RejectOfferApproval,
+			requestBody:  `{"comment": "discount too high"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending_approval"))
+				mock.ExpectQuery(decide).WithArgs("rejected", "boss", "discount too high", 1, "acme", "pending").WillReturnRows(decidedRow("rejected"))
+				mock.ExpectQuery(updateOffer).WithArgs("draft", 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"valid_until"}).AddRow(nil))
+				expectEvent(mock, "offer", "1", models.EventOfferStatusChanged)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - rejecting needs a comment",
+			handler:      RejectOfferApproval,
+			requestBody:  `{"comment": " "}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - offer not pending approval",
+			handler:      ApproveOffer,
+			requestBody:  `{}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("draft"))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := asPrincipal(httptest.NewRequest("POST", "/offers/1/approve", strings.NewReader(tc.requestBody)), "boss", auth.RoleManager)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			tc.handler(db).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateApprovalRule(t *testing.T) {
+	insert := regexp.QuoteMeta(`INSERT INTO approval_rule (name, metric, operator, threshold, active, tenant_id)`)
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - active by default",
+			requestBody:  `{"name": "Big discounts", "metric": "line_discount_percent", "operator": ">=", "threshold": 20}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(insert).WithArgs("Big discounts", "line_discount_percent", ">=", 20.0, true, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+			},
+		},
+		{
+			name:         "failure - unknown metric",
+			requestBody:  `{"name": "Margin", "metric": "margin", "operator": ">", "threshold": 5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - unknown operator",
+			requestBody:  `{"name": "Total", "metric": "total", "operator": "!=", "threshold": 5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/approval-rules", strings.NewReader(tc.requestBody)), "acme")
+			w := httptest.NewRecorder()
+
+			handler := CreateApprovalRule(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/config"
+	"Products/models"
+	"Products/storage"
+	"Products/tenant"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// attachmentEntities maps the entities that take attachments to their
+// table and URL prefix.
+var attachmentEntities = map[string]struct{ table, path string }{
+	models.EntityMaterial: {table: "material", path: "/materials/"},
+	models.EntityOffer:    {table: "offer", path: "/offers/"},
+}
+
+// sniffLen is how much of an upload http.DetectContentType looks at.
+const sniffLen = 512
+
+const attachmentColumns = `id, entity, entity_id, file_name, content_type, size_bytes, checksum, uploaded_by, created_at`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }, a *models.Attachment) error {
+	if err := row.Scan(&a.ID, &a.Entity, &a.EntityID, &a.FileName, &a.ContentType, &a.Size, &a.Checksum, &a.UploadedBy, &a.CreatedAt); err != nil {
+		return err
+	}
+	a.URL = fmt.Sprintf("%s%d/attachments/%d", attachmentEntities[a.Entity].path, a.EntityID, a.ID)
+	return nil
+}
+
+// loadAttachments returns the attachments of the given records by ID,
+// oldest first, with an empty list for records without any.
+func loadAttachments(ctx context.Context, db *sql.DB, entity string, ids []int) (map[int][]models.Attachment, error) {
+	attachments := map[int][]models.Attachment{}
+	for _, id := range ids {
+		attachments[id] = []models.Attachment{}
+	}
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+attachmentColumns+" FROM attachment WHERE entity = $1 AND entity_id = ANY($2) AND tenant_id = $3 AND deleted_at IS NULL ORDER BY entity_id, id",
+		entity, pq.Array(ids), tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, err
+		}
+		attachments[a.EntityID] = append(attachments[a.EntityID], a)
+	}
+	return attachments, rows.Err()
+}
+
+// checkAttachmentOwner makes sure the record in the URL exists in the
+// request's tenant and, for offers, that the caller may edit it.
+func checkAttachmentOwner(ctx context.Context, db *sql.DB, entity string, id int, edit bool) error {
+	if entity == models.EntityOffer && edit {
+		if err := authorizeOfferEdit(ctx, db, offerOwnerQuery, id); err != nil {
+			return err
+		}
+	}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+attachmentEntities[entity].table+" WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)",
+		id, tenant.FromContext(ctx)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFound("%s not found", attachmentEntities[entity].table)
+	}
+	return nil
+}
+
+// storeUpload streams the "file" part of a multipart request into store,
+// sniffing its content type and hashing it on the way. Uploads over max
+// bytes are removed again and fail with 413.
+func storeUpload(r *http.Request, store storage.Store, key string, max int64) (models.Attachment, error) {
+	var a models.Attachment
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return a, badRequest("a multipart/form-data body is required")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return a, badRequest("file is required")
+		}
+		if err != nil {
+			return a, badRequest("invalid multipart body: %v", err)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		a.FileName = filepath.Base(part.FileName())
+		if a.FileName == "." || a.FileName == string(filepath.Separator) {
+			a.FileName = "upload"
+		}
+		buffered := bufio.NewReaderSize(part, sniffLen)
+		head, _ := buffered.Peek(sniffLen)
+		a.ContentType = http.DetectContentType(head)
+
+		hash := sha256.New()
+		limited := &io.LimitedReader{R: buffered, N: max + 1}
+		a.Size, err = store.Put(r.Context(), key, io.TeeReader(limited, hash))
+		if err != nil {
+			store.Delete(r.Context(), key)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return a, &statusError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("file is larger than %d bytes", max)}
+			}
+			return a, err
+		}
+		if a.Size > max {
+			store.Delete(r.Context(), key)
+			return a, &statusError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("file is larger than %d bytes", max)}
+		}
+		if a.Size == 0 {
+			store.Delete(r.Context(), key)
+			return a, badRequest("file is empty")
+		}
+		a.Checksum = hex.EncodeToString(hash.Sum(nil))
+		return a, nil
+	}
+}
+
+// UploadAttachment stores the multipart "file" field as an attachment of
+// the material or offer in the URL. The content type is sniffed from the
+// content; the one sent by the client is ignored. Being multipart, uploads
+// cannot carry an Idempotency-Key.
+func UploadAttachment(db *sql.DB, store storage.Store, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		if err := checkAttachmentOwner(ctx, db, entity, id, true); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		tenantID := tenant.FromContext(ctx)
+		key, err := storage.NewKey(tenantID, entity)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		max := config.AttachmentMaxBytes()
+		// Leave room for the multipart headers around the file.
+		r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+		attachment, err := storeUpload(r, store, key, max)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		p, _ := auth.FromContext(ctx)
+		attachment.Entity, attachment.EntityID, attachment.UploadedBy = entity, id, p.ID
+		err = db.QueryRowContext(ctx, `INSERT INTO attachment (entity, entity_id, file_name, content_type, size_bytes, checksum, storage_key, uploaded_by, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`,
+			entity, id, attachment.FileName, attachment.ContentType, attachment.Size, attachment.Checksum, key, attachment.UploadedBy, tenantID).
+			Scan(&attachment.ID, &attachment.CreatedAt)
+		if err != nil {
+			store.Delete(ctx, key)
+			writeError(w, err)
+			return
+		}
+		attachment.URL = fmt.Sprintf("%s%d/attachments/%d", attachmentEntities[entity].path, id, attachment.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(attachment)
+	}
+}
+
+// GetAttachments lists the attachments of the material or offer in the URL.
+func GetAttachments(db *sql.DB, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		if err := checkAttachmentOwner(r.Context(), db, entity, id, false); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		attachments, err := loadAttachments(r.Context(), db, entity, []int{id})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attachments[id])
+	}
+}
+
+// findAttachment loads the attachment in the URL and its storage key.
+func findAttachment(r *http.Request, db *sql.DB, entity string) (models.Attachment, string, error) {
+	var a models.Attachment
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return a, "", badRequest("invalid id")
+	}
+	attachmentID, err := strconv.Atoi(vars["attachment_id"])
+	if err != nil {
+		return a, "", badRequest("invalid attachment id")
+	}
+
+	var key string
+	err = db.QueryRowContext(r.Context(), `SELECT `+attachmentColumns+`, storage_key FROM attachment
+		WHERE id = $1 AND entity = $2 AND entity_id = $3 AND tenant_id = $4 AND deleted_at IS NULL`,
+		attachmentID, entity, id, tenant.FromContext(r.Context())).
+		Scan(&a.ID, &a.Entity, &a.EntityID, &a.FileName, &a.ContentType, &a.Size, &a.Checksum, &a.UploadedBy, &a.CreatedAt, &key)
+	if err == sql.ErrNoRows {
+		return a, "", notFound("Attachment not found")
+	}
+	return a, key, err
+}
+
+// DownloadAttachment streams an attachment. Range, If-Range and
+// If-None-Match requests are answered from the checksum-based ETag. Files
+// are always served as downloads so uploaded HTML never renders inline.
+func DownloadAttachment(db *sql.DB, store storage.Store, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attachment, key, err := findAttachment(r, db, entity)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		blob, err := store.Open(r.Context(), key)
+		if err != nil {
+			log.Printf("Error opening attachment %d: %v", attachment.ID, err)
+			http.Error(w, "attachment content unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer blob.Close()
+
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("ETag", `"`+attachment.Checksum+`"`)
+		http.ServeContent(w, r, attachment.FileName, attachment.CreatedAt, blob)
+	}
+}
+
+// DeleteAttachment removes an attachment and its content.
+func DeleteAttachment(db *sql.DB, store storage.Store, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attachment, key, err := findAttachment(r, db, entity)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkAttachmentOwner(r.Context(), db, entity, attachment.EntityID, true); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		_, err = db.ExecContext(r.Context(), "UPDATE attachment SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2", attachment.ID, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := store.Delete(r.Context(), key); err != nil {
+			log.Printf("Error deleting attachment %d content: %v", attachment.ID, err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"Products/storage"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var materialExistsQuery = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+
+// multipartFile returns a multipart body with content in its "file" field.
+func multipartFile(t *testing.T, name string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", name)
+	assert.NoError(t, err)
+	part.Write(content)
+	assert.NoError(t, writer.Close())
+	return body, writer.FormDataContentType()
+}
+
+func TestUploadAttachment(t *testing.T) {
+	pdf := []byte("%PDF-1.4\nspec sheet")
+	sum := sha256.Sum256(pdf)
+	checksum := hex.EncodeToString(sum[:])
+	insert := regexp.QuoteMeta(`INSERT INTO attachment (entity, entity_id, file_name, content_type, size_bytes, checksum, storage_key, uploaded_by, tenant_id)`)
+
+	testCases := []struct {
+		name         string
+		maxBytes     string
+		fileName     string
+		content      []byte
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - type is sniffed from the content",
+			fileName:     "../spec.html",
+			content:      pdf,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(insert).
+					WithArgs(models.EntityMaterial, 4, "spec.html", "application/pdf", int64(len(pdf)), checksum, sqlmock.AnyArg(), "user-1", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(6, time.Now()))
+			},
+		},
+		{
+			name:         "failure - larger than the limit",
+			maxBytes:     "8",
+			fileName:     "spec.pdf",
+			content:      pdf,
+			expectedCode: http.StatusRequestEntityTooLarge,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+		{
+			name:         "failure - empty file",
+			fileName:     "spec.pdf",
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+		{
+			name:         "failure - material not found",
+			fileName:     "spec.pdf",
+			content:      pdf,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ATTACHMENT_MAX_BYTES", tc.maxBytes)
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			store, err := storage.NewLocal(t.TempDir())
+			assert.NoError(t, err)
+
+			tc.mockQueries(mock)
+
+			body, contentType := multipartFile(t, tc.fileName, tc.content)
+			req := asPrincipal(httptest.NewRequest("POST", "/materials/4/attachments", body), "user-1", auth.RoleCatalogAdmin)
+			req.Header.Set("Content-Type", contentType)
+			req = mux.SetURLVars(req, map[string]string{"id": "4"})
+			w := httptest.NewRecorder()
+
+			handler := UploadAttachment(db, store, models.EntityMaterial)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if w.Code == http.StatusCreated {
+				var attachment models.Attachment
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&attachment))
+				assert.Equal(t, "/materials/4/attachments/6", attachment.URL)
+				assert.Equal(t, checksum, attachment.Checksum)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDownloadAttachmentRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store, err := storage.NewLocal(t.TempDir())
+	assert.NoError(t, err)
+	_, err = store.Put(context.Background(), "acme/offer/abc", strings.NewReader("0123456789"))
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM attachment
+		WHERE id = $1 AND entity = $2 AND entity_id = $3 AND tenant_id = $4 AND deleted_at IS NULL`)).
+		WithArgs(6, models.EntityOffer, 1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "file_name", "content_type", "size_bytes", "checksum", "uploaded_by", "created_at", "storage_key"}).
+			AddRow(6, "offer", 1, "quote.txt", "text/plain; charset=utf-8", 10, "abc123", "user-1", time.Now(), "acme/offer/abc"))
+
+	req := withTenant(httptest.NewRequest("GET", "/offers/1/attachments/6", nil), "acme")
+	req.Header.Set("Range", "bytes=2-5")
+	req = mux.SetURLVars(req, map[string]string{"id": "1", "attachment_id": "6"})
+	w := httptest.NewRecorder()
+
+	handler := DownloadAttachment(db, store, models.EntityOffer)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "2345", w.Body.String())
+	assert.Equal(t, `"abc123"`, w.Header().Get("ETag"))
+	assert.Equal(t, `attachment; filename=quote.txt`, w.Header().Get("Content-Disposition"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
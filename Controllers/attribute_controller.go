@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Attribute names must be usable in attr.<name> filters.
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func validateAttributeDefinition(definition models.AttributeDefinition) string {
+	if definition.Entity != models.EntityMaterial && definition.Entity != models.EntityOffer {
+		return "entity must be material or offer"
+	}
+	if !attributeNamePattern.MatchString(definition.Name) {
+		return "name may only contain letters, digits and underscores"
+	}
+	if _, ok := attributeFilterOps[definition.Type]; !ok {
+		return "type must be string, number, bool or date"
+	}
+	return ""
+}
+
+// GetAttributeDefinitions lists attribute schemas, optionally only those
+// of ?entity=material or ?entity=offer.
+func GetAttributeDefinitions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := "SELECT id, entity, name, type, created_at, updated_at, deleted_at FROM attribute_definition WHERE tenant_id = $1 AND deleted_at IS NULL"
+		args := []interface{}{tenant.FromContext(r.Context())}
+		if entity := r.URL.Query().Get("entity"); entity != "" {
+			query += " AND entity = $2"
+			args = append(args, entity)
+		}
+
+		rows, err := db.QueryContext(r.Context(), query+" ORDER BY entity, name", args...)
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		definitions := []models.AttributeDefinition{}
+		for rows.Next() {
+			var definition models.AttributeDefinition
+			if err := rows.Scan(&definition.ID, &definition.Entity, &definition.Name, &definition.Type, &definition.CreatedAt, &definition.UpdatedAt, &definition.DeletedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			definitions = append(definitions, definition)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(definitions)
+	}
+}
+
+func CreateAttributeDefinition(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var definition models.AttributeDefinition
+		if err := json.NewDecoder(r.Body).Decode(&definition); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if msg := validateAttributeDefinition(definition); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var exists bool
+		err := db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM attribute_definition WHERE entity = $1 AND name = $2 AND tenant_id = $3 AND deleted_at IS NULL)", definition.Entity, definition.Name, tenantID).Scan(&exists)
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, "attribute already defined", http.StatusConflict)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), "INSERT INTO attribute_definition (entity, name, type, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at", definition.Entity, definition.Name, definition.Type, tenantID).
+			Scan(&definition.ID, &definition.CreatedAt, &definition.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(definition)
+	}
+}
+
+// DeleteAttributeDefinition soft deletes a schema. Stored values are kept
+// but no longer returned or filterable.
+func DeleteAttributeDefinition(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE attribute_definition SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Attribute not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const apiKeyLookup = `SELECT id, name, roles, tenant_id FROM api_key`
+
+// whoAmI answers with the principal found in the request context.
+var whoAmI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	json.NewEncoder(w).Encode(p)
+})
+
+func signJWT(t *testing.T, alg, kid string, claims map[string]interface{}, sign func([]byte) []byte) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		found      bool
+		wantStatus int
+	}{
+		{name: "X-API-Key header", header: "X-API-Key", value: "prd_valid", found: true, wantStatus: http.StatusOK},
+		{name: "Authorization header", header: "Authorization", value: "ApiKey prd_valid", found: true, wantStatus: http.StatusOK},
+		{name: "unknown key", header: "X-API-Key", value: "prd_unknown", found: false, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			key := strings.TrimPrefix(tt.value, "ApiKey ")
+			rows := sqlmock.NewRows([]string{"id", "name", "roles", "tenant_id"})
+			if tt.found {
+				rows.AddRow(7, "erp", "{admin,sales}", "acme")
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(apiKeyLookup)).WithArgs(auth.HashAPIKey(key)).WillReturnRows(rows)
+
+			req := httptest.NewRequest("GET", "/offers", nil)
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+
+			auth.Middleware(auth.NewAPIKeyAuthenticator(db))(whoAmI).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.found {
+				var p auth.Principal
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+				assert.Equal(t, "api_key:7", p.ID)
+				assert.Equal(t, auth.MethodAPIKey, p.Method)
+				assert.Equal(t, []string{"admin", "sales"}, p.Roles)
+				assert.Equal(t, "acme", p.Tenant)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA", "kid": "k1", "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+	}}})
+	assert.NoError(t, err)
+	keys, err := auth.ParseJWKS(jwks)
+	assert.NoError(t, err)
+
+	rs256 := func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+		return signature
+	}
+	claims := func(exp time.Duration, aud string) map[string]interface{} {
+		return map[string]interface{}{"sub": "user-1", "name": "Alice", "iss": "issuer", "aud": aud, "exp": time.Now().Add(exp).Unix(), "roles": []string{"sales"}}
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "HS256", token: signJWT(t, "HS256", "", claims(time.Hour, "products"), hs256(secret)), wantStatus: http.StatusOK},
+		{name: "RS256 from JWKS", token: signJWT(t, "RS256", "k1", claims(time.Hour, "products"), rs256), wantStatus: http.StatusOK},
+		{name: "wrong secret", token: signJWT(t, "HS256", "", claims(time.Hour, "products"), hs256([]byte("other"))), wantStatus: http.StatusUnauthorized},
+		{name: "expired", token: signJWT(t, "HS256", "", claims(-time.Hour, "products"), hs256(secret)), wantStatus: http.StatusUnauthorized},
+		{name: "wrong audience", token: signJWT(t, "RS256", "k1", claims(time.Hour, "billing"), rs256), wantStatus: http.StatusUnauthorized},
+		{name: "unknown kid", token: signJWT(t, "RS256", "k2", claims(time.Hour, "products"), rs256), wantStatus: http.StatusUnauthorized},
+		{name: "alg none", token: signJWT(t, "none", "", claims(time.Hour, "products"), func([]byte) []byte { return nil }), wantStatus: http.StatusUnauthorized},
+	}
+
+	authenticator := &auth.JWTAuthenticator{Secret: secret, Keys: keys, Issuer: "issuer", Audience: "products"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/offers", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+
+			auth.Middleware(authenticator)(whoAmI).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				var p auth.Principal
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+				assert.Equal(t, auth.Principal{ID: "user-1", Name: "Alice", Method: auth.MethodJWT, Roles: []string{"sales"}}, p)
+			}
+		})
+	}
+}
+
+func TestAuthenticationChain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	chain := auth.Chain(auth.NewAPIKeyAuthenticator(db), &auth.JWTAuthenticator{Secret: []byte("s")})
+
+	// No credentials at all: no authenticator applies.
+	w := httptest.NewRecorder()
+	auth.Middleware(chain)(whoAmI).ServeHTTP(w, httptest.NewRequest("GET", "/offers", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+
+	// A bearer token skips the API key lookup.
+	req := httptest.NewRequest("GET", "/offers", nil)
+	req.Header.Set("Authorization", "Bearer "+signJWT(t, "HS256", "", map[string]interface{}{"sub": "svc"}, hs256([]byte("s"))))
+	w = httptest.NewRecorder()
+	auth.Middleware(chain)(whoAmI).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		roles      []string
+		permission string
+		wantStatus int
+	}{
+		{name: "catalog admin writes materials", roles: []string{auth.RoleCatalogAdmin}, permission: auth.PermMaterialsWrite, wantStatus: http.StatusOK},
+		{name: "sales cannot write materials", roles: []string{auth.RoleSales}, permission: auth.PermMaterialsWrite, wantStatus: http.StatusForbidden},
+		{name: "sales writes offers", roles: []string{auth.RoleSales}, permission: auth.PermOffersWrite, wantStatus: http.StatusOK},
+		{name: "catalog admin cannot write offers", roles: []string{auth.RoleCatalogAdmin}, permission: auth.PermOffersWrite, wantStatus: http.StatusForbidden},
+		{name: "viewer reads offers", roles: []string{auth.RoleViewer}, permission: auth.PermOffersRead, wantStatus: http.StatusOK},
+		{name: "viewer cannot approve", roles: []string{auth.RoleViewer, "unknown"}, permission: auth.PermOffersApprove, wantStatus: http.StatusForbidden},
+		{name: "admin manages keys", roles: []string{auth.RoleAdmin}, permission: auth.PermAPIKeysManage, wantStatus: http.StatusOK},
+		{name: "roles combine", roles: []string{auth.RoleSales, auth.RoleCatalogAdmin}, permission: auth.PermMaterialsWrite, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/materials", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{ID: "u", Roles: tt.roles}))
+			w := httptest.NewRecorder()
+
+			auth.RequirePermission(tt.permission)(whoAmI).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), tt.permission)
+			}
+		})
+	}
+}
+
+func TestRequirePermissionWithoutPrincipal(t *testing.T) {
+	w := httptest.NewRecorder()
+	auth.RequirePermission(auth.PermOffersRead)(whoAmI).ServeHTTP(w, httptest.NewRequest("GET", "/offers", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO api_key (name, key_hash, key_prefix, roles, expires_at, tenant_id)`)).
+		WithArgs("erp", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+	req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(`{"name":"erp","roles":["sales"],"tenant":"acme"}`))
+	w := httptest.NewRecorder()
+
+	CreateAPIKey(db).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created models.APIKey
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, 3, created.ID)
+	assert.True(t, strings.HasPrefix(created.Key, auth.APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateAPIKeyRequiresName(t *testing.T) {
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(`{"name":"  "}`))
+	w := httptest.NewRecorder()
+
+	CreateAPIKey(db).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateAPIKeyTenant(t *testing.T) {
+	insert := regexp.QuoteMeta(`INSERT INTO api_key (name, key_hash, key_prefix, roles, expires_at, tenant_id)`)
+
+	testCases := []struct {
+		name         string
+		caller       auth.Principal
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "bound caller binds the key to its tenant",
+			caller:       auth.Principal{ID: "k", Roles: []string{auth.RoleAdmin}, Tenant: "acme"},
+			requestBody:  `{"name":"erp","roles":["sales"],"tenant":"globex"}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(insert).WithArgs("erp", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+			},
+		},
+		{
+			name:         "platform-admin key without tenant",
+			caller:       auth.Principal{ID: "ops", Roles: []string{auth.RolePlatformAdmin}},
+			requestBody:  `{"name":"ops","roles":["platform-admin"]}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(insert).WithArgs("ops", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+			},
+		},
+		{
+			name:         "failure - key without tenant",
+			caller:       auth.Principal{ID: "ops", Roles: []string{auth.RolePlatformAdmin}},
+			requestBody:  `{"name":"erp","roles":["sales"]}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - bound caller creating a platform-admin key",
+			caller:       auth.Principal{ID: "k", Roles: []string{auth.RoleAdmin}, Tenant: "acme"},
+			requestBody:  `{"name":"ops","roles":["platform-admin"]}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(tc.requestBody))
+			req = req.WithContext(auth.WithPrincipal(req.Context(), tc.caller))
+			w := httptest.NewRecorder()
+
+			CreateAPIKey(db).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// bomCycleQuery reports whether material $1 is component $2 itself or is
+// reachable from it in tenant $3, i.e. whether adding $2 to $1 would close
+// a cycle.
+const bomCycleQuery = `WITH RECURSIVE below AS (
+		SELECT $2::int AS id
+		UNION
+		SELECT c.component_id FROM material_component c JOIN below b ON c.assembly_id = b.id WHERE c.tenant_id = $3 AND c.deleted_at IS NULL
+	) SELECT EXISTS (SELECT 1 FROM below WHERE id = $1)`
+
+// offerExplosionQuery expands offer lines through the BOM and sums the
+// leaf materials. The path guard stops the recursion on a cycle.
+const offerExplosionQuery = `WITH RECURSIVE exploded AS (
+		SELECT om.material_id, om.quantity::numeric AS quantity, ARRAY[om.material_id] AS path
+		FROM offer_material om WHERE om.offer_id = $1 AND om.tenant_id = $2 AND om.deleted_at IS NULL
+		UNION ALL
+		SELECT c.component_id, e.quantity * c.quantity, e.path || c.component_id
+		FROM exploded e JOIN material_component c ON c.assembly_id = e.material_id AND c.tenant_id = $2 AND c.deleted_at IS NULL
+		WHERE NOT c.component_id = ANY(e.path)
+	)
+	SELECT e.material_id, m.name, SUM(e.quantity)
+	FROM exploded e JOIN material m ON m.id = e.material_id
+	WHERE NOT EXISTS (SELECT 1 FROM material_component c WHERE c.assembly_id = e.material_id AND c.tenant_id = $2 AND c.deleted_at IS NULL)
+	GROUP BY e.material_id, m.name
+	ORDER BY e.material_id`
+
+func GetMaterialComponents(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT c.id, c.assembly_id, c.component_id, m.name, c.quantity, c.created_at, c.updated_at, c.deleted_at
+			FROM material_component c JOIN material m ON m.id = c.component_id
+			WHERE c.assembly_id = $1 AND c.tenant_id = $2 AND c.deleted_at IS NULL
+			ORDER BY m.name, c.id`, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		components := []models.MaterialComponent{}
+		for rows.Next() {
+			var component models.MaterialComponent
+			if err := rows.Scan(&component.ID, &component.AssemblyID, &component.ComponentID, &component.ComponentName, &component.Quantity, &component.CreatedAt, &component.UpdatedAt, &component.DeletedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			components = append(components, component)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(components)
+	}
+}
+
+// CreateMaterialComponent adds a component to the assembly in the URL. The
+// table is locked for the check so two concurrent additions cannot close
+// a cycle between them.
+func CreateMaterialComponent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		assemblyID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var component models.MaterialComponent
+		if err := json.NewDecoder(r.Body).Decode(&component); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		component.AssemblyID = assemblyID
+		if component.Quantity <= 0 {
+			http.Error(w, "quantity must be positive", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE material_component IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", assemblyID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", component.ComponentID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "component material not found", http.StatusBadRequest)
+			return
+		}
+
+		var cycle bool
+		if err := tx.QueryRowContext(ctx, bomCycleQuery, assemblyID, component.ComponentID, tenantID).Scan(&cycle); err != nil {
+			writeError(w, err)
+			return
+		}
+		if cycle {
+			http.Error(w, "component would create a cycle in the bill of materials", http.StatusBadRequest)
+			return
+		}
+
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM material_component WHERE assembly_id = $1 AND component_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)", assemblyID, component.ComponentID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if exists {
+			http.Error(w, "component already part of this assembly", http.StatusConflict)
+			return
+		}
+
+		err = tx.QueryRowContext(ctx, "INSERT INTO material_component (assembly_id, component_id, quantity, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at",
+			assemblyID, component.ComponentID, component.Quantity, tenantID).
+			Scan(&component.ID, &component.CreatedAt, &component.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(component)
+	}
+}
+
+// UpdateMaterialComponent changes the quantity of a BOM line. To swap the
+// component, delete the line and add a new one.
+func UpdateMaterialComponent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var component models.MaterialComponent
+		if err := json.NewDecoder(r.Body).Decode(&component); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if component.Quantity <= 0 {
+			http.Error(w, "quantity must be positive", http.StatusBadRequest)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), `UPDATE material_component SET quantity = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL
+			RETURNING id, assembly_id, component_id, created_at, updated_at`, component.Quantity, id, tenant.FromContext(r.Context())).
+			Scan(&component.ID, &component.AssemblyID, &component.ComponentID, &component.CreatedAt, &component.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Material component not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(component)
+	}
+}
+
+func DeleteMaterialComponent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), `UPDATE material_component SET deleted_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Material component not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ExplodeOffer breaks an offer's lines down to leaf materials.
+func ExplodeOffer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), offerExplosionQuery, id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		explosion := models.OfferExplosion{OfferID: id, Lines: []models.ExplodedLine{}}
+		for rows.Next() {
+			var line models.ExplodedLine
+			if err := rows.Scan(&line.MaterialID, &line.MaterialName, &line.Quantity); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			explosion.Lines = append(explosion.Lines, line)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(explosion)
+	}
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateMaterialComponent(t *testing.T) {
+	lock := regexp.QuoteMeta(`LOCK TABLE material_component IN SHARE ROW EXCLUSIVE MODE`)
+	materialExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	cycle := regexp.QuoteMeta(`WITH RECURSIVE below AS (`)
+	duplicate := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM material_component WHERE assembly_id = $1 AND component_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)`)
+	insert := regexp.QuoteMeta(`INSERT INTO material_component (assembly_id, component_id, quantity, tenant_id) VALUES ($1, $2, $3, $4)`)
+
+	expectMaterials := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectExec(lock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(materialExists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(materialExists).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	}
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - component added",
+			requestBody:  `{"component_id": 2, "quantity": 4}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectMaterials(mock)
+				mock.ExpectQuery(cycle).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(duplicate).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(insert).WithArgs(1, 2, 4.0, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - cycle",
+			requestBody:  `{"component_id": 2, "quantity": 1}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectMaterials(mock)
+				mock.ExpectQuery(cycle).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - already a component",
+			requestBody:  `{"component_id": 2, "quantity": 1}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectMaterials(mock)
+				mock.ExpectQuery(cycle).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(duplicate).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - quantity missing",
+			requestBody:  `{"component_id": 2}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/materials/1/components", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := CreateMaterialComponent(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestExplodeOffer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)).
+		WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`WITH RECURSIVE exploded AS (`)).WithArgs(1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"material_id", "name", "quantity"}).
+			AddRow(2, "Screw", 16.0).
+			AddRow(3, "Panel", 2.0))
+
+	req := withTenant(httptest.NewRequest("GET", "/offers/1/explode", nil), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	handler := ExplodeOffer(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var explosion models.OfferExplosion
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&explosion))
+	assert.Equal(t, 1, explosion.OfferID)
+	assert.Len(t, explosion.Lines, 2)
+	assert.Equal(t, 16.0, explosion.Lines[0].Quantity)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const categoryColumns = `id, name, parent_id, created_at, updated_at, deleted_at`
+
+func scanCategory(row interface{ Scan(...interface{}) error }, c *models.Category) error {
+	return row.Scan(&c.ID, &c.Name, &c.ParentID, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+}
+
+// categorySubtreeQuery selects the id of category $n and of every
+// non-deleted category below it in the tenant $t.
+func categorySubtreeQuery(n, t int) string {
+	return fmt.Sprintf(`WITH RECURSIVE subtree AS (
+		SELECT id FROM category WHERE id = $%[1]d AND tenant_id = $%[2]d AND deleted_at IS NULL
+		UNION
+		SELECT c.id FROM category c JOIN subtree s ON c.parent_id = s.id WHERE c.tenant_id = $%[2]d AND c.deleted_at IS NULL
+	) SELECT id FROM subtree`, n, t)
+}
+
+// buildCategoryTree nests categories under their parents. Categories whose
+// parent is not in the list become roots.
+func buildCategoryTree(categories []models.Category) []models.Category {
+	children := map[int][]int{}
+	present := map[int]bool{}
+	for _, category := range categories {
+		present[category.ID] = true
+	}
+	roots := []int{}
+	for i, category := range categories {
+		if category.ParentID != nil && present[*category.ParentID] {
+			children[*category.ParentID] = append(children[*category.ParentID], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+
+	var build func(i int) models.Category
+	build = func(i int) models.Category {
+		category := categories[i]
+		for _, child := range children[category.ID] {
+			category.Children = append(category.Children, build(child))
+		}
+		return category
+	}
+
+	tree := []models.Category{}
+	for _, i := range roots {
+		tree = append(tree, build(i))
+	}
+	return tree
+}
+
+func GetCategories(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		asTree := false
+		if value := r.URL.Query().Get("tree"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "invalid tree", http.StatusBadRequest)
+				return
+			}
+			asTree = parsed
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT "+categoryColumns+" FROM category WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY name, id", tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		categories := []models.Category{}
+		for rows.Next() {
+			var category models.Category
+			if err := scanCategory(rows, &category); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			categories = append(categories, category)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		if asTree {
+			categories = buildCategoryTree(categories)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(categories)
+	}
+}
+
+func GetCategoryByID(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		var category models.Category
+		err := scanCategory(db.QueryRowContext(r.Context(), "SELECT "+categoryColumns+" FROM category WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context())), &category)
+		if err != nil {
+			http.Error(w, "Category not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(category)
+	}
+}
+
+// checkCategoryParent validates a parent for category id (0 when creating).
+// It returns a client error message, or "" when the parent is acceptable.
+func checkCategoryParent(ctx context.Context, db *sql.DB, id int, parentID *int) (string, error) {
+	if parentID == nil {
+		return "", nil
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM category WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", *parentID, tenantID).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "parent category not found", nil
+	}
+	if id == 0 {
+		return "", nil
+	}
+
+	var cycle bool
+	err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM ("+categorySubtreeQuery(1, 2)+") s WHERE s.id = $3)", id, tenantID, *parentID).Scan(&cycle)
+	if err != nil {
+		return "", err
+	}
+	if cycle {
+		return "category cannot be moved below itself", nil
+	}
+	return "", nil
+}
+
+func CreateCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var category models.Category
+		if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category.Name = strings.TrimSpace(category.Name)
+		if category.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		msg, err := checkCategoryParent(r.Context(), db, 0, category.ParentID)
+		if err != nil {
+			log.Printf("Error checking category parent: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), "INSERT INTO category (name, parent_id, tenant_id) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at", category.Name, category.ParentID, tenant.FromContext(r.Context())).
+			Scan(&category.ID, &category.CreatedAt, &category.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(category)
+	}
+}
+
+func UpdateCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var category models.Category
+		if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category.ID = id
+		category.Name = strings.TrimSpace(category.Name)
+		if category.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		msg, err := checkCategoryParent(r.Context(), db, id, category.ParentID)
+		if err != nil {
+			log.Printf("Error checking category parent: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE category SET name = $1, parent_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 AND deleted_at IS NULL",
+			category.Name, category.ParentID, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Category not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(category)
+	}
+}
+
+// DeleteCategory soft deletes an empty category. Categories that still
+// have subcategories or materials are refused with 409.
+func DeleteCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var inUse bool
+		err = db.QueryRowContext(r.Context(), `SELECT EXISTS (SELECT 1 FROM category WHERE parent_id = $1 AND tenant_id = $2 AND deleted_at IS NULL)
+			OR EXISTS (SELECT 1 FROM material WHERE category_id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`, id, tenantID).Scan(&inUse)
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if inUse {
+			http.Error(w, "category still has subcategories or materials", http.StatusConflict)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE category SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Category not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCategoriesTree(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, parent_id, created_at, updated_at, deleted_at FROM category WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY name, id`)).WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "parent_id", "created_at", "updated_at", "deleted_at"}).
+			AddRow(3, "Copper", 2, time.Now(), time.Now(), nil).
+			AddRow(1, "Materials", nil, time.Now(), time.Now(), nil).
+			AddRow(2, "Metals", 1, time.Now(), time.Now(), nil).
+			AddRow(4, "Steel", 2, time.Now(), time.Now(), nil).
+			AddRow(5, "Wood", 1, time.Now(), time.Now(), nil))
+
+	req := withTenant(httptest.NewRequest("GET", "/categories?tree=true", nil), "acme")
+	w := httptest.NewRecorder()
+
+	handler := GetCategories(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tree []models.Category
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&tree))
+	assert.Len(t, tree, 1)
+	assert.Equal(t, "Materials", tree[0].Name)
+	assert.Len(t, tree[0].Children, 2)
+	assert.Equal(t, "Metals", tree[0].Children[0].Name)
+	assert.Len(t, tree[0].Children[0].Children, 2)
+	assert.Equal(t, "Wood", tree[0].Children[1].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateCategory(t *testing.T) {
+	parentExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM category WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	cycle := `SELECT EXISTS \(SELECT 1 FROM \(WITH RECURSIVE subtree AS`
+	update := regexp.QuoteMeta(`UPDATE category SET name = $1, parent_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 AND deleted_at IS NULL`)
+
+	testCases := []struct {
+		name         string
+		categoryID   string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - moved under new parent",
+			categoryID:   "2",
+			requestBody:  `{"name": "Metals", "parent_id": 5}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(parentExists).WithArgs(5, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(cycle).WithArgs(2, "acme", 5).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(update).WithArgs("Metals", 5, 2, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:         "failure - moved below its own descendant",
+			categoryID:   "1",
+			requestBody:  `{"name": "Materials", "parent_id": 4}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(parentExists).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(cycle).WithArgs(1, "acme", 4).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+		{
+			name:         "failure - parent not found",
+			categoryID:   "1",
+			requestBody:  `{"name": "Materials", "parent_id": 99}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(parentExists).WithArgs(99, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+		{
+			name:         "failure - missing name",
+			categoryID:   "1",
+			requestBody:  `{"name": " "}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - category not found",
+			categoryID:   "42",
+			requestBody:  `{"name": "Top level"}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(update).WithArgs("Top level", nil, 42, "acme").WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("PUT", "/categories/"+tc.categoryID, strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": tc.categoryID})
+			w := httptest.NewRecorder()
+
+			handler := UpdateCategory(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	inUse := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM category WHERE parent_id = $1 AND tenant_id = $2 AND deleted_at IS NULL)
+			OR EXISTS (SELECT 1 FROM material WHERE category_id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	remove := regexp.QuoteMeta(`UPDATE category SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+
+	testCases := []struct {
+		name         string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - empty category deleted",
+			expectedCode: http.StatusNoContent,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(inUse).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(remove).WithArgs(1, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:         "failure - category in use",
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(inUse).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+		{
+			name:         "failure - database error",
+			expectedCode: http.StatusInternalServerError,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(inUse).WithArgs(1, "acme").WillReturnError(errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("DELETE", "/categories/1", nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := DeleteCategory(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetMaterialsCategoryFilter(t *testing.T) {
+	testCases := []struct {
+		name         string
+		url          string
+		expectedCode int
+		query        string
+	}{
+		{
+			name:         "direct category",
+			url:          "/materials?category=2",
+			expectedCode: http.StatusOK,
+			query:        regexp.QuoteMeta(`SELECT * FROM material WHERE deleted_at IS NULL AND tenant_id = $1 AND category_id = $2`),
+		},
+		{
+			name:         "category with descendants",
+			url:          "/materials?category=2&include_descendants=true",
+			expectedCode: http.StatusOK,
+			query:        `SELECT \* FROM material WHERE deleted_at IS NULL AND tenant_id = \$1 AND category_id IN \(WITH RECURSIVE subtree AS`,
+		},
+		{
+			name:         "failure - invalid category",
+			url:          "/materials?category=metals",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			if tc.query != "" {
+				mock.ExpectQuery(tc.query).WithArgs("acme", 2).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at", "category_id", "tenant_id"}).
+						AddRow(1, "Steel", true, time.Now(), time.Now(), nil, 2, "acme"))
+				expectMaterialMetadata(mock)
+			}
+
+			req := withTenant(httptest.NewRequest("GET", tc.url, nil), "acme")
+			w := httptest.NewRecorder()
+
+			handler := GetMaterials(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const offerCommentColumns = `id, offer_id, offer_material_id, parent_id, author_id, author_name, body, created_at, updated_at, deleted_at`
+
+func scanOfferComment(row interface{ Scan(...interface{}) error }, c *models.OfferComment) error {
+	return row.Scan(&c.ID, &c.OfferID, &c.OfferMaterialID, &c.ParentID, &c.AuthorID, &c.AuthorName, &c.Body, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+}
+
+// commentThreads nests comments under their parents, oldest first. Deleted
+// comments are dropped unless they have replies, in which case they keep
+// their place without their body.
+func commentThreads(comments []models.OfferComment) []models.OfferComment {
+	children := map[int][]models.OfferComment{}
+	for _, comment := range comments {
+		parent := 0
+		if comment.ParentID != nil {
+			parent = *comment.ParentID
+		}
+		children[parent] = append(children[parent], comment)
+	}
+
+	var build func(parent int) []models.OfferComment
+	build = func(parent int) []models.OfferComment {
+		thread := []models.OfferComment{}
+		for _, comment := range children[parent] {
+			comment.Replies = build(comment.ID)
+			if comment.DeletedAt != nil {
+				if len(comment.Replies) == 0 {
+					continue
+				}
+				comment.Body = ""
+			}
+			thread = append(thread, comment)
+		}
+		return thread
+	}
+	return build(0)
+}
+
+// parseCommentIDs reads the offer and comment IDs from the URL.
+func parseCommentIDs(r *http.Request) (int, int, error) {
+	vars := mux.Vars(r)
+	offerID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return 0, 0, badRequest("invalid id")
+	}
+	commentID, err := strconv.Atoi(vars["comment_id"])
+	if err != nil {
+		return 0, 0, badRequest("invalid comment id")
+	}
+	return offerID, commentID, nil
+}
+
+// checkCommentAuthor makes sure the comment exists on the offer and was
+// written by the caller, since only authors edit or delete comments.
+func checkCommentAuthor(ctx context.Context, db *sql.DB, offerID, commentID int) error {
+	var author string
+	err := db.QueryRowContext(ctx, "SELECT author_id FROM offer_comment WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL",
+		commentID, offerID, tenant.FromContext(ctx)).Scan(&author)
+	if err == sql.ErrNoRows {
+		return notFound("Comment not found")
+	}
+	if err != nil {
+		return err
+	}
+	if p, _ := auth.FromContext(ctx); p.ID != author {
+		return forbidden("forbidden: comment belongs to %s", author)
+	}
+	return nil
+}
+
+// decodeCommentBody reads a comment and trims its body, which is required.
+func decodeCommentBody(r *http.Request) (models.OfferComment, error) {
+	var comment models.OfferComment
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		return comment, badRequest("%s", err.Error())
+	}
+	comment.Body = strings.TrimSpace(comment.Body)
+	if comment.Body == "" {
+		return comment, badRequest("body is required")
+	}
+	return comment, nil
+}
+
+// GetOfferComments returns the comment threads of an offer. ?line= limits
+// them to the threads on one offer line.
+func GetOfferComments(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var line int
+		if value := r.URL.Query().Get("line"); value != "" {
+			if line, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "invalid line", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		var exists bool
+		err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(ctx, "SELECT "+offerCommentColumns+" FROM offer_comment WHERE offer_id = $1 AND tenant_id = $2 ORDER BY id", id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		comments := []models.OfferComment{}
+		for rows.Next() {
+			var comment models.OfferComment
+			if err := scanOfferComment(rows, &comment); err != nil {
+				writeError(w, err)
+				return
+			}
+			comments = append(comments, comment)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		threads := commentThreads(comments)
+		if line != 0 {
+			filtered := []models.OfferComment{}
+			for _, thread := range threads {
+				if thread.OfferMaterialID != nil && *thread.OfferMaterialID == line {
+					filtered = append(filtered, thread)
+				}
+			}
+			threads = filtered
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(threads)
+	}
+}
+
+// CreateOfferComment adds a comment to an offer. A reply sets parent_id and
+// is always on the line of the comment it answers; a new thread may set
+// offer_material_id to discuss one line.
+func CreateOfferComment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		comment, err := decodeCommentBody(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		comment.OfferID, comment.Replies = id, []models.OfferComment{}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		var exists bool
+		err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+
+		if comment.ParentID != nil {
+			err = db.QueryRowContext(ctx, "SELECT offer_material_id FROM offer_comment WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL",
+				*comment.ParentID, id, tenantID).Scan(&comment.OfferMaterialID)
+			if err == sql.ErrNoRows {
+				http.Error(w, "parent comment not found", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		} else if comment.OfferMaterialID != nil {
+			err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM offer_material WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)",
+				*comment.OfferMaterialID, id, tenantID).Scan(&exists)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if !exists {
+				http.Error(w, "offer line not found", http.StatusBadRequest)
+				return
+			}
+		}
+
+		p, _ := auth.FromContext(ctx)
+		comment.AuthorID, comment.AuthorName = p.ID, p.Name
+		err = db.QueryRowContext(ctx, `INSERT INTO offer_comment (offer_id, offer_material_id, parent_id, author_id, author_name, body, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at`,
+			id, comment.OfferMaterialID, comment.ParentID, comment.AuthorID, comment.AuthorName, comment.Body, tenantID).
+			Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(comment)
+	}
+}
+
+// UpdateOfferComment changes the body of the caller's own comment.
+func UpdateOfferComment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offerID, commentID, err := parseCommentIDs(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		input, err := decodeCommentBody(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkCommentAuthor(r.Context(), db, offerID, commentID); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		comment := models.OfferComment{Replies: []models.OfferComment{}}
+		err = scanOfferComment(db.QueryRowContext(r.Context(), `UPDATE offer_comment SET body = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND offer_id = $3 AND tenant_id = $4 AND deleted_at IS NULL
+			RETURNING `+offerCommentColumns, input.Body, commentID, offerID, tenant.FromContext(r.Context())), &comment)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(comment)
+	}
+}
+
+// DeleteOfferComment soft deletes the caller's own comment. Its replies
+// stay in the thread.
+func DeleteOfferComment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offerID, commentID, err := parseCommentIDs(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkCommentAuthor(r.Context(), db, offerID, commentID); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE offer_comment SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL",
+			commentID, offerID, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
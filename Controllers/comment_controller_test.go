@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	offerExistsQuery       = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	offerCommentRowColumns = []string{"id", "offer_id", "offer_material_id", "parent_id", "author_id", "author_name", "body", "created_at", "updated_at", "deleted_at"}
+)
+
+func TestGetOfferComments(t *testing.T) {
+	now := time.Now()
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(offerCommentRowColumns).
+			AddRow(1, 1, nil, nil, "user-1", "Ann", "Can we ship in May?", now, now, nil).
+			AddRow(2, 1, 5, nil, "user-2", "Bob", "Wrong gauge", now, now, now).
+			AddRow(3, 1, 5, 2, "user-1", "Ann", "Fixed", now, now, nil).
+			AddRow(4, 1, nil, 1, "user-2", "Bob", "Yes", now, now, nil).
+			AddRow(5, 1, nil, nil, "user-2", "Bob", "Typo", now, now, now)
+	}
+
+	testCases := []struct {
+		name      string
+		url       string
+		wantRoots []int
+	}{
+		{name: "threads with deleted leaves dropped", url: "/offers/1/comments", wantRoots: []int{1, 2}},
+		{name: "threads on one line", url: "/offers/1/comments?line=5", wantRoots: []int{2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery(offerExistsQuery).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM offer_comment WHERE offer_id = $1 AND tenant_id = $2 ORDER BY id`)).WithArgs(1, "acme").WillReturnRows(rows())
+
+			req := withTenant(httptest.NewRequest("GET", tc.url, nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := GetOfferComments(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var threads []models.OfferComment
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&threads))
+			roots := []int{}
+			for _, thread := range threads {
+				roots = append(roots, thread.ID)
+				assert.Len(t, thread.Replies, 1)
+				if thread.ID == 2 {
+					assert.Equal(t, "", thread.Body)
+				}
+			}
+			assert.Equal(t, tc.wantRoots, roots)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateOfferComment(t *testing.T) {
+	insert := regexp.QuoteMeta(`INSERT INTO offer_comment (offer_id, offer_material_id, parent_id, author_id, author_name, body, tenant_id)`)
+	parentQuery := regexp.QuoteMeta(`SELECT offer_material_id FROM offer_comment WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL`)
+	lineQuery := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM offer_material WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)`)
+	inserted := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(9, time.Now(), time.Now())
+	}
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - comment on a line",
+			requestBody:  `{"body": " Check stock ", "offer_material_id": 5}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(offerExistsQuery).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(lineQuery).WithArgs(5, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(insert).WithArgs(1, 5, nil, "user-1", "", "Check stock", "acme").WillReturnRows(inserted())
+			},
+		},
+		{
+			name:         "success - reply takes the line of its parent",
+			requestBody:  `{"body": "Done", "parent_id": 2, "offer_material_id": 8}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(offerExistsQuery).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(parentQuery).WithArgs(2, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"offer_material_id"}).AddRow(5))
+				mock.ExpectQuery(insert).WithArgs(1, 5, 2, "user-1", "", "Done", "acme").WillReturnRows(inserted())
+			},
+		},
+		{
+			name:         "failure - line of another offer",
+			requestBody:  `{"body": "Check stock", "offer_material_id": 7}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(offerExistsQuery).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(lineQuery).WithArgs(7, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+		{
+			name:         "failure - empty body",
+			requestBody:  `{"body": "  "}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := asPrincipal(httptest.NewRequest("POST", "/offers/1/comments", strings.NewReader(tc.requestBody)), "user-1", auth.RoleSales)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := CreateOfferComment(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateOfferCommentAuthorOnly(t *testing.T) {
+	author := regexp.QuoteMeta(`SELECT author_id FROM offer_comment WHERE id = $1 AND offer_id = $2 AND tenant_id = $3 AND deleted_at IS NULL`)
+	update := regexp.QuoteMeta(`UPDATE offer_comment SET body = $1, updated_at = CURRENT_TIMESTAMP`)
+
+	testCases := []struct {
+		name         string
+		principal    string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - author edits",
+			principal:    "user-1",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(author).WithArgs(3, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow("user-1"))
+				mock.ExpectQuery(update).WithArgs("Edited", 3, 1, "acme").
+					WillReturnRows(sqlmock.NewRows(offerCommentRowColumns).AddRow(3, 1, nil, nil, "user-1", "Ann", "Edited", time.Now(), time.Now(), nil))
+			},
+		},
+		{
+			name:         "failure - someone else",
+			principal:    "user-2",
+			expectedCode: http.StatusForbidden,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(author).WithArgs(3, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow("user-1"))
+			},
+		},
+		{
+			name:         "failure - not found",
+			principal:    "user-1",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(author).WithArgs(3, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"author_id"}))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := asPrincipal(httptest.NewRequest("PUT", "/offers/1/comments/3", strings.NewReader(`{"body": "Edited"}`)), tc.principal, auth.RoleSales)
+			req = mux.SetURLVars(req, map[string]string{"id": "1", "comment_id": "3"})
+			w := httptest.NewRecorder()
+
+			handler := UpdateOfferComment(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const customerColumns = `id, name, email, phone, tenant_id, created_at, updated_at, deleted_at`
+
+const contactColumns = `id, customer_id, name, email, phone, created_at, updated_at, deleted_at`
+
+func scanCustomer(row interface{ Scan(...interface{}) error }, c *models.Customer) error {
+	return row.Scan(&c.ID, &c.Name, &c.Email, &c.Phone, &c.TenantID, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+}
+
+func scanContact(row interface{ Scan(...interface{}) error }, c *models.Contact) error {
+	return row.Scan(&c.ID, &c.CustomerID, &c.Name, &c.Email, &c.Phone, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+}
+
+// checkCustomer verifies that an offer's customer, if it has one, exists in
+// the request's tenant.
+func checkCustomer(ctx context.Context, db *sql.DB, customerID *int) error {
+	if customerID == nil {
+		return nil
+	}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", *customerID, tenant.FromContext(ctx)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return badRequest("customer not found")
+	}
+	return nil
+}
+
+func GetCustomers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT "+customerColumns+" FROM customer WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY name, id", tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		customers := []models.Customer{}
+		for rows.Next() {
+			var customer models.Customer
+			if err := scanCustomer(rows, &customer); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			customers = append(customers, customer)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(customers)
+	}
+}
+
+// loadContacts lists the contacts of a customer by name.
+func loadContacts(ctx context.Context, db *sql.DB, customerID int) ([]models.Contact, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+contactColumns+" FROM contact WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL ORDER BY name, id",
+		customerID, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []models.Contact{}
+	for rows.Next() {
+		var contact models.Contact
+		if err := scanContact(rows, &contact); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// GetCustomerByID returns a customer with its contacts.
+func GetCustomerByID(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var customer models.Customer
+		err = scanCustomer(db.QueryRowContext(r.Context(), "SELECT "+customerColumns+" FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context())), &customer)
+		if err != nil {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+		if customer.Contacts, err = loadContacts(r.Context(), db, id); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(customer)
+	}
+}
+
+func CreateCustomer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var customer models.Customer
+		if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		customer.Name = strings.TrimSpace(customer.Name)
+		if customer.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		customer.TenantID, customer.Contacts = tenant.FromContext(r.Context()), nil
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		err = tx.QueryRowContext(r.Context(), "INSERT INTO customer (name, email, phone, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at",
+			customer.Name, customer.Email, customer.Phone, customer.TenantID).
+			Scan(&customer.ID, &customer.CreatedAt, &customer.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := enqueueEvent(r.Context(), tx, customer.TenantID, models.EntityCustomer, customer.ID, models.EventCustomerCreated, customer); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(customer)
+	}
+}
+
+func UpdateCustomer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var customer models.Customer
+		if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		customer.ID = id
+		customer.Name = strings.TrimSpace(customer.Name)
+		if customer.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		customer.TenantID, customer.Contacts = tenant.FromContext(r.Context()), nil
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		err = tx.QueryRowContext(r.Context(), `UPDATE customer SET name = $1, email = $2, phone = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4 AND tenant_id = $5 AND deleted_at IS NULL RETURNING created_at, updated_at`,
+			customer.Name, customer.Email, customer.Phone, id, customer.TenantID).
+			Scan(&customer.CreatedAt, &customer.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := enqueueEvent(r.Context(), tx, customer.TenantID, models.EntityCustomer, id, models.EventCustomerUpdated, customer); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(customer)
+	}
+}
+
+// DeleteCustomer soft deletes a customer together with its contacts. It
+// fails with 409 while open offers are addressed to the customer.
+func DeleteCustomer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(ctx, "UPDATE customer SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+
+		var open int
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM offer WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL AND status = ANY($3)",
+			id, tenantID, pq.Array(openOfferStatuses)).Scan(&open)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if open > 0 {
+			writeError(w, conflict("customer has %d open offers", open))
+			return
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE contact SET deleted_at = CURRENT_TIMESTAMP WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := enqueueEvent(ctx, tx, tenantID, models.EntityCustomer, id, models.EventCustomerDeleted, models.CustomerDeletedEvent{CustomerID: id}); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetCustomerOffers lists the offers addressed to a customer together with
+// their count and total per status.
+func GetCustomerOffers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		var exists bool
+		err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(ctx, "SELECT * FROM offer WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL ORDER BY id", id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		result := models.CustomerOffers{CustomerID: id, Offers: []models.Offer{}, Summary: []models.OfferStatusSummary{}}
+		for rows.Next() {
+			var offer models.Offer
+			if err := scanOffer(rows, &offer); err != nil {
+				writeError(w, err)
+				return
+			}
+			result.Offers = append(result.Offers, offer)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := attachOfferMetadata(r.Context(), db, result.Offers); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		summary, err := db.QueryContext(ctx, `SELECT o.status, COUNT(DISTINCT o.id), COALESCE(SUM(om.quantity * om.unit_price), 0)
+			FROM offer o LEFT JOIN offer_material om ON om.offer_id = o.id AND om.deleted_at IS NULL
+			WHERE o.customer_id = $1 AND o.tenant_id = $2 AND o.deleted_at IS NULL
+			GROUP BY o.status
+			ORDER BY o.status`, id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer summary.Close()
+
+		for summary.Next() {
+			var status models.OfferStatusSummary
+			if err := summary.Scan(&status.Status, &status.Count, &status.Total); err != nil {
+				writeError(w, err)
+				return
+			}
+			result.Summary = append(result.Summary, status)
+		}
+		if err := summary.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// GetCustomerContacts lists the contacts of the customer in the URL.
+func GetCustomerContacts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant.FromContext(r.Context())).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+
+		contacts, err := loadContacts(r.Context(), db, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contacts)
+	}
+}
+
+// CreateContact adds a contact to the customer in the URL.
+func CreateContact(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var contact models.Contact
+		if err := json.NewDecoder(r.Body).Decode(&contact); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contact.CustomerID = customerID
+		contact.Name = strings.TrimSpace(contact.Name)
+		if contact.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", customerID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), "INSERT INTO contact (customer_id, name, email, phone, tenant_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at",
+			customerID, contact.Name, contact.Email, contact.Phone, tenantID).
+			Scan(&contact.ID, &contact.CreatedAt, &contact.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(contact)
+	}
+}
+
+func UpdateContact(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var contact models.Contact
+		if err := json.NewDecoder(r.Body).Decode(&contact); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contact.ID = id
+		contact.Name = strings.TrimSpace(contact.Name)
+		if contact.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), `UPDATE contact SET name = $1, email = $2, phone = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4 AND tenant_id = $5 AND deleted_at IS NULL RETURNING customer_id, created_at, updated_at`,
+			contact.Name, contact.Email, contact.Phone, id, tenant.FromContext(r.Context())).
+			Scan(&contact.CustomerID, &contact.CreatedAt, &contact.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Contact not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contact)
+	}
+}
+
+func DeleteContact(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE contact SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Contact not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,269 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var customerExistsQuery = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+
+func TestGetCustomerByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, email, phone, tenant_id, created_at, updated_at, deleted_at FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)).
+		WithArgs(3, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "phone", "tenant_id", "created_at", "updated_at", "deleted_at"}).
+			AddRow(3, "Acme Builders", "office@acme.test", "", "acme", time.Now(), time.Now(), nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM contact WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL ORDER BY name, id`)).
+		WithArgs(3, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "name", "email", "phone", "created_at", "updated_at", "deleted_at"}).
+			AddRow(8, 3, "Jo Miller", "jo@acme.test", "555-0100", time.Now(), time.Now(), nil))
+
+	req := withTenant(httptest.NewRequest("GET", "/customers/3", nil), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	handler := GetCustomerByID(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var customer models.Customer
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&customer))
+	assert.Equal(t, "Acme Builders", customer.Name)
+	assert.Len(t, customer.Contacts, 1)
+	assert.Equal(t, "Jo Miller", customer.Contacts[0].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateCustomer(t *testing.T) {
+	insert := regexp.QuoteMeta(`INSERT INTO customer (name, email, phone, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`)
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - name trimmed",
+			requestBody:  `{"name": " Acme Builders ", "email": "office@acme.test"}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(insert).WithArgs("Acme Builders", "office@acme.test", "", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(3, time.Now(), time.Now()))
+				expectEvent(mock, "customer", "3", models.EventCustomerCreated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - missing name",
+			requestBody:  `{"name": " "}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/customers", strings.NewReader(tc.requestBody)), "acme")
+			w := httptest.NewRecorder()
+
+			handler := CreateCustomer(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateCustomer(t *testing.T) {
+	update := regexp.QuoteMeta(`UPDATE customer SET name = $1, email = $2, phone = $3, updated_at = CURRENT_TIMESTAMP`)
+
+	testCases := []struct {
+		name         string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - event enqueued",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(update).WithArgs("Acme Builders", "", "", 3, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+				expectEvent(mock, "customer", "3", models.EventCustomerUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - not found",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(update).WithArgs("Acme Builders", "", "", 3, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("PUT", "/customers/3", strings.NewReader(`{"name": "Acme Builders"}`)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "3"})
+			w := httptest.NewRecorder()
+
+			UpdateCustomer(db).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteCustomer(t *testing.T) {
+	softDelete := regexp.QuoteMeta(`UPDATE customer SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+	openOffers := regexp.QuoteMeta(`SELECT COUNT(*) FROM offer WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL AND status = ANY($3)`)
+	deleteContacts := regexp.QuoteMeta(`UPDATE contact SET deleted_at = CURRENT_TIMESTAMP WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+
+	testCases := []struct {
+		name         string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - contacts deleted too",
+			expectedCode: http.StatusNoContent,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(softDelete).WithArgs(3, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(openOffers).WithArgs(3, "acme", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectExec(deleteContacts).WithArgs(3, "acme").WillReturnResult(sqlmock.NewResult(0, 2))
+				expectEvent(mock, "customer", "3", models.EventCustomerDeleted)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - customer has open offers",
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(softDelete).WithArgs(3, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(openOffers).WithArgs(3, "acme", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - not found",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(softDelete).WithArgs(3, "acme").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("DELETE", "/customers/3", nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "3"})
+			w := httptest.NewRecorder()
+
+			handler := DeleteCustomer(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetCustomerOffers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(customerExistsQuery).WithArgs(3, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM offer WHERE customer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL ORDER BY id`)).
+		WithArgs(3, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at", "status", "valid_until", "tenant_id", "owner_id", "customer_id"}).
+			AddRow(1, "Roof", time.Now(), time.Now(), nil, "sent", nil, "acme", "user-1", 3).
+			AddRow(2, "Garage", time.Now(), time.Now(), nil, "sent", nil, "acme", nil, 3).
+			AddRow(4, "Fence", time.Now(), time.Now(), nil, "accepted", nil, "acme", "user-1", 3))
+	expectMetadata(mock, models.EntityOffer)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT o.status, COUNT(DISTINCT o.id), COALESCE(SUM(om.quantity * om.unit_price), 0)`)).
+		WithArgs(3, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count", "total"}).
+			AddRow("accepted", 1, 120.0).
+			AddRow("sent", 2, 480.5))
+
+	req := withTenant(httptest.NewRequest("GET", "/customers/3/offers", nil), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	handler := GetCustomerOffers(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result models.CustomerOffers
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Len(t, result.Offers, 3)
+	assert.Equal(t, []models.OfferStatusSummary{
+		{Status: "accepted", Count: 1, Total: 120},
+		{Status: "sent", Count: 2, Total: 480.5},
+	}, result.Summary)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateOfferUnknownCustomer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(customerExistsQuery).WithArgs(9, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	req := withTenant(httptest.NewRequest("POST", "/offers", strings.NewReader(`{"name": "Roof", "customer_id": 9}`)), "acme")
+	w := httptest.NewRecorder()
+
+	handler := CreateOffer(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "customer not found\n", w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"Products/documents"
+	"Products/models"
+	"Products/tenant"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// localizeQuote replaces the offer and material names of a quote with the
+// best translations for the request, as the JSON endpoints do.
+func localizeQuote(w http.ResponseWriter, r *http.Request, db *sql.DB, offerID int, name *string, lines []documents.QuoteLine) error {
+	offerNames, err := localizedNames(r, db, models.EntityOffer, []int{offerID})
+	if err != nil {
+		return err
+	}
+	ids := make([]int, len(lines))
+	for i, line := range lines {
+		ids[i] = line.MaterialID
+	}
+	materialNames, err := localizedNames(r, db, models.EntityMaterial, ids)
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	if translation, ok := offerNames[offerID]; ok {
+		*name = translation.Name
+	}
+	for i := range lines {
+		if translation, ok := materialNames[lines[i].MaterialID]; ok {
+			lines[i].MaterialName = translation.Name
+		}
+	}
+	return nil
+}
+
+// loadQuoteCustomer loads the customer an offer is addressed to, with its
+// contacts. A customer deleted since it was linked is left off the quote.
+func loadQuoteCustomer(ctx context.Context, db *sql.DB, customerID int) (*documents.QuoteCustomer, error) {
+	var customer documents.QuoteCustomer
+	err := db.QueryRowContext(ctx, "SELECT name, email, phone FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL",
+		customerID, tenant.FromContext(ctx)).Scan(&customer.Name, &customer.Email, &customer.Phone)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := loadContacts(ctx, db, customerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, contact := range contacts {
+		customer.Contacts = append(customer.Contacts, documents.QuoteContact{Name: contact.Name, Email: contact.Email, Phone: contact.Phone})
+	}
+	return &customer, nil
+}
+
+// GetOfferDocument renders the offer as a customer-facing quote, as HTML
+// (the default) or PDF, addressed to the offer's customer. Names are
+// translated like in the JSON endpoints, from ?lang= and Accept-Language.
+func GetOfferDocument(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = documents.FormatHTML
+		}
+		if format != documents.FormatHTML && format != documents.FormatPDF {
+			http.Error(w, "unsupported format", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			name       string
+			createdAt  time.Time
+			customerID sql.NullInt64
+		)
+		tenantID := tenant.FromContext(r.Context())
+		err = db.QueryRowContext(r.Context(), "SELECT name, created_at, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID).Scan(&name, &createdAt, &customerID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		var customer *documents.QuoteCustomer
+		if customerID.Valid {
+			if customer, err = loadQuoteCustomer(r.Context(), db, int(customerID.Int64)); err != nil {
+				log.Printf("Error querying database: %v", err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT m.id, m.name, om.quantity, om.unit_price
+			FROM offer_material om
+			JOIN material m ON m.id = om.material_id
+			WHERE om.offer_id = $1 AND om.tenant_id = $2 AND om.deleted_at IS NULL
+			ORDER BY om.id`, id, tenantID)
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		lines := []documents.QuoteLine{}
+		for rows.Next() {
+			var line documents.QuoteLine
+			if err := rows.Scan(&line.MaterialID, &line.MaterialName, &line.Quantity, &line.UnitPrice); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			lines = append(lines, line)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+		if err := localizeQuote(w, r, db, id, &name, lines); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// Render into a buffer first so a template error still yields a
+		// proper 500 instead of a half-written document.
+		quote := documents.NewQuote(id, name, createdAt, lines)
+		quote.Customer = customer
+		var buf bytes.Buffer
+		if err := documents.RenderQuote(&buf, format, quote); err != nil {
+			log.Printf("Error rendering quote: %v", err)
+			http.Error(w, "error rendering document", http.StatusInternalServerError)
+			return
+		}
+
+		if format == documents.FormatPDF {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="quote-%d.pdf"`, id))
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		buf.WriteTo(w)
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOfferDocument(t *testing.T) {
+	offerQuery := regexp.QuoteMeta(`SELECT name, created_at, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+	linesQuery := `SELECT m.id, m.name, om.quantity, om.unit_price\s+FROM offer_material om`
+
+	expectOfferWith := func(material string) func(mock sqlmock.Sqlmock) {
+		return func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(offerQuery).WithArgs(1, "acme").
+				WillReturnRows(sqlmock.NewRows([]string{"name", "created_at", "customer_id"}).AddRow("Roof <repair>", time.Now(), nil))
+			mock.ExpectQuery(linesQuery).WithArgs(1, "acme").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "quantity", "unit_price"}).
+					AddRow(5, material, 2.5, 10.0).
+					AddRow(6, "Screws", 100.0, 0.15))
+		}
+	}
+	expectOffer := expectOfferWith("Steel sheet")
+	expectOfferWithCustomer := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(offerQuery).WithArgs(1, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "created_at", "customer_id"}).AddRow("Roof repair", time.Now(), 3))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT name, email, phone FROM customer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)).WithArgs(3, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "email", "phone"}).AddRow("Smith & Sons", "office@smith.example", "030 1234"))
+		mock.ExpectQuery(`SELECT (.+) FROM contact WHERE customer_id = \$1`).WithArgs(3, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "name", "email", "phone", "created_at", "updated_at", "deleted_at"}).
+				AddRow(8, 3, "Anna Smith", "anna@smith.example", "", time.Now(), time.Now(), nil))
+		mock.ExpectQuery(linesQuery).WithArgs(1, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "quantity", "unit_price"}).AddRow(5, "Steel sheet", 2.5, 10.0))
+	}
+	translations := regexp.QuoteMeta(`SELECT DISTINCT ON (entity_id) entity_id, locale, name FROM name_translation`)
+
+	testCases := []struct {
+		name         string
+		offerID      string
+		query        string
+		expectedCode int
+		expectedType string
+		mockQueries  func(mock sqlmock.Sqlmock)
+		contains     []string
+	}{
+		{
+			name:         "success - html",
+			offerID:      "1",
+			expectedCode: http.StatusOK,
+			expectedType: "text/html; charset=utf-8",
+			mockQueries:  expectOffer,
+			contains:     []string{"Roof &lt;repair&gt;", "Steel sheet", "25.00", "15.00", "40.00"},
+		},
+		{
+			name:         "success - html with customer",
+			offerID:      "1",
+			expectedCode: http.StatusOK,
+			expectedType: "text/html; charset=utf-8",
+			mockQueries:  expectOfferWithCustomer,
+			contains:     []string{"Smith &amp; Sons", "office@smith.example", "030 1234", "Attn: Anna Smith", "anna@smith.example"},
+		},
+		{
+			name:         "success - pdf with customer",
+			offerID:      "1",
+			query:        "?format=pdf",
+			expectedCode: http.StatusOK,
+			expectedType: "application/pdf",
+			mockQueries:  expectOfferWithCustomer,
+			contains:     []string{"For: Smith & Sons", "Attn: Anna Smith"},
+		},
+		{
+			name:         "success - pdf",
+			offerID:      "1",
+			query:        "?format=pdf",
+			expectedCode: http.StatusOK,
+			expectedType: "application/pdf",
+			mockQueries:  expectOffer,
+			contains:     []string{"%PDF-1.4", "Steel sheet", "40.00", "%%EOF"},
+		},
+		{
+			name:         "success - pdf keeps the ellipsis of truncated names",
+			offerID:      "1",
+			query:        "?format=pdf",
+			expectedCode: http.StatusOK,
+			expectedType: "application/pdf",
+			mockQueries:  expectOfferWith("Galvanized steel sheet, 2mm, cut to length"),
+			contains:     []string{`Galvanized steel sheet, 2mm, cut\205`},
+		},
+		{
+			name:         "success - pdf with translated names",
+			offerID:      "1",
+			query:        "?format=pdf&lang=de",
+			expectedCode: http.StatusOK,
+			expectedType: "application/pdf",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectOffer(mock)
+				mock.ExpectQuery(translations).WithArgs("offer", "{1}", `{"de"}`).
+					WillReturnRows(sqlmock.NewRows([]string{"entity_id", "locale", "name"}).AddRow(1, "de", "Dachreparatur"))
+				mock.ExpectQuery(translations).WithArgs("material", "{5,6}", `{"de"}`).
+					WillReturnRows(sqlmock.NewRows([]string{"entity_id", "locale", "name"}).AddRow(5, "de", "Stahlblech"))
+			},
+			contains: []string{"Dachreparatur", "Stahlblech", "Screws"},
+		},
+		{
+			name:         "failure - offer not found",
+			offerID:      "1",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(offerQuery).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"name", "created_at", "customer_id"}))
+			},
+		},
+		{
+			name:         "failure - unsupported format",
+			offerID:      "1",
+			query:        "?format=docx",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("GET", "/offers/"+tc.offerID+"/document"+tc.query, nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": tc.offerID})
+			w := httptest.NewRecorder()
+
+			handler := GetOfferDocument(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedType != "" {
+				assert.Equal(t, tc.expectedType, w.Header().Get("Content-Type"))
+			}
+			for _, s := range tc.contains {
+				assert.True(t, strings.Contains(w.Body.String(), s), "body should contain %q", s)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
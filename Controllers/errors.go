@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// Partial unique indexes whose violations are reported with the record
+// already holding the key.
+const (
+	materialNameIndex  = "material_tenant_name_idx"
+	offerMaterialIndex = "offer_material_pair_idx"
+)
+
+// statusError is an error caused by the request, reported to the client
+// with its own status instead of as a 500.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusForbidden, msg: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+// isUniqueViolation reports whether err is a unique violation, of the
+// given index if one is named.
+func isUniqueViolation(err error, index string) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return false
+	}
+	return index == "" || pqErr.Constraint == index
+}
+
+// writeConflict answers 409 with the record that already holds the key.
+func writeConflict(w http.ResponseWriter, msg string, existing interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg, "existing": existing})
+}
+
+// writeError reports a statusError as is, a unique violation as 409 and
+// anything else as a logged database error.
+func writeError(w http.ResponseWriter, err error) {
+	var se *statusError
+	if errors.As(err, &se) {
+		http.Error(w, se.msg, se.status)
+		return
+	}
+	if isUniqueViolation(err, "") {
+		http.Error(w, "record already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("Error querying database: %v", err)
+	http.Error(w, "database error", http.StatusInternalServerError)
+}
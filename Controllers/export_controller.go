@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"Products/tenant"
+	"Products/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Offer exports have one row per offer_material line, with the material
+// expanded. Offers without lines still get a row with empty line columns.
+var offerExportColumns = []string{"offer_id", "offer_name", "offer_created_at", "offer_updated_at", "offer_material_id", "material_id", "material_name", "material_active", "quantity", "unit_price"}
+
+const offerExportQuery = `SELECT o.id, o.name, o.created_at, o.updated_at, om.id, m.id, m.name, m.active, om.quantity, om.unit_price
+	FROM offer o
+	LEFT JOIN offer_material om ON om.offer_id = o.id AND om.deleted_at IS NULL
+	LEFT JOIN material m ON m.id = om.material_id
+	WHERE o.deleted_at IS NULL`
+
+var materialExportColumns = []string{"id", "name", "active", "category_id", "created_at", "updated_at"}
+
+var offerMaterialExportColumns = []string{"id", "offer_id", "material_id", "quantity", "unit_price", "created_at", "updated_at"}
+
+func scanOfferExportRow(rows *sql.Rows) ([]interface{}, error) {
+	var (
+		offerID                int
+		offerName              string
+		createdAt, updatedAt   time.Time
+		offerMaterialID, matID *int
+		materialName           *string
+		materialActive         *bool
+		quantity, unitPrice    *float64
+	)
+	err := rows.Scan(&offerID, &offerName, &createdAt, &updatedAt, &offerMaterialID, &matID, &materialName, &materialActive, &quantity, &unitPrice)
+	return []interface{}{offerID, offerName, createdAt, updatedAt, offerMaterialID, matID, materialName, materialActive, quantity, unitPrice}, err
+}
+
+func scanMaterialExportRow(rows *sql.Rows) ([]interface{}, error) {
+	var (
+		id                   int
+		name                 string
+		active               bool
+		categoryID           *int
+		createdAt, updatedAt time.Time
+	)
+	err := rows.Scan(&id, &name, &active, &categoryID, &createdAt, &updatedAt)
+	return []interface{}{id, name, active, categoryID, createdAt, updatedAt}, err
+}
+
+func scanOfferMaterialExportRow(rows *sql.Rows) ([]interface{}, error) {
+	var (
+		id, offerID, materialID int
+		quantity, unitPrice     float64
+		createdAt, updatedAt    time.Time
+	)
+	err := rows.Scan(&id, &offerID, &materialID, &quantity, &unitPrice, &createdAt, &updatedAt)
+	return []interface{}{id, offerID, materialID, quantity, unitPrice, createdAt, updatedAt}, err
+}
+
+// streamExport writes each row as soon as it is scanned from the cursor,
+// so exports never hold the whole result set in memory. Once the first
+// byte is out the status can no longer change, so later errors are logged
+// and the response is cut short.
+func streamExport(w http.ResponseWriter, rows *sql.Rows, format, filename string, columns []string, scan func(*sql.Rows) ([]interface{}, error)) {
+	defer rows.Close()
+
+	out, err := utils.NewRowWriter(w, format, filename, columns)
+	if err != nil {
+		log.Printf("Error starting %s export: %v", filename, err)
+		http.Error(w, "export error", http.StatusInternalServerError)
+		return
+	}
+
+	for rows.Next() {
+		values, err := scan(rows)
+		if err != nil {
+			log.Printf("Error scanning %s export row: %v", filename, err)
+			return
+		}
+		if err := out.WriteRow(values...); err != nil {
+			log.Printf("Error writing %s export row: %v", filename, err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating %s export rows: %v", filename, err)
+		return
+	}
+
+	if err := out.Close(); err != nil {
+		log.Printf("Error finishing %s export: %v", filename, err)
+	}
+}
+
+// exportQuery runs query and streams the result, answering 500 if the
+// query itself fails.
+func exportQuery(ctx context.Context, db *sql.DB, w http.ResponseWriter, format, filename string, columns []string, scan func(*sql.Rows) ([]interface{}, error), query string, args ...interface{}) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error querying database: %v", err)
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	streamExport(w, rows, format, filename, columns, scan)
+}
+
+// ExportOffer downloads a single offer with its material lines. Without an
+// explicit format it defaults to CSV.
+func ExportOffer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		format, err := utils.ExportFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if format == "" {
+			format = utils.FormatCSV
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenantID).Scan(&exists)
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+
+		exportQuery(r.Context(), db, w, format, fmt.Sprintf("offer-%d", id), offerExportColumns, scanOfferExportRow,
+			offerExportQuery+" AND o.id = $1 AND o.tenant_id = $2 ORDER BY om.id", id, tenantID)
+	}
+}
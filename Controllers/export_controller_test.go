@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func offerExportRows() *sqlmock.Rows {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return sqlmock.NewRows(offerExportColumns).
+		AddRow(1, "Offer1", created, created, 10, 5, "Steel", true, 2.5, 10.0).
+		AddRow(1, "Offer1", created, created, 11, 6, "Copper, rolled", false, 1.0, 4.25).
+		AddRow(2, "Offer2", created, created, nil, nil, nil, nil, nil, nil)
+}
+
+func TestGetOffersExport(t *testing.T) {
+	query := regexp.QuoteMeta(offerExportQuery + " AND o.tenant_id = $1 ORDER BY o.id, om.id")
+
+	testCases := []struct {
+		name         string
+		url          string
+		accept       string
+		expectedCode int
+		expectedType string
+		mockQueries  func(mock sqlmock.Sqlmock)
+		check        func(t *testing.T, body []byte)
+	}{
+		{
+			name:         "csv via format parameter",
+			url:          "/offers?format=csv",
+			expectedCode: http.StatusOK,
+			expectedType: "text/csv",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WillReturnRows(offerExportRows())
+			},
+			check: func(t *testing.T, body []byte) {
+				lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+				assert.Len(t, lines, 4)
+				assert.Equal(t, strings.Join(offerExportColumns, ","), lines[0])
+				assert.Equal(t, `1,Offer1,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z,11,6,"Copper, rolled",false,1,4.25`, lines[2])
+				assert.Equal(t, `2,Offer2,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z,,,,,,`, lines[3])
+			},
+		},
+		{
+			name:         "ndjson via Accept header",
+			url:          "/offers",
+			accept:       "application/x-ndjson",
+			expectedCode: http.StatusOK,
+			expectedType: "application/x-ndjson",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WillReturnRows(offerExportRows())
+			},
+			check: func(t *testing.T, body []byte) {
+				lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+				assert.Len(t, lines, 3)
+				var row map[string]interface{}
+				assert.NoError(t, json.Unmarshal([]byte(lines[2]), &row))
+				assert.Equal(t, "Offer2", row["offer_name"])
+				assert.Nil(t, row["material_id"])
+			},
+		},
+		{
+			name:         "xlsx workbook",
+			url:          "/offers?format=xlsx",
+			expectedCode: http.StatusOK,
+			expectedType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WillReturnRows(offerExportRows())
+			},
+			check: func(t *testing.T, body []byte) {
+				zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				assert.NoError(t, err)
+				names := []string{}
+				for _, f := range zr.File {
+					names = append(names, f.Name)
+				}
+				assert.Contains(t, names, "xl/worksheets/sheet1.xml")
+			},
+		},
+		{
+			name:         "failure - unsupported format",
+			url:          "/offers?format=pdf",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - database error",
+			url:          "/offers?format=csv",
+			expectedCode: http.StatusInternalServerError,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WillReturnError(errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("GET", tc.url, nil), "acme")
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+
+			handler := GetOffers(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedType != "" {
+				assert.Equal(t, tc.expectedType, w.Header().Get("Content-Type"))
+			}
+			if tc.check != nil {
+				tc.check(t, w.Body.Bytes())
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestExportOffer(t *testing.T) {
+	exists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	query := regexp.QuoteMeta(offerExportQuery + " AND o.id = $1 AND o.tenant_id = $2 ORDER BY om.id")
+
+	testCases := []struct {
+		name         string
+		offerID      string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - defaults to csv",
+			offerID:      "1",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(exists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(query).WithArgs(1, "acme").WillReturnRows(offerExportRows())
+			},
+		},
+		{
+			name:         "failure - offer not found",
+			offerID:      "99",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(exists).WithArgs(99, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+		{
+			name:         "failure - invalid id",
+			offerID:      "abc",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("GET", "/offers/"+tc.offerID+"/export", nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": tc.offerID})
+			w := httptest.NewRecorder()
+
+			handler := ExportOffer(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, `attachment; filename="offer-1.csv"`, w.Header().Get("Content-Disposition"))
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/idempotency"
+	"Products/models"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOfferIdempotencyKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Only the first request reaches the database.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO offer (name, tenant_id, owner_id, customer_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`)).
+		WithArgs("Roof", "acme", nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+	expectEvent(mock, "offer", "1", models.EventOfferCreated)
+	mock.ExpectCommit()
+
+	handler := idempotency.Middleware(idempotency.NewMemoryStore(), time.Hour)(CreateOffer(db))
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := withTenant(httptest.NewRequest("POST", "/offers", strings.NewReader(body)), "acme")
+		req.Header.Set(idempotency.HeaderKey, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("key-1", `{"name": "Roof"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	retry := send("key-1", `{"name": "Roof"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(idempotency.HeaderReplayed))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	reused := send("key-1", `{"name": "Garage"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIdempotencyKeyServerErrorNotStored(t *testing.T) {
+	calls := 0
+	handler := idempotency.Middleware(idempotency.NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, expected := range []int{http.StatusInternalServerError, http.StatusCreated, http.StatusCreated} {
+		req := httptest.NewRequest("POST", "/offer-materials", strings.NewReader(`{"offer_id": 1}`))
+		req.Header.Set(idempotency.HeaderKey, "key-2")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Code)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyKeyScopedToPrincipal(t *testing.T) {
+	calls := 0
+	handler := idempotency.Middleware(idempotency.NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, id := range []string{"api_key:1", "api_key:2"} {
+		req := httptest.NewRequest("POST", "/offers", strings.NewReader(`{}`))
+		req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{ID: id}))
+		req.Header.Set(idempotency.HeaderKey, "shared-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get(idempotency.HeaderReplayed))
+	}
+	assert.Equal(t, 2, calls)
+}
+
+// contextStore fails like a database does once the context is cancelled.
+type contextStore struct {
+	*idempotency.MemoryStore
+}
+
+func (s contextStore) Complete(ctx context.Context, key string, rec idempotency.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryStore.Complete(ctx, key, rec)
+}
+
+func TestIdempotencyKeyCompletedAfterClientLeft(t *testing.T) {
+	calls := 0
+	handler := idempotency.Middleware(contextStore{idempotency.NewMemoryStore()}, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/offers", strings.NewReader(`{}`)).WithContext(ctx)
+	req.Header.Set(idempotency.HeaderKey, "key-3")
+	handler.ServeHTTP(&cancelingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}, req)
+
+	retry := httptest.NewRequest("POST", "/offers", strings.NewReader(`{}`))
+	retry.Header.Set(idempotency.HeaderKey, "key-3")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, retry)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get(idempotency.HeaderReplayed))
+	assert.Equal(t, 1, calls)
+}
+
+// cancelingWriter cancels the request once the response is sent, as a
+// client disconnecting right then would.
+type cancelingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) WriteHeader(status int) {
+	w.ResponseRecorder.WriteHeader(status)
+	w.cancel()
+}
+
+func TestIdempotencyKeyIncludesQuery(t *testing.T) {
+	handler := idempotency.Middleware(idempotency.NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		url          string
+		expectedCode int
+	}{
+		{"/materials/import?dry_run=true", http.StatusOK},
+		{"/materials/import", http.StatusUnprocessableEntity},
+	} {
+		req := httptest.NewRequest("POST", tc.url, strings.NewReader("name\nSteel\n"))
+		req.Header.Set(idempotency.HeaderKey, "key-4")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, tc.expectedCode, w.Code, tc.url)
+	}
+}
+
+func TestIdempotencyKeyBodyLimits(t *testing.T) {
+	calls := 0
+	handler := idempotency.Middleware(idempotency.NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/materials/import", strings.NewReader(strings.Repeat("x", 11<<20)))
+	req.Header.Set(idempotency.HeaderKey, "key-5")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, calls)
+
+	// Uploads are not buffered, so a key on one is rejected rather than
+	// ignored; without a key they reach the handler.
+	body, contentType := multipartFile(t, "sheet.pdf", []byte("%PDF-1.4"))
+	req = httptest.NewRequest("POST", "/materials/1/attachments", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(idempotency.HeaderKey, "key-6")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 0, calls)
+
+	body, contentType = multipartFile(t, "sheet.pdf", []byte("%PDF-1.4"))
+	req = httptest.NewRequest("POST", "/materials/1/attachments", body)
+	req.Header.Set("Content-Type", contentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, calls)
+}
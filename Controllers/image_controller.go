@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/config"
+	"Products/imaging"
+	"Products/models"
+	"Products/storage"
+	"Products/tenant"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// imageCacheControl lets clients keep images for a year. An image never
+// changes once uploaded; a new upload gets a new ID and so a new URL.
+const imageCacheControl = "private, max-age=31536000, immutable"
+
+const materialImageColumns = `id, material_id, file_name, content_type, width, height, size_bytes, checksum, uploaded_by, created_at`
+
+func scanMaterialImage(row interface{ Scan(...interface{}) error }, img *models.MaterialImage, extra ...interface{}) error {
+	dest := append([]interface{}{&img.ID, &img.MaterialID, &img.FileName, &img.ContentType, &img.Width, &img.Height, &img.Size, &img.Checksum, &img.UploadedBy, &img.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	setMaterialImageURLs(img)
+	return nil
+}
+
+func setMaterialImageURLs(img *models.MaterialImage) {
+	img.URL = fmt.Sprintf("/materials/%d/images/%d", img.MaterialID, img.ID)
+	img.ThumbnailURL = img.URL + "/thumbnail"
+}
+
+// loadMaterialImages returns the images of the given materials by ID,
+// oldest first, with an empty list for materials without any.
+func loadMaterialImages(ctx context.Context, db *sql.DB, ids []int) (map[int][]models.MaterialImage, error) {
+	images := map[int][]models.MaterialImage{}
+	for _, id := range ids {
+		images[id] = []models.MaterialImage{}
+	}
+	if len(ids) == 0 {
+		return images, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+materialImageColumns+" FROM material_image WHERE material_id = ANY($1) AND tenant_id = $2 AND deleted_at IS NULL ORDER BY material_id, id",
+		pq.Array(ids), tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var img models.MaterialImage
+		if err := scanMaterialImage(rows, &img); err != nil {
+			return nil, err
+		}
+		images[img.MaterialID] = append(images[img.MaterialID], img)
+	}
+	return images, rows.Err()
+}
+
+// readImageUpload reads the "file" part of a multipart request into
+// memory, since it has to be decoded before anything is stored. Files over
+// max bytes fail with 413.
+func readImageUpload(r *http.Request, max int64) (string, []byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, badRequest("a multipart/form-data body is required")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, badRequest("file is required")
+		}
+		if err != nil {
+			return "", nil, badRequest("invalid multipart body: %v", err)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		name := filepath.Base(part.FileName())
+		if name == "." || name == string(filepath.Separator) {
+			name = "image"
+		}
+		data, err := io.ReadAll(io.LimitReader(part, max+1))
+		var tooLarge *http.MaxBytesError
+		if int64(len(data)) > max || errors.As(err, &tooLarge) {
+			return "", nil, &statusError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("file is larger than %d bytes", max)}
+		}
+		if err != nil {
+			return "", nil, badRequest("invalid multipart body: %v", err)
+		}
+		return name, data, nil
+	}
+}
+
+// UploadMaterialImage stores the multipart "file" field as an image of the
+// material in the URL, together with a thumbnail scaled down to
+// THUMBNAIL_SIZE. Only JPEG, PNG and GIF images are accepted. Being
+// multipart, uploads cannot carry an Idempotency-Key.
+func UploadMaterialImage(db *sql.DB, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		if err := checkAttachmentOwner(ctx, db, models.EntityMaterial, id, true); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		max := config.AttachmentMaxBytes()
+		// Leave room for the multipart headers around the file.
+		r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+		name, data, err := readImageUpload(r, max)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		decoded, format, err := imaging.Decode(data)
+		if errors.Is(err, imaging.ErrTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "file must be a JPEG, PNG or GIF image", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var thumbnail bytes.Buffer
+		thumbnailType, err := imaging.Encode(&thumbnail, imaging.Thumbnail(decoded, config.ThumbnailSize()), format)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		tenantID := tenant.FromContext(ctx)
+		key, err := storage.NewKey(tenantID, "material-image")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		thumbnailKey := key + "-thumbnail"
+		if _, err := store.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			writeError(w, err)
+			return
+		}
+		if _, err := store.Put(ctx, thumbnailKey, &thumbnail); err != nil {
+			store.Delete(ctx, key)
+			writeError(w, err)
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		p, _ := auth.FromContext(ctx)
+		img := models.MaterialImage{
+			MaterialID:  id,
+			FileName:    name,
+			ContentType: imaging.ContentTypes[format],
+			Width:       decoded.Bounds().Dx(),
+			Height:      decoded.Bounds().Dy(),
+			Size:        int64(len(data)),
+			Checksum:    hex.EncodeToString(sum[:]),
+			UploadedBy:  p.ID,
+		}
+		err = db.QueryRowContext(ctx, `INSERT INTO material_image (material_id, file_name, content_type, width, height, size_bytes, checksum, storage_key, thumbnail_key, thumbnail_type, uploaded_by, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at`,
+			id, img.FileName, img.ContentType, img.Width, img.Height, img.Size, img.Checksum, key, thumbnailKey, thumbnailType, img.UploadedBy, tenantID).
+			Scan(&img.ID, &img.CreatedAt)
+		if err != nil {
+			store.Delete(ctx, key)
+			store.Delete(ctx, thumbnailKey)
+			writeError(w, err)
+			return
+		}
+		setMaterialImageURLs(&img)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(img)
+	}
+}
+
+// GetMaterialImages lists the images of the material in the URL.
+func GetMaterialImages(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		if err := checkAttachmentOwner(r.Context(), db, models.EntityMaterial, id, false); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		images, err := loadMaterialImages(r.Context(), db, []int{id})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(images[id])
+	}
+}
+
+// storedImage is a material image with the keys of its content.
+type storedImage struct {
+	models.MaterialImage
+	key, thumbnailKey, thumbnailType string
+}
+
+// findMaterialImage loads the image in the URL and its storage keys.
+func findMaterialImage(r *http.Request, db *sql.DB) (storedImage, error) {
+	var img storedImage
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return img, badRequest("invalid id")
+	}
+	imageID, err := strconv.Atoi(vars["image_id"])
+	if err != nil {
+		return img, badRequest("invalid image id")
+	}
+
+	err = scanMaterialImage(db.QueryRowContext(r.Context(), `SELECT `+materialImageColumns+`, storage_key, thumbnail_key, thumbnail_type FROM material_image
+		WHERE id = $1 AND material_id = $2 AND tenant_id = $3 AND deleted_at IS NULL`,
+		imageID, id, tenant.FromContext(r.Context())), &img.MaterialImage, &img.key, &img.thumbnailKey, &img.thumbnailType)
+	if err == sql.ErrNoRows {
+		return img, notFound("Image not found")
+	}
+	return img, err
+}
+
+// serveMaterialImage streams an image or its thumbnail with long-lived
+// cache headers. Conditional and range requests are answered from the
+// ETag.
+func serveMaterialImage(db *sql.DB, store storage.Store, thumbnail bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		img, err := findMaterialImage(r, db)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		key, contentType, etag := img.key, img.ContentType, `"`+img.Checksum+`"`
+		if thumbnail {
+			key, contentType, etag = img.thumbnailKey, img.thumbnailType, `"`+img.Checksum+`-thumbnail"`
+		}
+
+		blob, err := store.Open(r.Context(), key)
+		if err != nil {
+			log.Printf("Error opening material image %d: %v", img.ID, err)
+			http.Error(w, "image content unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer blob.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", imageCacheControl)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, img.FileName, img.CreatedAt, blob)
+	}
+}
+
+// DownloadMaterialImage serves the original of a material image.
+func DownloadMaterialImage(db *sql.DB, store storage.Store) http.HandlerFunc {
+	return serveMaterialImage(db, store, false)
+}
+
+// DownloadMaterialThumbnail serves the thumbnail of a material image.
+func DownloadMaterialThumbnail(db *sql.DB, store storage.Store) http.HandlerFunc {
+	return serveMaterialImage(db, store, true)
+}
+
+// DeleteMaterialImage removes an image and its thumbnail.
+func DeleteMaterialImage(db *sql.DB, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		img, err := findMaterialImage(r, db)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		_, err = db.ExecContext(r.Context(), "UPDATE material_image SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2", img.ID, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, key := range []string{img.key, img.thumbnailKey} {
+			if err := store.Delete(r.Context(), key); err != nil {
+				log.Printf("Error deleting material image %d content: %v", img.ID, err)
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"Products/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var materialImageRowColumns = []string{"id", "material_id", "file_name", "content_type", "width", "height", "size_bytes", "checksum", "uploaded_by", "created_at", "storage_key", "thumbnail_key", "thumbnail_type"}
+
+// pngImage returns a w x h PNG filled with one colour.
+func pngImage(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 30, B: 30, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestUploadMaterialImage(t *testing.T) {
+	insert := regexp.QuoteMeta(`INSERT INTO material_image (material_id, file_name, content_type, width, height, size_bytes, checksum, storage_key, thumbnail_key, thumbnail_type, uploaded_by, tenant_id)`)
+
+	testCases := []struct {
+		name          string
+		content       []byte
+		thumbnailSize string
+		expectedCode  int
+		wantThumbnail image.Point
+		mockQueries   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:          "success - thumbnail keeps the aspect ratio",
+			content:       pngImage(t, 600, 300),
+			thumbnailSize: "120",
+			expectedCode:  http.StatusCreated,
+			wantThumbnail: image.Pt(120, 60),
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(insert).
+					WithArgs(4, "sheet.png", "image/png", 600, 300, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "image/png", "user-1", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+			},
+		},
+		{
+			name:          "success - small images are not enlarged",
+			content:       pngImage(t, 40, 20),
+			expectedCode:  http.StatusCreated,
+			wantThumbnail: image.Pt(40, 20),
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(insert).
+					WithArgs(4, "sheet.png", "image/png", 40, 20, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "image/png", "user-1", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+			},
+		},
+		{
+			name:         "failure - not an image",
+			content:      []byte("%PDF-1.4\nspec sheet"),
+			expectedCode: http.StatusUnsupportedMediaType,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("THUMBNAIL_SIZE", tc.thumbnailSize)
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			dir := t.TempDir()
+			store, err := storage.NewLocal(dir)
+			assert.NoError(t, err)
+
+			tc.mockQueries(mock)
+
+			body, contentType := multipartFile(t, "sheet.png", tc.content)
+			req := asPrincipal(httptest.NewRequest("POST", "/materials/4/images", body), "user-1", auth.RoleCatalogAdmin)
+			req.Header.Set("Content-Type", contentType)
+			req = mux.SetURLVars(req, map[string]string{"id": "4"})
+			w := httptest.NewRecorder()
+
+			handler := UploadMaterialImage(db, store)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if w.Code == http.StatusCreated {
+				var img models.MaterialImage
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&img))
+				assert.Equal(t, "/materials/4/images/2/thumbnail", img.ThumbnailURL)
+
+				thumbnails, _ := filepath.Glob(filepath.Join(dir, "acme", "material-image", "*-thumbnail"))
+				assert.Len(t, thumbnails, 1)
+				file, err := os.Open(thumbnails[0])
+				assert.NoError(t, err)
+				defer file.Close()
+				config, err := png.DecodeConfig(file)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantThumbnail, image.Pt(config.Width, config.Height))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDownloadMaterialThumbnail(t *testing.T) {
+	find := regexp.QuoteMeta(`FROM material_image
+		WHERE id = $1 AND material_id = $2 AND tenant_id = $3 AND deleted_at IS NULL`)
+
+	testCases := []struct {
+		name         string
+		ifNoneMatch  string
+		expectedCode int
+	}{
+		{name: "success - served with cache headers", expectedCode: http.StatusOK},
+		{name: "success - not modified", ifNoneMatch: `"abc123-thumbnail"`, expectedCode: http.StatusNotModified},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			store, err := storage.NewLocal(t.TempDir())
+			assert.NoError(t, err)
+			_, err = store.Put(context.Background(), "acme/material-image/abc-thumbnail", strings.NewReader("thumbnail"))
+			assert.NoError(t, err)
+
+			mock.ExpectQuery(find).WithArgs(2, 4, "acme").
+				WillReturnRows(sqlmock.NewRows(materialImageRowColumns).
+					AddRow(2, 4, "sheet.png", "image/png", 600, 300, 2048, "abc123", "user-1", time.Now(), "acme/material-image/abc", "acme/material-image/abc-thumbnail", "image/png"))
+
+			req := withTenant(httptest.NewRequest("GET", "/materials/4/images/2/thumbnail", nil), "acme")
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": "4", "image_id": "2"})
+			w := httptest.NewRecorder()
+
+			handler := DownloadMaterialThumbnail(db, store)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Equal(t, "private, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+			assert.Equal(t, `"abc123-thumbnail"`, w.Header().Get("ETag"))
+			if w.Code == http.StatusOK {
+				assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+				assert.Equal(t, "thumbnail", w.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func GetStockLocations(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT id, name, created_at, updated_at, deleted_at FROM stock_location WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY name, id", tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		locations := []models.StockLocation{}
+		for rows.Next() {
+			var location models.StockLocation
+			if err := rows.Scan(&location.ID, &location.Name, &location.CreatedAt, &location.UpdatedAt, &location.DeletedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			locations = append(locations, location)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(locations)
+	}
+}
+
+func CreateStockLocation(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var location models.StockLocation
+		if err := json.NewDecoder(r.Body).Decode(&location); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		location.Name = strings.TrimSpace(location.Name)
+		if location.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		err := db.QueryRowContext(r.Context(), "INSERT INTO stock_location (name, tenant_id) VALUES ($1, $2) RETURNING id, created_at, updated_at", location.Name, tenant.FromContext(r.Context())).
+			Scan(&location.ID, &location.CreatedAt, &location.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(location)
+	}
+}
+
+// GetMaterialStock reports a material's stock per location along with the
+// totals reserved by accepted offers.
+func GetMaterialStock(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT sl.location_id, l.name, sl.on_hand, sl.updated_at
+			FROM stock_level sl JOIN stock_location l ON l.id = sl.location_id
+			WHERE sl.material_id = $1 AND sl.tenant_id = $2 AND l.deleted_at IS NULL
+			ORDER BY l.name, l.id`, id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		stock := models.MaterialStock{MaterialID: id, Locations: []models.StockLevel{}}
+		for rows.Next() {
+			var level models.StockLevel
+			if err := rows.Scan(&level.LocationID, &level.LocationName, &level.OnHand, &level.UpdatedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			stock.OnHand += level.OnHand
+			stock.Locations = append(stock.Locations, level)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), `SELECT COALESCE(SUM(sr.quantity), 0) FROM stock_reservation sr
+			JOIN offer o ON o.id = sr.offer_id AND o.deleted_at IS NULL
+			WHERE sr.material_id = $1 AND sr.tenant_id = $2 AND sr.released_at IS NULL`, id, tenantID).Scan(&stock.Reserved)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		stock.Available = stock.OnHand - stock.Reserved
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stock)
+	}
+}
+
+func GetMaterialMovements(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT id, material_id, location_id, type, quantity, note, created_at FROM inventory_movement
+			WHERE material_id = $1 AND tenant_id = $2
+			ORDER BY created_at DESC, id DESC`, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		movements := []models.InventoryMovement{}
+		for rows.Next() {
+			var movement models.InventoryMovement
+			if err := rows.Scan(&movement.ID, &movement.MaterialID, &movement.LocationID, &movement.Type, &movement.Quantity, &movement.Note, &movement.CreatedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			movements = append(movements, movement)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(movements)
+	}
+}
+
+// movementDelta returns the change a movement makes to the stock on hand.
+func movementDelta(movement models.InventoryMovement) (float64, error) {
+	switch movement.Type {
+	case models.MovementReceive, models.MovementIssue:
+		if movement.Quantity <= 0 {
+			return 0, badRequest("quantity must be positive")
+		}
+		if movement.Type == models.MovementIssue {
+			return -movement.Quantity, nil
+		}
+		return movement.Quantity, nil
+	case models.MovementAdjust:
+		if movement.Quantity == 0 {
+			return 0, badRequest("quantity must not be zero")
+		}
+		return movement.Quantity, nil
+	default:
+		return 0, badRequest("type must be receive, issue or adjust")
+	}
+}
+
+// applyMovement records a movement and updates the stock level it touches.
+// Stock never goes below zero at a location.
+func applyMovement(ctx context.Context, tx *sql.Tx, movement *models.InventoryMovement) error {
+	delta, err := movementDelta(*movement)
+	if err != nil {
+		return err
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", movement.MaterialID, tenantID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return badRequest("material not found")
+	}
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM stock_location WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", movement.LocationID, tenantID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return badRequest("location not found")
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO stock_level (material_id, location_id, tenant_id) VALUES ($1, $2, $3)
+		ON CONFLICT (material_id, location_id) DO NOTHING`, movement.MaterialID, movement.LocationID, tenantID)
+	if err != nil {
+		return err
+	}
+	var onHand float64
+	err = tx.QueryRowContext(ctx, "SELECT on_hand FROM stock_level WHERE material_id = $1 AND location_id = $2 AND tenant_id = $3 FOR UPDATE", movement.MaterialID, movement.LocationID, tenantID).Scan(&onHand)
+	if err != nil {
+		return err
+	}
+	if onHand+delta < 0 {
+		return conflict("insufficient stock: %v on hand", onHand)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE stock_level SET on_hand = on_hand + $1, updated_at = CURRENT_TIMESTAMP WHERE material_id = $2 AND location_id = $3 AND tenant_id = $4", delta, movement.MaterialID, movement.LocationID, tenantID)
+	if err != nil {
+		return err
+	}
+	return tx.QueryRowContext(ctx, "INSERT INTO inventory_movement (material_id, location_id, type, quantity, note, tenant_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at",
+		movement.MaterialID, movement.LocationID, movement.Type, movement.Quantity, movement.Note, tenantID).
+		Scan(&movement.ID, &movement.CreatedAt)
+}
+
+func CreateInventoryMovement(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var movement models.InventoryMovement
+		if err := json.NewDecoder(r.Body).Decode(&movement); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := applyMovement(r.Context(), tx, &movement); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(movement)
+	}
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateInventoryMovement(t *testing.T) {
+	materialExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	locationExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM stock_location WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	ensureLevel := regexp.QuoteMeta(`INSERT INTO stock_level (material_id, location_id, tenant_id) VALUES ($1, $2, $3)`)
+	lockLevel := regexp.QuoteMeta(`SELECT on_hand FROM stock_level WHERE material_id = $1 AND location_id = $2 AND tenant_id = $3 FOR UPDATE`)
+	updateLevel := regexp.QuoteMeta(`UPDATE stock_level SET on_hand = on_hand + $1`)
+	insert := regexp.QuoteMeta(`INSERT INTO inventory_movement (material_id, location_id, type, quantity, note, tenant_id)`)
+
+	expectLevel := func(mock sqlmock.Sqlmock, onHand float64) {
+		mock.ExpectQuery(materialExists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(locationExists).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(ensureLevel).WithArgs(1, 2, "acme").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(lockLevel).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"on_hand"}).AddRow(onHand))
+	}
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - stock received",
+			requestBody:  `{"material_id": 1, "location_id": 2, "type": "receive", "quantity": 10, "note": "PO 17"}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLevel(mock, 0)
+				mock.ExpectExec(updateLevel).WithArgs(10.0, 1, 2, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(insert).WithArgs(1, 2, "receive", 10.0, "PO 17", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - negative adjustment",
+			requestBody:  `{"material_id": 1, "location_id": 2, "type": "adjust", "quantity": -2}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLevel(mock, 5)
+				mock.ExpectExec(updateLevel).WithArgs(-2.0, 1, 2, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(insert).WithArgs(1, 2, "adjust", -2.0, "", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - issue more than on hand",
+			requestBody:  `{"material_id": 1, "location_id": 2, "type": "issue", "quantity": 6}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLevel(mock, 5)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - unknown type",
+			requestBody:  `{"material_id": 1, "location_id": 2, "type": "transfer", "quantity": 1}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/inventory/movements", strings.NewReader(tc.requestBody)), "acme")
+			w := httptest.NewRecorder()
+
+			handler := CreateInventoryMovement(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateOfferStatus(t *testing.T) {
+	lockOffer := regexp.QuoteMeta(`SELECT status, owner_id, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`)
+	lockMaterials := regexp.QuoteMeta(`SELECT id FROM material`)
+	fulfillment := regexp.QuoteMeta(`SELECT om.material_id, m.name, SUM(om.quantity),`)
+	reserve := regexp.QuoteMeta(`INSERT INTO stock_reservation (offer_id, offer_material_id, material_id, quantity, tenant_id)`)
+	release := regexp.QuoteMeta(`UPDATE stock_reservation SET released_at = CURRENT_TIMESTAMP WHERE offer_id = ANY($1) AND released_at IS NULL`)
+	update := regexp.QuoteMeta(`UPDATE offer SET status = $1, valid_until = COALESCE($2, valid_until), updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 RETURNING valid_until`)
+	fulfillmentColumns := []string{"material_id", "name", "required", "available"}
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - accepting reserves stock",
+			requestBody:  `{"status": "accepted"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("sent", nil, 7))
+				mock.ExpectQuery(lockMaterials).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectQuery(fulfillment).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows(fulfillmentColumns).AddRow(3, "Steel", 4.0, 10.0))
+				mock.ExpectExec(reserve).WithArgs(1, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(update).WithArgs("accepted", nil, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"valid_until"}).AddRow(nil))
+				expectEvent(mock, "offer", "1", models.EventOfferStatusChanged)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - accepting without enough stock",
+			requestBody:  `{"status": "accepted"}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("draft", nil, 7))
+				mock.ExpectQuery(approvalRules).WithArgs("acme").WillReturnRows(sqlmock.NewRows(approvalRuleRowColumns))
+				mock.ExpectQuery(lockMaterials).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectQuery(fulfillment).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows(fulfillmentColumns).AddRow(3, "Steel", 4.0, 1.5))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "success - rejecting an accepted offer releases stock",
+			requestBody:  `{"status": "rejected"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("accepted", nil, 7))
+				mock.ExpectExec(release).WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(update).WithArgs("rejected", nil, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"valid_until"}).AddRow(nil))
+				expectEvent(mock, "offer", "1", models.EventOfferStatusChanged)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - revising a sent offer moves it back to draft",
+			requestBody:  `{"status": "draft"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("sent", nil, 7))
+				mock.ExpectQuery(update).WithArgs("draft", nil, 1, "acme").WillReturnRows(sqlmock.NewRows([]string{"valid_until"}).AddRow(nil))
+				expectEvent(mock, "offer", "1", models.EventOfferStatusChanged)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - accepted offers cannot be revised",
+			requestBody:  `{"status": "draft"}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("accepted", nil, 7))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - transition not allowed",
+			requestBody:  `{"status": "sent"}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("expired", nil, 7))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - sending without a customer",
+			requestBody:  `{"status": "sent"}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id", "customer_id"}).AddRow("draft", nil, nil))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - unknown status",
+			requestBody:  `{"status": "won"}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/offers/1/status", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := UpdateOfferStatus(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetOfferFulfillment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)).
+		WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT om.material_id, m.name, SUM(om.quantity),`)).WithArgs(1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"material_id", "name", "required", "available"}).
+			AddRow(3, "Steel", 4.0, 10.0).
+			AddRow(5, "Copper", 2.0, 0.5))
+
+	req := withTenant(httptest.NewRequest("GET", "/offers/1/fulfillment", nil), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	handler := GetOfferFulfillment(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var check models.FulfillmentCheck
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&check))
+	assert.False(t, check.Fulfillable)
+	assert.Equal(t, 0.0, check.Lines[0].Shortfall)
+	assert.Equal(t, 1.5, check.Lines[1].Shortfall)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,33 +1,177 @@
 package controllers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"github.com/gorilla/mux"
 	"Products/models"
+	"Products/tenant"
+	"Products/utils"
 )
 
+// materialFilter scopes the list to the request's tenant and turns the
+// query parameters into extra WHERE conditions. ?category=X matches
+// materials in category X, or anywhere below it with
+// include_descendants=true. Tag and attribute filters are handled by
+// metadataFilter.
+func materialFilter(db *sql.DB, r *http.Request) (string, []interface{}, error) {
+	query := r.URL.Query()
+	filter := " AND tenant_id = $1"
+	args := []interface{}{tenant.FromContext(r.Context())}
+
+	if value := query.Get("category"); value != "" {
+		categoryID, err := strconv.Atoi(value)
+		if err != nil {
+			return "", nil, badRequest("invalid category")
+		}
+		descendants := false
+		if value := query.Get("include_descendants"); value != "" {
+			if descendants, err = strconv.ParseBool(value); err != nil {
+				return "", nil, badRequest("invalid include_descendants")
+			}
+		}
+
+		args = append(args, categoryID)
+		if descendants {
+			filter += fmt.Sprintf(" AND category_id IN (%s)", categorySubtreeQuery(len(args), 1))
+		} else {
+			filter += fmt.Sprintf(" AND category_id = $%d", len(args))
+		}
+	}
+
+	metadata, args, err := metadataFilter(db, r, models.EntityMaterial, "id", args)
+	if err != nil {
+		return "", nil, err
+	}
+	return filter + metadata, args, nil
+}
+
+// attachMaterialMetadata loads tags, attributes, attachments and images
+// onto materials.
+func attachMaterialMetadata(ctx context.Context, db *sql.DB, materials []models.Material) error {
+	ids := make([]int, len(materials))
+	for i, material := range materials {
+		ids[i] = material.ID
+	}
+	tags, attributes, err := loadMetadata(ctx, db, models.EntityMaterial, ids)
+	if err != nil {
+		return err
+	}
+	attachments, err := loadAttachments(ctx, db, models.EntityMaterial, ids)
+	if err != nil {
+		return err
+	}
+	images, err := loadMaterialImages(ctx, db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range materials {
+		materials[i].Tags = tags[materials[i].ID]
+		materials[i].Attributes = attributes[materials[i].ID]
+		materials[i].Attachments = attachments[materials[i].ID]
+		materials[i].Images = images[materials[i].ID]
+	}
+	return nil
+}
+
+// prepareMaterialMetadata normalizes and validates the tags and attributes
+// sent with a create or update.
+func prepareMaterialMetadata(ctx context.Context, db *sql.DB, material *models.Material) ([]attributeValue, error) {
+	tags, err := normalizeTags(material.Tags)
+	if err != nil {
+		return nil, err
+	}
+	material.Tags = tags
+	return validateAttributes(ctx, db, models.EntityMaterial, material.Attributes)
+}
+
+// checkMaterialCategory makes sure an assigned category exists in the
+// tenant and has not been deleted, answering the request itself when not.
+func checkMaterialCategory(ctx context.Context, db *sql.DB, w http.ResponseWriter, categoryID *int) bool {
+	if categoryID == nil {
+		return true
+	}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM category WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", *categoryID, tenant.FromContext(ctx)).Scan(&exists)
+	if err != nil {
+		log.Printf("Error querying database: %v", err)
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		http.Error(w, "category not found", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeMaterialConflict answers 409 with the tenant's live material
+// already using name.
+func writeMaterialConflict(ctx context.Context, db *sql.DB, w http.ResponseWriter, name string) {
+	var existing models.Material
+	err := db.QueryRowContext(ctx, "SELECT * FROM material WHERE LOWER(name) = LOWER($1) AND tenant_id = $2 AND deleted_at IS NULL", name, tenant.FromContext(ctx)).
+		Scan(&existing.ID, &existing.Name, &existing.Active, &existing.CreatedAt, &existing.UpdatedAt, &existing.DeletedAt, &existing.CategoryID, &existing.TenantID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeConflict(w, "material name already exists", existing)
+}
 
 func GetMaterials(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM material WHERE deleted_at IS NULL")
+		format, err := utils.ExportFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, args, err := materialFilter(db, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if format != "" {
+			exportQuery(r.Context(), db, w, format, "materials", materialExportColumns, scanMaterialExportRow,
+				"SELECT id, name, active, category_id, created_at, updated_at FROM material WHERE deleted_at IS NULL"+filter+" ORDER BY id", args...)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT * FROM material WHERE deleted_at IS NULL"+filter, args...)
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
 		}
 		defer rows.Close()
 
 		materials := []models.Material{}
 		for rows.Next() {
 			var material models.Material
-			if err := rows.Scan(&material.ID, &material.Name, &material.Active, &material.CreatedAt, &material.UpdatedAt, &material.DeletedAt); err != nil {
-				log.Fatal(err)
+			if err := rows.Scan(&material.ID, &material.Name, &material.Active, &material.CreatedAt, &material.UpdatedAt, &material.DeletedAt, &material.CategoryID, &material.TenantID); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
 			}
 			materials = append(materials, material)
 		}
 		if err := rows.Err(); err != nil {
-			log.Fatal(err)
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+		if err := attachMaterialMetadata(r.Context(), db, materials); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := localizeMaterials(w, r, db, materials); err != nil {
+			writeError(w, err)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -42,12 +186,22 @@ func GetMaterialByID(db *sql.DB) http.HandlerFunc {
 		id := vars["id"]
 
 		var material models.Material
-		err := db.QueryRow("SELECT * FROM material WHERE id = $1 AND deleted_at IS NULL", id).
-			Scan(&material.ID, &material.Name, &material.Active, &material.CreatedAt, &material.UpdatedAt, &material.DeletedAt)
+		err := db.QueryRowContext(r.Context(), "SELECT * FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context())).
+			Scan(&material.ID, &material.Name, &material.Active, &material.CreatedAt, &material.UpdatedAt, &material.DeletedAt, &material.CategoryID, &material.TenantID)
 		if err != nil {
 			http.Error(w, "Material not found", http.StatusNotFound)
 			return
 		}
+		materials := []models.Material{material}
+		if err := attachMaterialMetadata(r.Context(), db, materials); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := localizeMaterials(w, r, db, materials); err != nil {
+			writeError(w, err)
+			return
+		}
+		material = materials[0]
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(material)
@@ -63,14 +217,47 @@ func CreateMaterial(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		err := db.QueryRow("INSERT INTO material (name, active) VALUES ($1, $2) RETURNING id, created_at, updated_at", material.Name, material.Active).
+		if !checkMaterialCategory(r.Context(), db, w, material.CategoryID) {
+			return
+		}
+		values, err := prepareMaterialMetadata(r.Context(), db, &material)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		material.TenantID = tenant.FromContext(r.Context())
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		err = tx.QueryRowContext(r.Context(), "INSERT INTO material (name, active, category_id, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at", material.Name, material.Active, material.CategoryID, material.TenantID).
 			Scan(&material.ID, &material.CreatedAt, &material.UpdatedAt)
+		if isUniqueViolation(err, materialNameIndex) {
+			writeMaterialConflict(r.Context(), db, w, material.Name)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := enqueueEvent(r.Context(), tx, material.TenantID, models.EntityMaterial, material.ID, models.EventMaterialCreated, material); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := saveMetadata(r.Context(), tx, models.EntityMaterial, material.ID, material.Tags, values); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(material)
 	}
 }
@@ -80,6 +267,11 @@ func UpdateMaterial(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id := vars["id"]
+		materialID, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
 
 		var material models.Material
 		if err := json.NewDecoder(r.Body).Decode(&material); err != nil {
@@ -87,28 +279,190 @@ func UpdateMaterial(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE material SET name = $1, active = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND deleted_at IS NULL", material.Name, material.Active, id)
+		if !checkMaterialCategory(r.Context(), db, w, material.CategoryID) {
+			return
+		}
+		values, err := prepareMaterialMetadata(r.Context(), db, &material)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		var wasActive bool
+		err = tx.QueryRowContext(r.Context(), `WITH old AS (SELECT id, active FROM material WHERE id = $4 AND tenant_id = $5 AND deleted_at IS NULL FOR UPDATE)
+			UPDATE material m SET name = $1, active = $2, category_id = $3, updated_at = CURRENT_TIMESTAMP FROM old WHERE m.id = old.id
+			RETURNING old.active`, material.Name, material.Active, material.CategoryID, id, tenant.FromContext(r.Context())).Scan(&wasActive)
+		if isUniqueViolation(err, materialNameIndex) {
+			writeMaterialConflict(r.Context(), db, w, material.Name)
+			return
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// An inactive material is reported with the open offers still using
+		// it, so they can be reviewed or given a substitute.
+		if !material.Active {
+			if material.AffectedOffers, err = affectedOffers(r.Context(), tx, materialID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		material.ID, material.TenantID = materialID, tenant.FromContext(r.Context())
+		if err := enqueueEvent(r.Context(), tx, material.TenantID, models.EntityMaterial, materialID, models.EventMaterialUpdated, material); err != nil {
+			writeError(w, err)
+			return
+		}
+		if wasActive && !material.Active {
+			err := enqueueEvent(r.Context(), tx, material.TenantID, models.EntityMaterial, materialID, models.EventMaterialDeactivated,
+				models.MaterialDeactivatedEvent{MaterialID: materialID, Name: material.Name, AffectedOffers: material.AffectedOffers})
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if err := saveMetadata(r.Context(), tx, models.EntityMaterial, materialID, material.Tags, values); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(material)
 	}
 }
 
+// UpsertMaterial creates or updates the material named in the URL, 201 on
+// create and 200 on update. Names match case-insensitively and an existing
+// material keeps its spelling.
+func UpsertMaterial(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSpace(mux.Vars(r)["name"])
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		var material models.Material
+		if err := json.NewDecoder(r.Body).Decode(&material); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		material.Name = name
+
+		if !checkMaterialCategory(r.Context(), db, w, material.CategoryID) {
+			return
+		}
+		values, err := prepareMaterialMetadata(r.Context(), db, &material)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		var inserted, wasActive bool
+		material.TenantID = tenant.FromContext(r.Context())
+		err = tx.QueryRowContext(r.Context(), `WITH old AS (SELECT active FROM material WHERE tenant_id = $4 AND LOWER(name) = LOWER($1) AND deleted_at IS NULL FOR UPDATE)
+			INSERT INTO material (name, active, category_id, tenant_id) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, LOWER(name)) WHERE deleted_at IS NULL
+			DO UPDATE SET active = EXCLUDED.active, category_id = EXCLUDED.category_id, updated_at = CURRENT_TIMESTAMP
+			RETURNING id, name, created_at, updated_at, xmax = 0, COALESCE((SELECT active FROM old), FALSE)`, material.Name, material.Active, material.CategoryID, material.TenantID).
+			Scan(&material.ID, &material.Name, &material.CreatedAt, &material.UpdatedAt, &inserted, &wasActive)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !material.Active && !inserted {
+			if material.AffectedOffers, err = affectedOffers(r.Context(), tx, material.ID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		event := models.EventMaterialUpdated
+		if inserted {
+			event = models.EventMaterialCreated
+		}
+		if err := enqueueEvent(r.Context(), tx, material.TenantID, models.EntityMaterial, material.ID, event, material); err != nil {
+			writeError(w, err)
+			return
+		}
+		if wasActive && !material.Active {
+			err := enqueueEvent(r.Context(), tx, material.TenantID, models.EntityMaterial, material.ID, models.EventMaterialDeactivated,
+				models.MaterialDeactivatedEvent{MaterialID: material.ID, Name: material.Name, AffectedOffers: material.AffectedOffers})
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if err := saveMetadata(r.Context(), tx, models.EntityMaterial, material.ID, material.Tags, values); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if inserted {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(material)
+	}
+}
+
 // soft delete
 func DeleteMaterial(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
 
-		_, err := db.Exec("UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+		tenantID := tenant.FromContext(r.Context())
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		res, err := tx.ExecContext(r.Context(), "UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
+		if err := enqueueEvent(r.Context(), tx, tenantID, models.EntityMaterial, id, models.EventMaterialDeleted, models.MaterialDeletedEvent{MaterialID: id}); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.WriteHeader(http.StatusNoContent)
 	}
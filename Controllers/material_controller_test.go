@@ -15,9 +15,12 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
+var updateMaterialQuery = regexp.QuoteMeta(`UPDATE material m SET name = $1, active = $2, category_id = $3, updated_at = CURRENT_TIMESTAMP FROM old WHERE m.id = old.id`)
+
 // Implement Query method for MockDB
 func TestGetMaterials(t *testing.T) {
 	type testCase struct {
@@ -31,8 +34,8 @@ func TestGetMaterials(t *testing.T) {
 		{
 			name: "success - materials found",
 			mockData: [][]interface{}{
-				{1, "Material1", true, time.Now(), time.Now(), nil},
-				{2, "Material2", false, time.Now(), time.Now(), nil},
+				{1, "Material1", true, time.Now(), time.Now(), nil, nil, "acme"},
+				{2, "Material2", false, time.Now(), time.Now(), nil, nil, "acme"},
 			},
 			expectedLen: 2,
 		},
@@ -49,7 +52,7 @@ func TestGetMaterials(t *testing.T) {
 		},
 		{
 			name:        "scan error",
-			mockData:    [][]interface{}{{1, "Material1", "invalid_active", time.Now(), time.Now(), nil}},
+			mockData:    [][]interface{}{{1, "Material1", "invalid_active", time.Now(), time.Now(), nil, nil, "acme"}},
 			expectedLen: 0,
 			mockError:   nil,
 		},
@@ -64,12 +67,12 @@ func TestGetMaterials(t *testing.T) {
 			defer db.Close()
 
 			// Define expected query behavior
-			query := "SELECT * FROM material WHERE deleted_at IS NULL"
+			query := regexp.QuoteMeta("SELECT * FROM material WHERE deleted_at IS NULL AND tenant_id = $1")
 
 			if tc.mockError != nil {
-				mock.ExpectQuery(query).WillReturnError(tc.mockError)
+				mock.ExpectQuery(query).WithArgs("acme").WillReturnError(tc.mockError)
 			} else {
-				rows := sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at"})
+				rows := sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at", "category_id", "tenant_id"})
 				for _, row := range tc.mockData {
 					var values []driver.Value
 					for _, v := range row {
@@ -77,11 +80,14 @@ func TestGetMaterials(t *testing.T) {
 					}
 					rows.AddRow(values...)
 				}
-				mock.ExpectQuery(query).WillReturnRows(rows)
+				mock.ExpectQuery(query).WithArgs("acme").WillReturnRows(rows)
+				if len(tc.mockData) > 0 && tc.name != "scan error" {
+					expectMaterialMetadata(mock)
+				}
 			}
 
 			// Create test HTTP request
-			req := httptest.NewRequest("GET", "/materials", nil)
+			req := withTenant(httptest.NewRequest("GET", "/materials", nil), "acme")
 			w := httptest.NewRecorder()
 
 			// Call the handler
@@ -122,7 +128,7 @@ func TestGetMaterialByID(t *testing.T) {
 			name:       "success - material found",
 			materialID: "1",
 			mockData: []interface{}{
-				1, "Material 1", true, time.Now(), time.Now(), nil,
+				1, "Material 1", true, time.Now(), time.Now(), nil, nil, "acme",
 			},
 			expectErr: false,
 		},
@@ -146,23 +152,24 @@ func TestGetMaterialByID(t *testing.T) {
 			db, mock, err := sqlmock.New()
 			assert.NoError(t, err)
 
-			query := regexp.QuoteMeta(`SELECT * FROM material WHERE id = $1 AND deleted_at IS NULL`)
+			query := regexp.QuoteMeta(`SELECT * FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
 
 			if tc.mockError != nil {
-				mock.ExpectQuery(query).WithArgs(tc.materialID).WillReturnError(tc.mockError)
+				mock.ExpectQuery(query).WithArgs(tc.materialID, "acme").WillReturnError(tc.mockError)
 			} else if tc.mockData != nil {
 				rowValues := make([]driver.Value, len(tc.mockData))
 				for i, v := range tc.mockData {
 					rowValues[i] = v
 				}
 
-				rows := sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at"}).
+				rows := sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at", "category_id", "tenant_id"}).
 					AddRow(rowValues...)
 
-				mock.ExpectQuery(query).WithArgs(tc.materialID).WillReturnRows(rows).RowsWillBeClosed()
+				mock.ExpectQuery(query).WithArgs(tc.materialID, "acme").WillReturnRows(rows).RowsWillBeClosed()
+				expectMaterialMetadata(mock)
 			}
 
-			req := httptest.NewRequest("GET", "/materials/"+tc.materialID, nil)
+			req := withTenant(httptest.NewRequest("GET", "/materials/"+tc.materialID, nil), "acme")
 			w := httptest.NewRecorder()
 			req = mux.SetURLVars(req, map[string]string{"id": tc.materialID})
 
@@ -205,10 +212,13 @@ func TestCreateMaterial(t *testing.T) {
 			requestBody:  `{"name": "Material 1", "active": true}`,
 			expectedCode: http.StatusCreated,
 			mockQueries: func() {
-				mock.ExpectQuery(`INSERT INTO material \(name, active\) VALUES \(\$1, \$2\) RETURNING id, created_at, updated_at`).
-					WithArgs("Material 1", true).
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO material \(name, active, category_id, tenant_id\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at, updated_at`).
+					WithArgs("Material 1", true, nil, "acme").
 					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
 						AddRow(1, time.Now(), time.Now()))
+				expectEvent(mock, "material", "1", models.EventMaterialCreated)
+				mock.ExpectCommit()
 			},
 		},
 		{
@@ -224,9 +234,11 @@ func TestCreateMaterial(t *testing.T) {
 			requestBody:  `{"name": "Material 1", "active": true}`,
 			expectedCode: http.StatusInternalServerError,
 			mockQueries: func() {
-				mock.ExpectQuery(`INSERT INTO material \(name, active\) VALUES \(\$1, \$2\) RETURNING id, created_at, updated_at`).
-					WithArgs("Material 1", true).
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO material \(name, active, category_id, tenant_id\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at, updated_at`).
+					WithArgs("Material 1", true, nil, "acme").
 					WillReturnError(errors.New("insert error"))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -235,7 +247,7 @@ func TestCreateMaterial(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.mockQueries()
 
-			req := httptest.NewRequest("POST", "/materials", strings.NewReader(tc.requestBody))
+			req := withTenant(httptest.NewRequest("POST", "/materials", strings.NewReader(tc.requestBody)), "acme")
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
@@ -267,9 +279,12 @@ func TestUpdateMaterial(t *testing.T) {
             requestBody:  `{"name": "Updated Material", "active": true}`,
             expectedCode: http.StatusOK,
             mockQueries: func() {
-                mock.ExpectExec(`UPDATE material SET name = \$1, active = \$2, updated_at = CURRENT_TIMESTAMP WHERE id = \$3 AND deleted_at IS NULL`).
-                    WithArgs("Updated Material", true, "1").
-                    WillReturnResult(sqlmock.NewResult(1, 1))
+                mock.ExpectBegin()
+                mock.ExpectQuery(updateMaterialQuery).
+                    WithArgs("Updated Material", true, nil, "1", "acme").
+                    WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(true))
+                expectEvent(mock, "material", "1", models.EventMaterialUpdated)
+                mock.ExpectCommit()
             },
         },
         {
@@ -285,9 +300,11 @@ func TestUpdateMaterial(t *testing.T) {
             requestBody:  `{"name": "Updated Material", "active": true}`,
             expectedCode: http.StatusInternalServerError,
             mockQueries: func() {
-                mock.ExpectExec(`UPDATE material SET name = \$1, active = \$2, updated_at = CURRENT_TIMESTAMP WHERE id = \$3 AND deleted_at IS NULL`).
-                    WithArgs("Updated Material", true, "1").
+                mock.ExpectBegin()
+                mock.ExpectQuery(updateMaterialQuery).
+                    WithArgs("Updated Material", true, nil, "1", "acme").
                     WillReturnError(errors.New("update error"))
+                mock.ExpectRollback()
             },
         },
     }
@@ -296,7 +313,7 @@ func TestUpdateMaterial(t *testing.T) {
         t.Run(tc.name, func(t *testing.T) {
             tc.mockQueries()
 
-            req := httptest.NewRequest("PUT", "/materials/"+tc.materialID, strings.NewReader(tc.requestBody))
+            req := withTenant(httptest.NewRequest("PUT", "/materials/"+tc.materialID, strings.NewReader(tc.requestBody)), "acme")
             req.Header.Set("Content-Type", "application/json")
             w := httptest.NewRecorder()
             req = mux.SetURLVars(req, map[string]string{"id": tc.materialID})
@@ -326,9 +343,12 @@ func TestDeleteMaterial(t *testing.T) {
 			materialID:   "1",
 			expectedCode: http.StatusNoContent,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(1).
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2`).
+					WithArgs(1, "acme").
 					WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
+				expectEvent(mock, "material", "1", models.EventMaterialDeleted)
+				mock.ExpectCommit()
 			},
 		},
 		{
@@ -336,9 +356,11 @@ func TestDeleteMaterial(t *testing.T) {
 			materialID:   "99",
 			expectedCode: http.StatusNotFound,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(99).
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2`).
+					WithArgs(99, "acme").
 					WillReturnResult(sqlmock.NewResult(0, 0)) // 0 rows affected
+				mock.ExpectRollback()
 			},
 		},
 		{
@@ -346,9 +368,11 @@ func TestDeleteMaterial(t *testing.T) {
 			materialID:   "1",
 			expectedCode: http.StatusInternalServerError,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(1).
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE material SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2`).
+					WithArgs(1, "acme").
 					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -357,7 +381,7 @@ func TestDeleteMaterial(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.mockExec()
 
-			req := httptest.NewRequest("DELETE", fmt.Sprintf("/material/%s", tc.materialID), nil)
+			req := withTenant(httptest.NewRequest("DELETE", fmt.Sprintf("/material/%s", tc.materialID), nil), "acme")
 			req = mux.SetURLVars(req, map[string]string{"id": tc.materialID})
 			w := httptest.NewRecorder()
 
@@ -372,3 +396,109 @@ func TestDeleteMaterial(t *testing.T) {
 	}
 }
 
+func TestCreateMaterialDuplicateName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO material (name, active, category_id, tenant_id) VALUES ($1, $2, $3, $4)`)).
+		WithArgs("steel", true, nil, "acme").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: materialNameIndex})
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM material WHERE LOWER(name) = LOWER($1) AND tenant_id = $2 AND deleted_at IS NULL`)).
+		WithArgs("steel", "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at", "category_id", "tenant_id"}).
+			AddRow(3, "Steel", true, time.Now(), time.Now(), nil, nil, "acme"))
+	mock.ExpectRollback()
+
+	req := withTenant(httptest.NewRequest("POST", "/materials", strings.NewReader(`{"name": "steel", "active": true}`)), "acme")
+	w := httptest.NewRecorder()
+
+	handler := CreateMaterial(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var body struct {
+		Error    string          `json:"error"`
+		Existing models.Material `json:"existing"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, 3, body.Existing.ID)
+	assert.Equal(t, "Steel", body.Existing.Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertMaterial(t *testing.T) {
+	upsert := regexp.QuoteMeta(`INSERT INTO material (name, active, category_id, tenant_id) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, LOWER(name)) WHERE deleted_at IS NULL`)
+
+	testCases := []struct {
+		name         string
+		url          string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "created",
+			url:          "Steel",
+			requestBody:  `{"active": true}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(upsert).WithArgs("Steel", true, nil, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "inserted", "was_active"}).
+						AddRow(1, "Steel", time.Now(), time.Now(), true, false))
+				expectEvent(mock, "material", "1", models.EventMaterialCreated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "updated",
+			url:          "steel",
+			requestBody:  `{"active": true}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(upsert).WithArgs("steel", true, nil, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "inserted", "was_active"}).
+						AddRow(1, "Steel", time.Now(), time.Now(), false, true))
+				expectEvent(mock, "material", "1", models.EventMaterialUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - blank name",
+			url:          " ",
+			requestBody:  `{"active": true}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("PUT", "/materials/by-name/x", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"name": tc.url})
+			w := httptest.NewRecorder()
+
+			handler := UpsertMaterial(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusBadRequest {
+				var material models.Material
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&material))
+				assert.Equal(t, "Steel", material.Name)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
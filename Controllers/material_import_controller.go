@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidImport is returned when the CSV itself cannot be processed,
+// as opposed to individual rows being invalid.
+var ErrInvalidImport = errors.New("invalid import file")
+
+// importedMaterial holds one parsed CSV row. Name is the natural key.
+type importedMaterial struct {
+	Name       string
+	Active     bool
+	CategoryID *int
+}
+
+// materialImportColumns maps a CSV header to the setter for that column.
+// Files with other columns are rejected row by row, so that a misspelt or
+// unsupported column is never dropped silently.
+var materialImportColumns = map[string]func(m *importedMaterial, value string) error{
+	"name": func(m *importedMaterial, value string) error {
+		m.Name = strings.TrimSpace(value)
+		return nil
+	},
+	"active": func(m *importedMaterial, value string) error {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil
+		}
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid active value %q", value)
+		}
+		m.Active = active
+		return nil
+	},
+	"category_id": func(m *importedMaterial, value string) error {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			m.CategoryID = nil
+			return nil
+		}
+		categoryID, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid category_id value %q", value)
+		}
+		m.CategoryID = &categoryID
+		return nil
+	},
+}
+
+// materialImport is the state shared by the rows of one import.
+type materialImport struct {
+	ctx        context.Context
+	tenantID   string
+	tx         *sql.Tx
+	setters    []func(*importedMaterial, string) error
+	columns    map[string]bool
+	unknown    []string
+	seen       map[string]int
+	categories map[int]bool
+	dryRun     bool
+}
+
+// ImportMaterialsCSV upserts materials of the tenant of ctx from CSV by
+// name. With dryRun set nothing is written, but the report shows what would
+// have happened.
+func ImportMaterialsCSV(ctx context.Context, db *sql.DB, src io.Reader, dryRun bool) (models.MaterialImportReport, error) {
+	report := models.MaterialImportReport{DryRun: dryRun, Rows: []models.MaterialImportRow{}}
+
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return report, fmt.Errorf("%w: file is empty", ErrInvalidImport)
+	}
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	state := &materialImport{
+		ctx:        ctx,
+		tenantID:   tenant.FromContext(ctx),
+		setters:    make([]func(*importedMaterial, string) error, len(header)),
+		columns:    map[string]bool{},
+		seen:       map[string]int{},
+		categories: map[int]bool{},
+		dryRun:     dryRun,
+	}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if setter, ok := materialImportColumns[column]; ok {
+			state.setters[i] = setter
+			state.columns[column] = true
+		} else {
+			state.unknown = append(state.unknown, column)
+		}
+	}
+	report.UnknownColumns = state.unknown
+	if !state.columns["name"] {
+		return report, fmt.Errorf("%w: missing name column", ErrInvalidImport)
+	}
+
+	state.tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer state.tx.Rollback()
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+
+		row, err := state.importRow(line, record)
+		if err != nil {
+			return report, err
+		}
+		report.Add(row)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, state.tx.Commit()
+}
+
+// categoryExists checks a category of the tenant once per import.
+func (m *materialImport) categoryExists(id int) (bool, error) {
+	if exists, ok := m.categories[id]; ok {
+		return exists, nil
+	}
+	var exists bool
+	err := m.tx.QueryRowContext(m.ctx, "SELECT EXISTS (SELECT 1 FROM category WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, m.tenantID).Scan(&exists)
+	m.categories[id] = exists
+	return exists, err
+}
+
+func (m *materialImport) importRow(line int, record []string) (models.MaterialImportRow, error) {
+	material := importedMaterial{Active: true}
+	row := models.MaterialImportRow{Row: line, Action: models.ImportInvalid}
+
+	for i, value := range record {
+		if i >= len(m.setters) || m.setters[i] == nil {
+			continue
+		}
+		if err := m.setters[i](&material, value); err != nil {
+			row.Name = material.Name
+			row.Error = err.Error()
+			return row, nil
+		}
+	}
+	row.Name = material.Name
+
+	if len(m.unknown) > 0 {
+		row.Error = fmt.Sprintf("unknown columns: %s", strings.Join(m.unknown, ", "))
+		return row, nil
+	}
+	if material.Name == "" {
+		row.Error = "name is required"
+		return row, nil
+	}
+	key := strings.ToLower(material.Name)
+	if first, ok := m.seen[key]; ok {
+		row.Error = fmt.Sprintf("duplicate of row %d", first)
+		return row, nil
+	}
+	m.seen[key] = line
+
+	if material.CategoryID != nil {
+		exists, err := m.categoryExists(*material.CategoryID)
+		if err != nil {
+			return row, err
+		}
+		if !exists {
+			row.Error = fmt.Sprintf("category %d not found", *material.CategoryID)
+			return row, nil
+		}
+	}
+
+	var existing models.Material
+	err := m.tx.QueryRowContext(m.ctx, "SELECT id, name, active, category_id FROM material WHERE LOWER(name) = LOWER($1) AND tenant_id = $2 AND deleted_at IS NULL ORDER BY id LIMIT 1", material.Name, m.tenantID).
+		Scan(&existing.ID, &existing.Name, &existing.Active, &existing.CategoryID)
+	switch {
+	case err == sql.ErrNoRows:
+		row.Action = models.ImportCreated
+		if m.dryRun {
+			return row, nil
+		}
+		err = m.tx.QueryRowContext(m.ctx, "INSERT INTO material (name, active, category_id, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id", material.Name, material.Active, material.CategoryID, m.tenantID).
+			Scan(&row.ID)
+		if err != nil {
+			return row, err
+		}
+		return row, m.enqueueMaterialEvent(row.ID, models.EventMaterialCreated, material)
+	case err != nil:
+		return row, err
+	}
+
+	// Columns missing from the file keep their current values.
+	if !m.columns["active"] {
+		material.Active = existing.Active
+	}
+	if !m.columns["category_id"] {
+		material.CategoryID = existing.CategoryID
+	}
+
+	row.ID = existing.ID
+	if existing.Name == material.Name && existing.Active == material.Active && equalIntPtr(existing.CategoryID, material.CategoryID) {
+		row.Action = models.ImportSkipped
+		return row, nil
+	}
+
+	row.Action = models.ImportUpdated
+	deactivated := existing.Active && !material.Active
+	if deactivated {
+		if row.AffectedOffers, err = affectedOffers(m.ctx, m.tx, existing.ID); err != nil {
+			return row, err
+		}
+	}
+	if m.dryRun {
+		return row, nil
+	}
+	_, err = m.tx.ExecContext(m.ctx, "UPDATE material SET name = $1, active = $2, category_id = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND tenant_id = $5", material.Name, material.Active, material.CategoryID, existing.ID, m.tenantID)
+	if err != nil {
+		return row, err
+	}
+	if err := m.enqueueMaterialEvent(existing.ID, models.EventMaterialUpdated, material); err != nil || !deactivated {
+		return row, err
+	}
+	return row, enqueueEvent(m.ctx, m.tx, m.tenantID, models.EntityMaterial, existing.ID, models.EventMaterialDeactivated,
+		models.MaterialDeactivatedEvent{MaterialID: existing.ID, Name: material.Name, AffectedOffers: row.AffectedOffers})
+}
+
+// enqueueMaterialEvent records a created or updated event for an imported
+// row, with the same payload the material endpoints send.
+func (m *materialImport) enqueueMaterialEvent(id int, event string, material importedMaterial) error {
+	return enqueueEvent(m.ctx, m.tx, m.tenantID, models.EntityMaterial, id, event, models.Material{
+		ID:         id,
+		Name:       material.Name,
+		Active:     material.Active,
+		CategoryID: material.CategoryID,
+		TenantID:   m.tenantID,
+	})
+}
+
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ImportMaterials accepts a CSV either as the raw request body or as the
+// "file" field of a multipart form. Pass ?dry_run=true to preview. Only
+// raw bodies can carry an Idempotency-Key.
+func ImportMaterials(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := false
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "invalid dry_run", http.StatusBadRequest)
+				return
+			}
+			dryRun = parsed
+		}
+
+		var src io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "missing file", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			src = file
+		}
+
+		report, err := ImportMaterialsCSV(r.Context(), db, src, dryRun)
+		if errors.Is(err, ErrInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error importing materials: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
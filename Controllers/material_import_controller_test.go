@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportMaterials(t *testing.T) {
+	lookup := regexp.QuoteMeta(`SELECT id, name, active, category_id FROM material WHERE LOWER(name) = LOWER($1) AND tenant_id = $2 AND deleted_at IS NULL ORDER BY id LIMIT 1`)
+	insert := regexp.QuoteMeta(`INSERT INTO material (name, active, category_id, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id`)
+	categoryExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM category WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	update := regexp.QuoteMeta(`UPDATE material SET name = $1, active = $2, category_id = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND tenant_id = $5`)
+	affected := regexp.QuoteMeta(`SELECT DISTINCT o.id, o.name, o.status`)
+
+	csv := "name,active\n" +
+		"Steel,true\n" +
+		"Copper,false\n" +
+		"Wood,\n" +
+		",true\n" +
+		"steel,false\n" +
+		"Glass,maybe\n"
+
+	testCases := []struct {
+		name         string
+		url          string
+		body         string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+		expected     map[string]int
+		affected     []int
+	}{
+		{
+			name:         "success - rows created, updated and skipped",
+			url:          "/materials/import",
+			body:         csv,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookup).WithArgs("Steel", "acme").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(insert).WithArgs("Steel", true, nil, "acme").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				expectEvent(mock, "material", "7", models.EventMaterialCreated)
+				mock.ExpectQuery(lookup).WithArgs("Copper", "acme").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "category_id"}).AddRow(3, "copper", false, nil))
+				mock.ExpectExec(update).WithArgs("Copper", false, nil, 3, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectEvent(mock, "material", "3", models.EventMaterialUpdated)
+				mock.ExpectQuery(lookup).WithArgs("Wood", "acme").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "category_id"}).AddRow(4, "Wood", true, nil))
+				mock.ExpectCommit()
+			},
+			expected: map[string]int{models.ImportCreated: 1, models.ImportUpdated: 1, models.ImportSkipped: 1, models.ImportInvalid: 3},
+		},
+		{
+			name:         "dry run - nothing written",
+			url:          "/materials/import?dry_run=true",
+			body:         "name\nSteel\n",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookup).WithArgs("Steel", "acme").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			expected: map[string]int{models.ImportCreated: 1},
+		},
+		{
+			name:         "category column - missing category is invalid, missing column keeps current",
+			url:          "/materials/import",
+			body:         "name,category_id\nSteel,2\nCopper,9\nWood,\n",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(categoryExists).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(lookup).WithArgs("Steel", "acme").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "category_id"}).AddRow(1, "Steel", false, 2))
+				mock.ExpectQuery(categoryExists).WithArgs(9, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(lookup).WithArgs("Wood", "acme").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "category_id"}).AddRow(4, "Wood", true, 2))
+				mock.ExpectExec(update).WithArgs("Wood", true, nil, 4, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectEvent(mock, "material", "4", models.EventMaterialUpdated)
+				mock.ExpectCommit()
+			},
+			expected: map[string]int{models.ImportSkipped: 1, models.ImportInvalid: 1, models.ImportUpdated: 1},
+		},
+		{
+			name:         "deactivation - event lists the affected offers",
+			url:          "/materials/import",
+			body:         "name,active\nSteel,false\n",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookup).WithArgs("Steel", "acme").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "category_id"}).AddRow(5, "Steel", true, nil))
+				mock.ExpectQuery(affected).WithArgs(5, sqlmock.AnyArg(), "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "status"}).AddRow(2, "Hall roof", "sent"))
+				mock.ExpectExec(update).WithArgs("Steel", false, nil, 5, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectEvent(mock, "material", "5", models.EventMaterialUpdated)
+				expectEvent(mock, "material", "5", models.EventMaterialDeactivated)
+				mock.ExpectCommit()
+			},
+			expected: map[string]int{models.ImportUpdated: 1},
+			affected: []int{2},
+		},
+		{
+			name:         "dry run deactivation - report lists the affected offers",
+			url:          "/materials/import?dry_run=true",
+			body:         "name,active\nSteel,false\n",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookup).WithArgs("Steel", "acme").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "category_id"}).AddRow(5, "Steel", true, nil))
+				mock.ExpectQuery(affected).WithArgs(5, sqlmock.AnyArg(), "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "status"}).AddRow(2, "Hall roof", "sent").AddRow(4, "Barn", "draft"))
+				mock.ExpectRollback()
+			},
+			expected: map[string]int{models.ImportUpdated: 1},
+			affected: []int{2, 4},
+		},
+		{
+			name:         "unknown column - every row is invalid",
+			url:          "/materials/import",
+			body:         "name,active,notes\nSteel,true,\nCopper,false,urgent\n",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectCommit()
+			},
+			expected: map[string]int{models.ImportInvalid: 2},
+		},
+		{
+			name:         "failure - missing name column",
+			url:          "/materials/import",
+			body:         "title,active\nSteel,true\n",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - invalid dry_run",
+			url:          "/materials/import?dry_run=perhaps",
+			body:         "name\nSteel\n",
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", tc.url, strings.NewReader(tc.body)), "acme")
+			req.Header.Set("Content-Type", "text/csv")
+			w := httptest.NewRecorder()
+
+			handler := ImportMaterials(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+
+			if tc.expected != nil {
+				var report models.MaterialImportReport
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+				assert.Equal(t, tc.expected[models.ImportCreated], report.Created)
+				assert.Equal(t, tc.expected[models.ImportUpdated], report.Updated)
+				assert.Equal(t, tc.expected[models.ImportSkipped], report.Skipped)
+				assert.Equal(t, tc.expected[models.ImportInvalid], report.Invalid)
+				if tc.affected != nil {
+					var offerIDs []int
+					for _, offer := range report.Rows[0].AffectedOffers {
+						offerIDs = append(offerIDs, offer.OfferID)
+					}
+					assert.Equal(t, tc.affected, offerIDs)
+				}
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tags and custom attributes are stored per entity ("material", "offer")
+// in entity_tag and attribute_value rather than on the records themselves.
+// Both, like the attribute definitions, belong to a tenant.
+
+const maxTagLength = 64
+
+// normalizeTags lower-cases, trims and de-duplicates tags. A nil slice
+// stays nil, meaning "leave the tags alone".
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, badRequest("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// attributeValue is a custom attribute parsed against its definition. Only
+// the field matching the definition's type is set.
+type attributeValue struct {
+	definition models.AttributeDefinition
+	str        *string
+	num        *float64
+	boolean    *bool
+	date       *time.Time
+}
+
+func attributeDefinitions(ctx context.Context, db *sql.DB, entity string) (map[string]models.AttributeDefinition, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, type FROM attribute_definition WHERE entity = $1 AND tenant_id = $2 AND deleted_at IS NULL", entity, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions := map[string]models.AttributeDefinition{}
+	for rows.Next() {
+		definition := models.AttributeDefinition{Entity: entity}
+		if err := rows.Scan(&definition.ID, &definition.Name, &definition.Type); err != nil {
+			return nil, err
+		}
+		definitions[definition.Name] = definition
+	}
+	return definitions, rows.Err()
+}
+
+// parseAttributeValue converts a decoded JSON value to the definition's
+// type. Dates are "YYYY-MM-DD" strings.
+func parseAttributeValue(definition models.AttributeDefinition, raw interface{}) (attributeValue, error) {
+	value := attributeValue{definition: definition}
+	ok := false
+	switch definition.Type {
+	case models.AttributeString:
+		var s string
+		if s, ok = raw.(string); ok {
+			value.str = &s
+		}
+	case models.AttributeNumber:
+		var n float64
+		if n, ok = raw.(float64); ok {
+			value.num = &n
+		}
+	case models.AttributeBool:
+		var b bool
+		if b, ok = raw.(bool); ok {
+			value.boolean = &b
+		}
+	case models.AttributeDate:
+		if s, isString := raw.(string); isString {
+			d, err := time.Parse("2006-01-02", s)
+			if ok = err == nil; ok {
+				value.date = &d
+			}
+		}
+	}
+	if !ok {
+		return value, badRequest("attribute %s must be a %s", definition.Name, definition.Type)
+	}
+	return value, nil
+}
+
+// parseAttributeText parses a query string value, as used by filters.
+func parseAttributeText(definition models.AttributeDefinition, text string) (attributeValue, error) {
+	var raw interface{} = text
+	switch definition.Type {
+	case models.AttributeNumber:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return attributeValue{}, badRequest("attribute %s must be a number", definition.Name)
+		}
+		raw = n
+	case models.AttributeBool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return attributeValue{}, badRequest("attribute %s must be a bool", definition.Name)
+		}
+		raw = b
+	}
+	return parseAttributeValue(definition, raw)
+}
+
+func (v attributeValue) column() (string, interface{}) {
+	switch {
+	case v.str != nil:
+		return "string_value", *v.str
+	case v.num != nil:
+		return "number_value", *v.num
+	case v.boolean != nil:
+		return "bool_value", *v.boolean
+	default:
+		return "date_value", *v.date
+	}
+}
+
+// validateAttributes checks attributes against the entity's schema. A nil
+// map returns nil, meaning "leave the attributes alone".
+func validateAttributes(ctx context.Context, db *sql.DB, entity string, attributes map[string]interface{}) ([]attributeValue, error) {
+	if attributes == nil {
+		return nil, nil
+	}
+	values := []attributeValue{}
+	if len(attributes) == 0 {
+		return values, nil
+	}
+
+	definitions, err := attributeDefinitions(ctx, db, entity)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		definition, ok := definitions[name]
+		if !ok {
+			return nil, badRequest("unknown attribute %s", name)
+		}
+		if attributes[name] == nil {
+			continue
+		}
+		value, err := parseAttributeValue(definition, attributes[name])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// saveMetadata replaces the tags and attributes of one record in tx, the
+// transaction writing the record itself, so a failure leaves neither
+// behind. A nil argument leaves that part untouched.
+func saveMetadata(ctx context.Context, tx *sql.Tx, entity string, id int, tags []string, values []attributeValue) error {
+	if tags == nil && values == nil {
+		return nil
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	if tags != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM entity_tag WHERE entity = $1 AND entity_id = $2 AND tenant_id = $3", entity, id, tenantID); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO entity_tag (entity, entity_id, tag, tenant_id) VALUES ($1, $2, $3, $4)", entity, id, tag, tenantID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if values != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM attribute_value WHERE entity = $1 AND entity_id = $2 AND tenant_id = $3", entity, id, tenantID); err != nil {
+			return err
+		}
+		for _, value := range values {
+			column, v := value.column()
+			query := fmt.Sprintf("INSERT INTO attribute_value (entity, entity_id, attribute_id, %s, tenant_id) VALUES ($1, $2, $3, $4, $5)", column)
+			if _, err := tx.ExecContext(ctx, query, entity, id, value.definition.ID, v, tenantID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadMetadata fetches tags and attributes for a batch of records. Every
+// id gets a non-nil entry so the JSON shows [] and {} rather than null.
+func loadMetadata(ctx context.Context, db *sql.DB, entity string, ids []int) (map[int][]string, map[int]map[string]interface{}, error) {
+	tags := map[int][]string{}
+	attributes := map[int]map[string]interface{}{}
+	for _, id := range ids {
+		tags[id] = []string{}
+		attributes[id] = map[string]interface{}{}
+	}
+	if len(ids) == 0 {
+		return tags, attributes, nil
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	rows, err := db.QueryContext(ctx, "SELECT entity_id, tag FROM entity_tag WHERE entity = $1 AND entity_id = ANY($2) AND tenant_id = $3 ORDER BY entity_id, tag", entity, pq.Array(ids), tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id  int
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT v.entity_id, d.name, v.string_value, v.number_value, v.bool_value, v.date_value
+		FROM attribute_value v
+		JOIN attribute_definition d ON d.id = v.attribute_id AND d.deleted_at IS NULL
+		WHERE v.entity = $1 AND v.entity_id = ANY($2) AND v.tenant_id = $3`, entity, pq.Array(ids), tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id      int
+			name    string
+			str     *string
+			num     *float64
+			boolean *bool
+			date    *time.Time
+		)
+		if err := rows.Scan(&id, &name, &str, &num, &boolean, &date); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case str != nil:
+			attributes[id][name] = *str
+		case num != nil:
+			attributes[id][name] = *num
+		case boolean != nil:
+			attributes[id][name] = *boolean
+		case date != nil:
+			attributes[id][name] = date.Format("2006-01-02")
+		}
+	}
+	return tags, attributes, rows.Err()
+}
+
+// attributeFilterPattern matches raw query parts such as attr.thickness_mm>=3.
+// They are parsed by hand because url.ParseQuery would split "a>=3" into
+// the key "a>" and the value "3".
+var attributeFilterPattern = regexp.MustCompile(`^attr\.([A-Za-z0-9_]+)(>=|<=|!=|=|>|<)(.*)$`)
+
+var attributeFilterOps = map[string]map[string]bool{
+	models.AttributeString: {"=": true, "!=": true},
+	models.AttributeBool:   {"=": true, "!=": true},
+	models.AttributeNumber: {"=": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true},
+	models.AttributeDate:   {"=": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true},
+}
+
+// metadataFilter adds conditions for ?tag=x (repeatable, all must match)
+// and attr.<name><op><value> filters. idColumn is the record id column as
+// it appears in the caller's query; args holds the caller's placeholders.
+func metadataFilter(db *sql.DB, r *http.Request, entity, idColumn string, args []interface{}) (string, []interface{}, error) {
+	filter := ""
+	tenantID := tenant.FromContext(r.Context())
+
+	for _, tag := range r.URL.Query()["tag"] {
+		args = append(args, entity, strings.ToLower(strings.TrimSpace(tag)), tenantID)
+		filter += fmt.Sprintf(" AND %s IN (SELECT entity_id FROM entity_tag WHERE entity = $%d AND tag = $%d AND tenant_id = $%d)", idColumn, len(args)-2, len(args)-1, len(args))
+	}
+
+	var definitions map[string]models.AttributeDefinition
+	for _, part := range strings.Split(r.URL.RawQuery, "&") {
+		part, err := url.QueryUnescape(part)
+		if err != nil || !strings.HasPrefix(part, "attr.") {
+			continue
+		}
+		match := attributeFilterPattern.FindStringSubmatch(part)
+		if match == nil {
+			return "", nil, badRequest("invalid attribute filter %q", part)
+		}
+
+		if definitions == nil {
+			if definitions, err = attributeDefinitions(r.Context(), db, entity); err != nil {
+				return "", nil, err
+			}
+		}
+		definition, ok := definitions[match[1]]
+		if !ok {
+			return "", nil, badRequest("unknown attribute %s", match[1])
+		}
+		if !attributeFilterOps[definition.Type][match[2]] {
+			return "", nil, badRequest("operator %s is not supported for %s attributes", match[2], definition.Type)
+		}
+		value, err := parseAttributeText(definition, match[3])
+		if err != nil {
+			return "", nil, err
+		}
+
+		column, v := value.column()
+		args = append(args, entity, definition.ID, v, tenantID)
+		filter += fmt.Sprintf(" AND %s IN (SELECT entity_id FROM attribute_value WHERE entity = $%d AND attribute_id = $%d AND %s %s $%d AND tenant_id = $%d)",
+			idColumn, len(args)-3, len(args)-2, column, match[2], len(args)-1, len(args))
+	}
+
+	return filter, args, nil
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	tagQuery        = regexp.QuoteMeta(`SELECT entity_id, tag FROM entity_tag WHERE entity = $1 AND entity_id = ANY($2) AND tenant_id = $3 ORDER BY entity_id, tag`)
+	attributeQuery  = regexp.QuoteMeta(`SELECT v.entity_id, d.name, v.string_value, v.number_value, v.bool_value, v.date_value`)
+	attachmentQuery = regexp.QuoteMeta(`FROM attachment WHERE entity = $1 AND entity_id = ANY($2) AND tenant_id = $3 AND deleted_at IS NULL ORDER BY entity_id, id`)
+	imageQuery      = regexp.QuoteMeta(`FROM material_image WHERE material_id = ANY($1) AND tenant_id = $2 AND deleted_at IS NULL ORDER BY material_id, id`)
+	definitionQuery = regexp.QuoteMeta(`SELECT id, name, type FROM attribute_definition WHERE entity = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+)
+
+// expectMetadata expects the tag, attribute and attachment lookups made
+// after loading records, returning no metadata.
+func expectMetadata(mock sqlmock.Sqlmock, entity string) {
+	mock.ExpectQuery(tagQuery).WithArgs(entity, sqlmock.AnyArg(), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"entity_id", "tag"}))
+	mock.ExpectQuery(attributeQuery).WithArgs(entity, sqlmock.AnyArg(), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"entity_id", "name", "string_value", "number_value", "bool_value", "date_value"}))
+	mock.ExpectQuery(attachmentQuery).WithArgs(entity, sqlmock.AnyArg(), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "file_name", "content_type", "size_bytes", "checksum", "uploaded_by", "created_at"}))
+}
+
+// expectMaterialMetadata also expects the image lookup made for materials.
+func expectMaterialMetadata(mock sqlmock.Sqlmock) {
+	expectMetadata(mock, models.EntityMaterial)
+	mock.ExpectQuery(imageQuery).WithArgs(sqlmock.AnyArg(), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "material_id", "file_name", "content_type", "width", "height", "size_bytes", "checksum", "uploaded_by", "created_at"}))
+}
+
+func expectDefinitions(mock sqlmock.Sqlmock, entity string) {
+	mock.ExpectQuery(definitionQuery).WithArgs(entity, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "type"}).
+			AddRow(1, "thickness_mm", models.AttributeNumber).
+			AddRow(2, "grade", models.AttributeString).
+			AddRow(3, "certified_on", models.AttributeDate))
+}
+
+func TestGetMaterialsMetadataFilter(t *testing.T) {
+	testCases := []struct {
+		name         string
+		url          string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "tag and attribute filter",
+			url:          "/materials?tag=Steel&attr.thickness_mm>=3",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectDefinitions(mock, models.EntityMaterial)
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM material WHERE deleted_at IS NULL AND tenant_id = $1`+
+					` AND id IN (SELECT entity_id FROM entity_tag WHERE entity = $2 AND tag = $3 AND tenant_id = $4)`+
+					` AND id IN (SELECT entity_id FROM attribute_value WHERE entity = $5 AND attribute_id = $6 AND number_value >= $7 AND tenant_id = $8)`)).
+					WithArgs("acme", models.EntityMaterial, "steel", "acme", models.EntityMaterial, 1, 3.0, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "created_at", "updated_at", "deleted_at", "category_id", "tenant_id"}).
+						AddRow(1, "Steel sheet", true, time.Now(), time.Now(), nil, nil, "acme"))
+				mock.ExpectQuery(tagQuery).WithArgs(models.EntityMaterial, sqlmock.AnyArg(), "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"entity_id", "tag"}).AddRow(1, "sheet").AddRow(1, "steel"))
+				mock.ExpectQuery(attributeQuery).WithArgs(models.EntityMaterial, sqlmock.AnyArg(), "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"entity_id", "name", "string_value", "number_value", "bool_value", "date_value"}).
+						AddRow(1, "thickness_mm", nil, 4.5, nil, nil).
+						AddRow(1, "certified_on", nil, nil, nil, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+				mock.ExpectQuery(attachmentQuery).WithArgs(models.EntityMaterial, sqlmock.AnyArg(), "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "file_name", "content_type", "size_bytes", "checksum", "uploaded_by", "created_at"}))
+				mock.ExpectQuery(imageQuery).WithArgs(sqlmock.AnyArg(), "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "material_id", "file_name", "content_type", "width", "height", "size_bytes", "checksum", "uploaded_by", "created_at"}).
+						AddRow(2, 1, "sheet.png", "image/png", 800, 600, 1024, "abc", "user-1", time.Now()))
+			},
+		},
+		{
+			name:         "failure - unknown attribute",
+			url:          "/materials?attr.colour=red",
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectDefinitions(mock, models.EntityMaterial)
+			},
+		},
+		{
+			name:         "failure - operator not valid for type",
+			url:          "/materials?attr.grade>=A",
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectDefinitions(mock, models.EntityMaterial)
+			},
+		},
+		{
+			name:         "failure - value of wrong type",
+			url:          "/materials?attr.thickness_mm<thin",
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectDefinitions(mock, models.EntityMaterial)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("GET", tc.url, nil), "acme")
+			w := httptest.NewRecorder()
+
+			handler := GetMaterials(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				var materials []models.Material
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&materials))
+				assert.Equal(t, []string{"sheet", "steel"}, materials[0].Tags)
+				assert.Equal(t, 4.5, materials[0].Attributes["thickness_mm"])
+				assert.Equal(t, "2024-05-01", materials[0].Attributes["certified_on"])
+				assert.Equal(t, "/materials/1/images/2/thumbnail", materials[0].Images[0].ThumbnailURL)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateOfferMetadata(t *testing.T) {
+	update := regexp.QuoteMeta(`UPDATE offer SET name = $1, customer_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 AND deleted_at IS NULL`)
+	lock := regexp.QuoteMeta(`SELECT status, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`)
+	expectLock := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(lock).WithArgs(1, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"status", "customer_id"}).AddRow(models.OfferDraft, nil))
+	}
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - tags and attributes replaced",
+			requestBody:  `{"name": "Offer", "tags": ["Urgent", " urgent ", "export"], "attributes": {"grade": "A", "thickness_mm": 3}}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectDefinitions(mock, models.EntityOffer)
+				mock.ExpectBegin()
+				expectLock(mock)
+				mock.ExpectExec(update).WithArgs("Offer", nil, "1", "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM entity_tag WHERE entity = $1 AND entity_id = $2 AND tenant_id = $3`)).
+					WithArgs(models.EntityOffer, 1, "acme").WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO entity_tag (entity, entity_id, tag, tenant_id) VALUES ($1, $2, $3, $4)`)).
+					WithArgs(models.EntityOffer, 1, "export", "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO entity_tag (entity, entity_id, tag, tenant_id) VALUES ($1, $2, $3, $4)`)).
+					WithArgs(models.EntityOffer, 1, "urgent", "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM attribute_value WHERE entity = $1 AND entity_id = $2 AND tenant_id = $3`)).
+					WithArgs(models.EntityOffer, 1, "acme").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO attribute_value (entity, entity_id, attribute_id, string_value, tenant_id) VALUES ($1, $2, $3, $4, $5)`)).
+					WithArgs(models.EntityOffer, 1, 2, "A", "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO attribute_value (entity, entity_id, attribute_id, number_value, tenant_id) VALUES ($1, $2, $3, $4, $5)`)).
+					WithArgs(models.EntityOffer, 1, 1, 3.0, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectEvent(mock, "offer", "1", models.EventOfferUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - attribute of wrong type",
+			requestBody:  `{"name": "Offer", "attributes": {"certified_on": "yesterday"}}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectDefinitions(mock, models.EntityOffer)
+			},
+		},
+		{
+			name:         "failure - tags not saved rolls back the offer",
+			requestBody:  `{"name": "Offer", "tags": ["urgent"]}`,
+			expectedCode: http.StatusInternalServerError,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLock(mock)
+				mock.ExpectExec(update).WithArgs("Offer", nil, "1", "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM entity_tag WHERE entity = $1 AND entity_id = $2 AND tenant_id = $3`)).
+					WithArgs(models.EntityOffer, 1, "acme").WillReturnError(errors.New("connection reset"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "no metadata - nothing else touched",
+			requestBody:  `{"name": "Offer"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLock(mock)
+				mock.ExpectExec(update).WithArgs("Offer", nil, "1", "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectEvent(mock, "offer", "1", models.EventOfferUpdated)
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("PUT", "/offers/1", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := UpdateOffer(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package controllers
 
 import (
+	"Products/auth"
 	"Products/models"
+	"Products/tenant"
+	"Products/utils"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -11,9 +16,104 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// attachOfferMetadata loads tags, attributes and attachments onto offers.
+func attachOfferMetadata(ctx context.Context, db *sql.DB, offers []models.Offer) error {
+	ids := make([]int, len(offers))
+	for i, offer := range offers {
+		ids[i] = offer.ID
+	}
+	tags, attributes, err := loadMetadata(ctx, db, models.EntityOffer, ids)
+	if err != nil {
+		return err
+	}
+	attachments, err := loadAttachments(ctx, db, models.EntityOffer, ids)
+	if err != nil {
+		return err
+	}
+	for i := range offers {
+		offers[i].Tags = tags[offers[i].ID]
+		offers[i].Attributes = attributes[offers[i].ID]
+		offers[i].Attachments = attachments[offers[i].ID]
+	}
+	return nil
+}
+
+// prepareOfferMetadata normalizes and validates the tags and attributes
+// sent with a create or update.
+func prepareOfferMetadata(ctx context.Context, db *sql.DB, offer *models.Offer) ([]attributeValue, error) {
+	tags, err := normalizeTags(offer.Tags)
+	if err != nil {
+		return nil, err
+	}
+	offer.Tags = tags
+	return validateAttributes(ctx, db, models.EntityOffer, offer.Attributes)
+}
+
+// offerFilter builds the WHERE conditions for the request's tenant,
+// ?status=, ?owner= and the metadata filters. owner is a principal ID, "me"
+// for the caller or "none" for unassigned offers. prefix qualifies the
+// offer columns, e.g. "o." in joins.
+func offerFilter(db *sql.DB, r *http.Request, prefix string) (string, []interface{}, error) {
+	filter := fmt.Sprintf(" AND %stenant_id = $1", prefix)
+	args := []interface{}{tenant.FromContext(r.Context())}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		if _, ok := offerTransitions[status]; !ok {
+			return "", nil, badRequest("invalid status")
+		}
+		args = append(args, status)
+		filter += fmt.Sprintf(" AND %sstatus = $%d", prefix, len(args))
+	}
+
+	switch owner := r.URL.Query().Get("owner"); owner {
+	case "":
+	case "none":
+		filter += fmt.Sprintf(" AND %sowner_id IS NULL", prefix)
+	default:
+		if owner == "me" {
+			p, _ := auth.FromContext(r.Context())
+			owner = p.ID
+		}
+		args = append(args, owner)
+		filter += fmt.Sprintf(" AND %sowner_id = $%d", prefix, len(args))
+	}
+
+	metadata, args, err := metadataFilter(db, r, models.EntityOffer, prefix+"id", args)
+	if err != nil {
+		return "", nil, err
+	}
+	return filter + metadata, args, nil
+}
+
+// scanOffer scans a SELECT * row of the offer table.
+func scanOffer(row interface{ Scan(...interface{}) error }, offer *models.Offer) error {
+	return row.Scan(&offer.ID, &offer.Name, &offer.CreatedAt, &offer.UpdatedAt, &offer.DeletedAt, &offer.Status, &offer.ValidUntil, &offer.TenantID, &offer.OwnerID, &offer.CustomerID)
+}
+
 func GetOffers(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM offer WHERE deleted_at IS NULL")
+		format, err := utils.ExportFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if format != "" {
+			filter, args, err := offerFilter(db, r, "o.")
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			exportQuery(r.Context(), db, w, format, "offers", offerExportColumns, scanOfferExportRow, offerExportQuery+filter+" ORDER BY o.id, om.id", args...)
+			return
+		}
+
+		filter, args, err := offerFilter(db, r, "")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT * FROM offer WHERE deleted_at IS NULL"+filter, args...)
 		if err != nil {
 			log.Printf("Error querying database: %v", err) // Use log.Printf instead of Fatal
 			http.Error(w, "database error", http.StatusInternalServerError)
@@ -24,7 +124,7 @@ func GetOffers(db *sql.DB) http.HandlerFunc {
 		offers := []models.Offer{}
 		for rows.Next() {
 			var offer models.Offer
-			if err := rows.Scan(&offer.ID, &offer.Name, &offer.CreatedAt, &offer.UpdatedAt, &offer.DeletedAt); err != nil {
+			if err := scanOffer(rows, &offer); err != nil {
 				log.Printf("Error scanning rows: %v", err)
 				http.Error(w, "error processing database results", http.StatusInternalServerError)
 				return
@@ -36,6 +136,14 @@ func GetOffers(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "error iterating over results", http.StatusInternalServerError)
 			return
 		}
+		if err := attachOfferMetadata(r.Context(), db, offers); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := localizeOffers(w, r, db, offers); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(offers)
@@ -48,12 +156,21 @@ func GetOfferByID(db *sql.DB) http.HandlerFunc {
 		id := vars["id"]
 
 		var offer models.Offer
-		err := db.QueryRow("SELECT * FROM offer WHERE id = $1 AND deleted_at IS NULL", id).
-			Scan(&offer.ID, &offer.Name, &offer.CreatedAt, &offer.UpdatedAt, &offer.DeletedAt)
+		err := scanOffer(db.QueryRowContext(r.Context(), "SELECT * FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context())), &offer)
 		if err != nil {
 			http.Error(w, "Offer not found", http.StatusNotFound)
 			return
 		}
+		offers := []models.Offer{offer}
+		if err := attachOfferMetadata(r.Context(), db, offers); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := localizeOffers(w, r, db, offers); err != nil {
+			writeError(w, err)
+			return
+		}
+		offer = offers[0]
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(offer)
@@ -86,13 +203,47 @@ func CreateOffer(db *sql.DB) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		values, err := prepareOfferMetadata(r.Context(), db, &offer)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
-		err := db.QueryRow("INSERT INTO offer (name) VALUES ($1) RETURNING id, created_at, updated_at", offer.Name).
+		// New offers always start as drafts owned by their creator; status
+		// and owner changes go through their own endpoints.
+		offer.Status, offer.ValidUntil, offer.OwnerID = models.OfferDraft, nil, nil
+		if p, ok := auth.FromContext(r.Context()); ok {
+			offer.OwnerID = &p.ID
+		}
+		offer.TenantID = tenant.FromContext(r.Context())
+		if err := checkCustomer(r.Context(), db, offer.CustomerID); err != nil {
+			writeError(w, err)
+			return
+		}
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		err = tx.QueryRowContext(r.Context(), "INSERT INTO offer (name, tenant_id, owner_id, customer_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at", offer.Name, offer.TenantID, offer.OwnerID, offer.CustomerID).
 			Scan(&offer.ID, &offer.CreatedAt, &offer.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := enqueueEvent(r.Context(), tx, offer.TenantID, models.EntityOffer, offer.ID, models.EventOfferCreated, offer); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := saveMetadata(r.Context(), tx, models.EntityOffer, offer.ID, offer.Tags, values); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated) // Explicitly set the status code to 201
@@ -100,22 +251,103 @@ func CreateOffer(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+// mergeOfferCustomer locks the offer and returns the customer it keeps
+// after an update. An omitted customer_id keeps the stored customer; an
+// explicit one, null included, replaces it, but only on drafts, since
+// any later offer has been addressed to its customer.
+func mergeOfferCustomer(ctx context.Context, tx *sql.Tx, offerID int, customerID *int, given bool) (*int, error) {
+	var (
+		status string
+		stored *int
+	)
+	err := tx.QueryRowContext(ctx, "SELECT status, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", offerID, tenant.FromContext(ctx)).
+		Scan(&status, &stored)
+	if err == sql.ErrNoRows {
+		return nil, notFound("Offer not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !given {
+		return stored, nil
+	}
+	if status != models.OfferDraft && !equalIntPtr(stored, customerID) {
+		return nil, conflict("offer is %s; only the customer of a draft offer can be changed", status)
+	}
+	return customerID, nil
+}
+
 func UpdateOffer(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id := vars["id"]
+		offerID, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
 
-		var offer models.Offer
-		if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
+		var (
+			body   json.RawMessage
+			offer  models.Offer
+			fields map[string]json.RawMessage
+		)
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(body, &offer); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		json.Unmarshal(body, &fields)
+		_, customerGiven := fields["customer_id"]
+		values, err := prepareOfferMetadata(r.Context(), db, &offer)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := authorizeOfferEdit(r.Context(), db, offerOwnerQuery, offerID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkCustomer(r.Context(), db, offer.CustomerID); err != nil {
+			writeError(w, err)
+			return
+		}
 
-		_, err := db.Exec("UPDATE offer SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND deleted_at IS NULL", offer.Name, id)
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		if offer.CustomerID, err = mergeOfferCustomer(r.Context(), tx, offerID, offer.CustomerID, customerGiven); err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := tx.ExecContext(r.Context(), "UPDATE offer SET name = $1, customer_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 AND deleted_at IS NULL", offer.Name, offer.CustomerID, id, tenant.FromContext(r.Context()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		if err := saveMetadata(r.Context(), tx, models.EntityOffer, offerID, offer.Tags, values); err != nil {
+			writeError(w, err)
+			return
+		}
+		offer.ID = offerID
+		if err := enqueueEvent(r.Context(), tx, tenant.FromContext(r.Context()), models.EntityOffer, offerID, models.EventOfferUpdated, offer); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(offer)
@@ -152,8 +384,20 @@ func DeleteOffer(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if err := authorizeOfferEdit(r.Context(), db, offerOwnerQuery, id); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
 		// Execute soft delete query
-		res, err := db.Exec("UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+		res, err := tx.ExecContext(r.Context(), "UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -166,6 +410,20 @@ func DeleteOffer(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// A deleted accepted offer no longer holds its stock.
+		if err := releaseOfferStock(r.Context(), tx, id); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := enqueueEvent(r.Context(), tx, tenant.FromContext(r.Context()), models.EntityOffer, id, models.EventOfferDeleted, models.OfferDeletedEvent{OfferID: id}); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
 		// Return 204 No Content if deletion was successful
 		w.WriteHeader(http.StatusNoContent)
 	}
@@ -54,8 +54,8 @@ func TestGetOffers(t *testing.T) {
 		{
 			name: "success - offers found",
 			mockData: [][]interface{}{
-				{1, "Offer1", time.Now(), time.Now(), nil},
-				{2, "Offer2", time.Now(), time.Now(), nil},
+				{1, "Offer1", time.Now(), time.Now(), nil, "draft", nil, "acme", "user-1", 7},
+				{2, "Offer2", time.Now(), time.Now(), nil, "accepted", nil, "acme", nil, nil},
 			},
 			expectedLen:  2,
 			expectedCode: http.StatusOK,
@@ -77,12 +77,12 @@ func TestGetOffers(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			query := `SELECT \* FROM offer WHERE deleted_at IS NULL`
+			query := regexp.QuoteMeta(`SELECT * FROM offer WHERE deleted_at IS NULL AND tenant_id = $1`)
 
 			if tc.mockError != nil {
 				mock.ExpectQuery(query).WillReturnError(tc.mockError)
 			} else {
-				rows := sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at"})
+				rows := sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at", "status", "valid_until", "tenant_id", "owner_id", "customer_id"})
 				for _, row := range tc.mockData {
 					var values []driver.Value
 					for _, v := range row {
@@ -91,9 +91,12 @@ func TestGetOffers(t *testing.T) {
 					rows.AddRow(values...)
 				}
 				mock.ExpectQuery(query).WillReturnRows(rows)
+				if len(tc.mockData) > 0 {
+					expectMetadata(mock, models.EntityOffer)
+				}
 			}
 
-			req := httptest.NewRequest("GET", "/offers", nil)
+			req := withTenant(httptest.NewRequest("GET", "/offers", nil), "acme")
 			w := httptest.NewRecorder()
 
 			handler := GetOffers(db)
@@ -119,6 +122,7 @@ func TestGetOfferByID(t *testing.T) {
 	type testCase struct {
 		name      string
 		offerID   string
+		tenant    string
 		mockData  []interface{}
 		expectErr bool
 		mockError error
@@ -128,20 +132,30 @@ func TestGetOfferByID(t *testing.T) {
 		{
 			name:    "success - valid offer",
 			offerID: "1",
+			tenant:  "acme",
 			mockData: []interface{}{
-				1, "Premium Plan", time.Now(), time.Now(), nil,
+				1, "Premium Plan", time.Now(), time.Now(), nil, "draft", nil, "acme", "user-1", 7,
 			},
 			expectErr: false,
 		},
 		{
 			name:      "offer not found",
 			offerID:   "99",
+			tenant:    "acme",
+			mockData:  nil,
+			expectErr: true,
+		},
+		{
+			name:      "offer of another tenant",
+			offerID:   "1",
+			tenant:    "globex",
 			mockData:  nil,
 			expectErr: true,
 		},
 		{
 			name:      "database error",
 			offerID:   "1",
+			tenant:    "acme",
 			mockData:  nil,
 			mockError: errors.New("database error"),
 			expectErr: true,
@@ -153,23 +167,26 @@ func TestGetOfferByID(t *testing.T) {
 			db, mock, err := sqlmock.New()
 			assert.NoError(t, err)
 
-			query := regexp.QuoteMeta(`SELECT * FROM offer WHERE id = $1 AND deleted_at IS NULL`)
+			query := regexp.QuoteMeta(`SELECT * FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
 
 			if tc.mockError != nil {
-				mock.ExpectQuery(query).WithArgs(tc.offerID).WillReturnError(tc.mockError)
+				mock.ExpectQuery(query).WithArgs(tc.offerID, tc.tenant).WillReturnError(tc.mockError)
 			} else if tc.mockData != nil {
 				rowValues := make([]driver.Value, len(tc.mockData))
 				for i, v := range tc.mockData {
 					rowValues[i] = v
 				}
 
-				rows := sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at"}).
+				rows := sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at", "status", "valid_until", "tenant_id", "owner_id", "customer_id"}).
 					AddRow(rowValues...)
 
-				mock.ExpectQuery(query).WithArgs(tc.offerID).WillReturnRows(rows).RowsWillBeClosed()
+				mock.ExpectQuery(query).WithArgs(tc.offerID, tc.tenant).WillReturnRows(rows).RowsWillBeClosed()
+				expectMetadata(mock, models.EntityOffer)
+			} else {
+				mock.ExpectQuery(query).WithArgs(tc.offerID, tc.tenant).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 			}
 
-			req := httptest.NewRequest("GET", "/offer/"+tc.offerID, nil)
+			req := withTenant(httptest.NewRequest("GET", "/offer/"+tc.offerID, nil), tc.tenant)
 			w := httptest.NewRecorder()
 			req = mux.SetURLVars(req, map[string]string{"id": tc.offerID})
 
@@ -212,10 +229,13 @@ func TestCreateOffer(t *testing.T) {
 			requestBody:  `{"name": "Premium Offer"}`,
 			expectedCode: http.StatusCreated,
 			mockQueries: func() {
-				mock.ExpectQuery(`INSERT INTO offer \(name\) VALUES \(\$1\) RETURNING id, created_at, updated_at`).
-					WithArgs("Premium Offer").
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO offer \(name, tenant_id, owner_id, customer_id\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at, updated_at`).
+					WithArgs("Premium Offer", "acme", nil, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
 						AddRow(1, time.Now(), time.Now()))
+				expectEvent(mock, "offer", "1", models.EventOfferCreated)
+				mock.ExpectCommit()
 			},
 		},
 		{
@@ -231,9 +251,11 @@ func TestCreateOffer(t *testing.T) {
 			requestBody:  `{"name": "Standard Offer"}`,
 			expectedCode: http.StatusInternalServerError,
 			mockQueries: func() {
-				mock.ExpectQuery(`INSERT INTO offer \(name\) VALUES \(\$1\) RETURNING id, created_at, updated_at`).
-					WithArgs("Standard Offer").
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO offer \(name, tenant_id, owner_id, customer_id\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at, updated_at`).
+					WithArgs("Standard Offer", "acme", nil, nil).
 					WillReturnError(errors.New("insert error"))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -242,7 +264,7 @@ func TestCreateOffer(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.mockQueries()
 
-			req := httptest.NewRequest("POST", "/offers", strings.NewReader(tc.requestBody))
+			req := withTenant(httptest.NewRequest("POST", "/offers", strings.NewReader(tc.requestBody)), "acme")
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
@@ -260,6 +282,13 @@ func TestUpdateOffer(t *testing.T) {
 	assert.NoError(t, err)
 	defer db.Close()
 
+	lock := regexp.QuoteMeta(`SELECT status, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`)
+	update := `UPDATE offer SET name = \$1, customer_id = \$2, updated_at = CURRENT_TIMESTAMP WHERE id = \$3 AND tenant_id = \$4 AND deleted_at IS NULL`
+	expectLock := func(status string, customerID interface{}) {
+		mock.ExpectQuery(lock).WithArgs(1, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"status", "customer_id"}).AddRow(status, customerID))
+	}
+
 	testCases := []struct {
 		name         string
 		offerID      string
@@ -273,9 +302,65 @@ func TestUpdateOffer(t *testing.T) {
 			requestBody:  `{"name": "Updated Offer Name"}`,
 			expectedCode: http.StatusOK,
 			mockQueries: func() {
-				mock.ExpectExec(`UPDATE offer SET name = \$1, updated_at = CURRENT_TIMESTAMP WHERE id = \$2 AND deleted_at IS NULL`).
-					WithArgs("Updated Offer Name", "1").
+				mock.ExpectBegin()
+				expectLock(models.OfferDraft, nil)
+				mock.ExpectExec(update).
+					WithArgs("Updated Offer Name", nil, "1", "acme").
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "offer", "1", models.EventOfferUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - renaming a sent offer keeps its customer",
+			offerID:      "1",
+			requestBody:  `{"name": "Renamed"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				expectLock(models.OfferSent, 7)
+				mock.ExpectExec(update).
+					WithArgs("Renamed", 7, "1", "acme").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "offer", "1", models.EventOfferUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - draft customer cleared with null",
+			offerID:      "1",
+			requestBody:  `{"name": "Draft", "customer_id": null}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				expectLock(models.OfferDraft, 7)
+				mock.ExpectExec(update).
+					WithArgs("Draft", nil, "1", "acme").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "offer", "1", models.EventOfferUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - customer of a sent offer cleared",
+			offerID:      "1",
+			requestBody:  `{"name": "Renamed", "customer_id": null}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				expectLock(models.OfferSent, 7)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - offer not found",
+			offerID:      "1",
+			requestBody:  `{"name": "Renamed"}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lock).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "customer_id"}))
+				mock.ExpectRollback()
 			},
 		},
 		{
@@ -291,9 +376,12 @@ func TestUpdateOffer(t *testing.T) {
 			requestBody:  `{"name": "New Offer Name"}`,
 			expectedCode: http.StatusInternalServerError,
 			mockQueries: func() {
-				mock.ExpectExec(`UPDATE offer SET name = \$1, updated_at = CURRENT_TIMESTAMP WHERE id = \$2 AND deleted_at IS NULL`).
-					WithArgs("New Offer Name", "1").
+				mock.ExpectBegin()
+				expectLock(models.OfferDraft, nil)
+				mock.ExpectExec(update).
+					WithArgs("New Offer Name", nil, "1", "acme").
 					WillReturnError(errors.New("update error"))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -302,7 +390,7 @@ func TestUpdateOffer(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.mockQueries()
 
-			req := httptest.NewRequest("PUT", "/offers/"+tc.offerID, strings.NewReader(tc.requestBody))
+			req := withTenant(httptest.NewRequest("PUT", "/offers/"+tc.offerID, strings.NewReader(tc.requestBody)), "acme")
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 			req = mux.SetURLVars(req, map[string]string{"id": tc.offerID})
@@ -333,9 +421,15 @@ func TestDeleteOffer(t *testing.T) {
 			offerID:      1,
 			expectedCode: http.StatusNoContent,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(1).
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+					WithArgs(1, "acme").
 					WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
+				mock.ExpectExec(`UPDATE stock_reservation SET released_at = CURRENT_TIMESTAMP WHERE offer_id = ANY\(\$1\)`).
+					WithArgs("{1}").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				expectEvent(mock, "offer", "1", models.EventOfferDeleted)
+				mock.ExpectCommit()
 			},
 		},
 		{
@@ -343,9 +437,23 @@ func TestDeleteOffer(t *testing.T) {
 			offerID:      99,
 			expectedCode: http.StatusNotFound,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(99).
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+					WithArgs(99, "acme").
 					WillReturnResult(sqlmock.NewResult(0, 0)) // 0 rows affected
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - offer already deleted",
+			offerID:      1,
+			expectedCode: http.StatusNotFound,
+			mockExec: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+					WithArgs(1, "acme").
+					WillReturnResult(sqlmock.NewResult(0, 0)) // deleted by an earlier request
+				mock.ExpectRollback()
 			},
 		},
 		{
@@ -353,9 +461,11 @@ func TestDeleteOffer(t *testing.T) {
 			offerID:      1,
 			expectedCode: http.StatusInternalServerError,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs(1).
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE offer SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+					WithArgs(1, "acme").
 					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -364,7 +474,7 @@ func TestDeleteOffer(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.mockExec()
 
-			req := httptest.NewRequest("DELETE", fmt.Sprintf("/offer/%d", tc.offerID), nil)
+			req := withTenant(httptest.NewRequest("DELETE", fmt.Sprintf("/offer/%d", tc.offerID), nil), "acme")
 			req = mux.SetURLVars(req, map[string]string{"id": fmt.Sprintf("%d", tc.offerID)})
 			w := httptest.NewRecorder()
 
@@ -1,32 +1,194 @@
 package controllers
 
 import (
+	"Products/config"
 	"Products/models"
+	"Products/tenant"
+	"Products/utils"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 )
 
+// decodeOfferMaterial reads an offer line and reports whether the body set
+// unit_price, so an omitted price can be told apart from an explicit 0.
+func decodeOfferMaterial(body io.Reader, offerMaterial *models.OfferMaterial) (bool, error) {
+	var input struct {
+		models.OfferMaterial
+		UnitPrice *float64 `json:"unit_price"`
+	}
+	if err := json.NewDecoder(body).Decode(&input); err != nil {
+		return false, err
+	}
+	*offerMaterial = input.OfferMaterial
+	if input.UnitPrice == nil {
+		return false, nil
+	}
+	offerMaterial.UnitPrice = *input.UnitPrice
+	return true, nil
+}
+
+// defaultUnitPrice prices a material at its preferred supplier's cost plus
+// the configured markup, rounded to cents. Materials without a preferred
+// supplier are priced at 0.
+func defaultUnitPrice(ctx context.Context, db *sql.DB, materialID int) (float64, error) {
+	var cost float64
+	err := db.QueryRowContext(ctx, `SELECT ms.purchase_price FROM material_supplier ms
+		JOIN supplier s ON s.id = ms.supplier_id
+		WHERE ms.material_id = $1 AND ms.tenant_id = $2 AND ms.preferred AND ms.deleted_at IS NULL AND s.deleted_at IS NULL`, materialID, tenant.FromContext(ctx)).Scan(&cost)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return listPrice(cost), nil
+}
+
+// listPrice adds the configured markup to a purchase price, rounded to
+// cents.
+func listPrice(cost float64) float64 {
+	return math.Round(cost*(1+config.MarkupPercent()/100)*100) / 100
+}
+
+// validateOfferMaterial defaults a missing quantity to 1 and returns a
+// message for values that cannot be stored.
+func validateOfferMaterial(offerMaterial *models.OfferMaterial) string {
+	if offerMaterial.Quantity == 0 {
+		offerMaterial.Quantity = 1
+	}
+	if offerMaterial.Quantity < 0 {
+		return "quantity must be positive"
+	}
+	if offerMaterial.UnitPrice < 0 {
+		return "unit_price must not be negative"
+	}
+	return ""
+}
+
+// checkOfferMaterialLink enforces the rules for pointing an offer line at
+// a material: both must exist in the tenant of ctx, so lines never link
+// tenants, the caller must be allowed to edit the offer, which must still
+// be a draft, and an inactive material cannot be added. A line that keeps
+// its current material (currentMaterialID, 0 for new lines) on its own
+// offer is left alone even if that material was deactivated since. The
+// offer row stays locked until tx ends, so its status cannot change before
+// the line is written.
+func checkOfferMaterialLink(ctx context.Context, tx *sql.Tx, offerID, materialID, currentMaterialID int) error {
+	tenantID := tenant.FromContext(ctx)
+	var status string
+	var owner *string
+	err := tx.QueryRowContext(ctx, "SELECT status, owner_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", offerID, tenantID).Scan(&status, &owner)
+	if err == sql.ErrNoRows {
+		return badRequest("offer not found")
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkOfferLineStatus(ctx, status, owner); err != nil {
+		return err
+	}
+
+	var active bool
+	err = tx.QueryRowContext(ctx, "SELECT active FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", materialID, tenantID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return badRequest("material not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !active && materialID != currentMaterialID {
+		return badRequest("material %d is inactive and cannot be added to an offer", materialID)
+	}
+	return nil
+}
+
+// checkOfferLineStatus allows line changes only on draft offers the caller
+// may edit. A sent offer is revised back to draft first, and goes through
+// approval again when it is resent. Lines of an accepted offer are what
+// its stock reservation is based on and stay final.
+func checkOfferLineStatus(ctx context.Context, status string, owner *string) error {
+	if err := checkOfferOwner(ctx, owner); err != nil {
+		return err
+	}
+	if status != models.OfferDraft {
+		return conflict("offer is %s; only lines of draft offers can be changed", status)
+	}
+	return nil
+}
+
+// lockOfferLine loads the offer and material of line id and checks that
+// the offer can be changed, locking both rows until tx ends.
+func lockOfferLine(ctx context.Context, tx *sql.Tx, id int) (models.OfferMaterialRemovedEvent, error) {
+	line := models.OfferMaterialRemovedEvent{OfferMaterialID: id}
+	var status string
+	var owner *string
+	err := tx.QueryRowContext(ctx, `SELECT om.offer_id, om.material_id, o.status, o.owner_id FROM offer_material om JOIN offer o ON o.id = om.offer_id
+		WHERE om.id = $1 AND om.tenant_id = $2 AND om.deleted_at IS NULL FOR UPDATE`, id, tenant.FromContext(ctx)).Scan(&line.OfferID, &line.MaterialID, &status, &owner)
+	if err == sql.ErrNoRows {
+		return line, notFound("Offer material not found")
+	}
+	if err != nil {
+		return line, err
+	}
+	return line, checkOfferLineStatus(ctx, status, owner)
+}
+
+// writeOfferMaterialConflict answers 409 with the live line already
+// linking the material to the offer.
+func writeOfferMaterialConflict(ctx context.Context, db *sql.DB, w http.ResponseWriter, offerID, materialID int) {
+	var existing models.OfferMaterial
+	err := db.QueryRowContext(ctx, "SELECT * FROM offer_material WHERE offer_id = $1 AND material_id = $2 AND tenant_id = $3 AND deleted_at IS NULL", offerID, materialID, tenant.FromContext(ctx)).
+		Scan(&existing.ID, &existing.OfferID, &existing.MaterialID, &existing.CreatedAt, &existing.UpdatedAt, &existing.DeletedAt, &existing.Quantity, &existing.UnitPrice, &existing.TenantID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeConflict(w, "material already on this offer", existing)
+}
+
 func GetOfferMaterials(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM offer_material WHERE deleted_at IS NULL")
+		format, err := utils.ExportFormat(r)
 		if err != nil {
-			log.Fatal(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tenantID := tenant.FromContext(r.Context())
+		if format != "" {
+			exportQuery(r.Context(), db, w, format, "offer-materials", offerMaterialExportColumns, scanOfferMaterialExportRow,
+				"SELECT id, offer_id, material_id, quantity, unit_price, created_at, updated_at FROM offer_material WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id", tenantID)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT * FROM offer_material WHERE tenant_id = $1 AND deleted_at IS NULL", tenantID)
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
 		}
 		defer rows.Close()
 
 		offerMaterials := []models.OfferMaterial{}
 		for rows.Next() {
 			var offerMaterial models.OfferMaterial
-			if err := rows.Scan(&offerMaterial.ID, &offerMaterial.OfferID, &offerMaterial.MaterialID, &offerMaterial.CreatedAt, &offerMaterial.UpdatedAt, &offerMaterial.DeletedAt); err != nil {
-				log.Fatal(err)
+			if err := rows.Scan(&offerMaterial.ID, &offerMaterial.OfferID, &offerMaterial.MaterialID, &offerMaterial.CreatedAt, &offerMaterial.UpdatedAt, &offerMaterial.DeletedAt, &offerMaterial.Quantity, &offerMaterial.UnitPrice, &offerMaterial.TenantID); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
 			}
 			offerMaterials = append(offerMaterials, offerMaterial)
 		}
 		if err := rows.Err(); err != nil {
-			log.Fatal(err)
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -40,8 +202,8 @@ func GetOfferMaterialByID(db *sql.DB) http.HandlerFunc {
 		id := vars["id"]
 
 		var offerMaterial models.OfferMaterial
-		err := db.QueryRow("SELECT * FROM offer_material WHERE id = $1 AND deleted_at IS NULL", id).
-			Scan(&offerMaterial.ID, &offerMaterial.OfferID, &offerMaterial.MaterialID, &offerMaterial.CreatedAt, &offerMaterial.UpdatedAt, &offerMaterial.DeletedAt)
+		err := db.QueryRowContext(r.Context(), "SELECT * FROM offer_material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context())).
+			Scan(&offerMaterial.ID, &offerMaterial.OfferID, &offerMaterial.MaterialID, &offerMaterial.CreatedAt, &offerMaterial.UpdatedAt, &offerMaterial.DeletedAt, &offerMaterial.Quantity, &offerMaterial.UnitPrice, &offerMaterial.TenantID)
 		if err != nil {
 			http.Error(w, "OfferMaterial not found", http.StatusNotFound)
 			return
@@ -55,17 +217,52 @@ func GetOfferMaterialByID(db *sql.DB) http.HandlerFunc {
 func CreateOfferMaterial(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var offerMaterial models.OfferMaterial
-		if err := json.NewDecoder(r.Body).Decode(&offerMaterial); err != nil {
+		priced, err := decodeOfferMaterial(r.Body, &offerMaterial)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if !priced {
+			if offerMaterial.UnitPrice, err = defaultUnitPrice(r.Context(), db, offerMaterial.MaterialID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
 
-		err := db.QueryRow("INSERT INTO offer_material (offer_id, material_id) VALUES ($1, $2) RETURNING id, created_at, updated_at", offerMaterial.OfferID, offerMaterial.MaterialID).
+		if msg := validateOfferMaterial(&offerMaterial); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		offerMaterial.TenantID = tenant.FromContext(r.Context())
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		if err := checkOfferMaterialLink(r.Context(), tx, offerMaterial.OfferID, offerMaterial.MaterialID, 0); err != nil {
+			writeError(w, err)
+			return
+		}
+		err = tx.QueryRowContext(r.Context(), "INSERT INTO offer_material (offer_id, material_id, quantity, unit_price, tenant_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at", offerMaterial.OfferID, offerMaterial.MaterialID, offerMaterial.Quantity, offerMaterial.UnitPrice, offerMaterial.TenantID).
 			Scan(&offerMaterial.ID, &offerMaterial.CreatedAt, &offerMaterial.UpdatedAt)
+		if isUniqueViolation(err, offerMaterialIndex) {
+			writeOfferMaterialConflict(r.Context(), db, w, offerMaterial.OfferID, offerMaterial.MaterialID)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := enqueueEvent(r.Context(), tx, offerMaterial.TenantID, models.EntityOffer, offerMaterial.OfferID, models.EventOfferMaterialAdded, offerMaterial); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(offerMaterial)
@@ -75,21 +272,178 @@ func CreateOfferMaterial(db *sql.DB) http.HandlerFunc {
 func UpdateOfferMaterial(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
 
 		var offerMaterial models.OfferMaterial
-		if err := json.NewDecoder(r.Body).Decode(&offerMaterial); err != nil {
+		priced, err := decodeOfferMaterial(r.Body, &offerMaterial)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if !priced {
+			if offerMaterial.UnitPrice, err = defaultUnitPrice(r.Context(), db, offerMaterial.MaterialID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
 
-		_, err := db.Exec("UPDATE offer_material SET offer_id = $1, material_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND deleted_at IS NULL", offerMaterial.OfferID, offerMaterial.MaterialID, id)
+		if msg := validateOfferMaterial(&offerMaterial); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		tenantID := tenant.FromContext(r.Context())
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		// Moving a line needs the right to edit the offer it leaves too.
+		current, err := lockOfferLine(r.Context(), tx, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		// Moving a line with a deactivated material to another offer would
+		// add that material there, so only a line that stays is exempt.
+		keptMaterialID := 0
+		if offerMaterial.OfferID == current.OfferID {
+			keptMaterialID = current.MaterialID
+		}
+		if err := checkOfferMaterialLink(r.Context(), tx, offerMaterial.OfferID, offerMaterial.MaterialID, keptMaterialID); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		offerMaterial.ID, offerMaterial.TenantID = id, tenantID
+		err = tx.QueryRowContext(r.Context(), "UPDATE offer_material SET offer_id = $1, material_id = $2, quantity = $3, unit_price = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5 AND tenant_id = $6 AND deleted_at IS NULL RETURNING created_at, updated_at", offerMaterial.OfferID, offerMaterial.MaterialID, offerMaterial.Quantity, offerMaterial.UnitPrice, id, tenantID).
+			Scan(&offerMaterial.CreatedAt, &offerMaterial.UpdatedAt)
+		if isUniqueViolation(err, offerMaterialIndex) {
+			writeOfferMaterialConflict(r.Context(), db, w, offerMaterial.OfferID, offerMaterial.MaterialID)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// A moved line leaves one offer and joins another, so each offer's
+		// events describe its own lines.
+		if current.OfferID != offerMaterial.OfferID {
+			if err := enqueueEvent(r.Context(), tx, tenantID, models.EntityOffer, current.OfferID, models.EventOfferMaterialRemoved, current); err != nil {
+				writeError(w, err)
+				return
+			}
+			err = enqueueEvent(r.Context(), tx, tenantID, models.EntityOffer, offerMaterial.OfferID, models.EventOfferMaterialAdded, offerMaterial)
+		} else {
+			err = enqueueEvent(r.Context(), tx, tenantID, models.EntityOffer, offerMaterial.OfferID, models.EventOfferMaterialUpdated, offerMaterial)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(offerMaterial)
+	}
+}
+
+// UpsertOfferMaterial sets the line for a material on an offer, creating it
+// (201) or updating it (200). An omitted unit_price keeps the price of an
+// existing line and defaults a new one from the preferred supplier.
+func UpsertOfferMaterial(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		offerID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		materialID, err := strconv.Atoi(vars["material_id"])
+		if err != nil {
+			http.Error(w, "invalid material_id", http.StatusBadRequest)
+			return
+		}
+
+		var offerMaterial models.OfferMaterial
+		priced, err := decodeOfferMaterial(r.Body, &offerMaterial)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		offerMaterial.OfferID, offerMaterial.MaterialID = offerID, materialID
+		if !priced {
+			if offerMaterial.UnitPrice, err = defaultUnitPrice(r.Context(), db, materialID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if msg := validateOfferMaterial(&offerMaterial); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		offerMaterial.TenantID = tenant.FromContext(r.Context())
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		var exists bool
+		err = tx.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM offer_material WHERE offer_id = $1 AND material_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)", offerID, materialID, offerMaterial.TenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		currentMaterialID := 0
+		if exists {
+			currentMaterialID = materialID
+		}
+		if err := checkOfferMaterialLink(r.Context(), tx, offerID, materialID, currentMaterialID); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// The link check put the offer in this tenant, so a conflicting
+		// line is too.
+		var inserted bool
+		err = tx.QueryRowContext(r.Context(), `INSERT INTO offer_material (offer_id, material_id, quantity, unit_price, tenant_id) VALUES ($1, $2, $3, $4, $6)
+			ON CONFLICT (offer_id, material_id) WHERE deleted_at IS NULL
+			DO UPDATE SET quantity = EXCLUDED.quantity,
+				unit_price = CASE WHEN $5 THEN EXCLUDED.unit_price ELSE offer_material.unit_price END,
+				updated_at = CURRENT_TIMESTAMP
+			RETURNING id, unit_price, created_at, updated_at, xmax = 0`,
+			offerID, materialID, offerMaterial.Quantity, offerMaterial.UnitPrice, priced, offerMaterial.TenantID).
+			Scan(&offerMaterial.ID, &offerMaterial.UnitPrice, &offerMaterial.CreatedAt, &offerMaterial.UpdatedAt, &inserted)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		event := models.EventOfferMaterialUpdated
+		if inserted {
+			event = models.EventOfferMaterialAdded
+		}
+		if err := enqueueEvent(r.Context(), tx, offerMaterial.TenantID, models.EntityOffer, offerID, event, offerMaterial); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
+		if inserted {
+			w.WriteHeader(http.StatusCreated)
+		}
 		json.NewEncoder(w).Encode(offerMaterial)
 	}
 }
@@ -97,13 +451,38 @@ func UpdateOfferMaterial(db *sql.DB) http.HandlerFunc {
 func DeleteOfferMaterial(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		line, err := lockOfferLine(r.Context(), tx, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
-		_, err := db.Exec("UPDATE offer_material SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+		_, err = tx.ExecContext(r.Context(), "UPDATE offer_material SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2", id, tenantID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := enqueueEvent(r.Context(), tx, tenantID, models.EntityOffer, line.OfferID, models.EventOfferMaterialRemoved, line); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.WriteHeader(http.StatusNoContent)
 	}
@@ -0,0 +1,399 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	offerLinkQuery    = regexp.QuoteMeta(`SELECT status, owner_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`)
+	materialLinkQuery = regexp.QuoteMeta(`SELECT active FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+	offerLineQuery    = regexp.QuoteMeta(`SELECT om.offer_id, om.material_id, o.status, o.owner_id FROM offer_material om JOIN offer o ON o.id = om.offer_id`)
+)
+
+// expectOfferLine mocks the lookup of lockOfferLine for line 7 of offer 1
+// in tenant acme.
+func expectOfferLine(mock sqlmock.Sqlmock, materialID int, status string) {
+	mock.ExpectQuery(offerLineQuery).WithArgs(7, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"offer_id", "material_id", "status", "owner_id"}).AddRow(1, materialID, status, nil))
+}
+
+// expectOfferMaterialLink mocks the offer and material lookups of
+// checkOfferMaterialLink for tenant acme.
+func expectOfferMaterialLink(mock sqlmock.Sqlmock, offerID int, status string, materialID int, active bool) {
+	mock.ExpectQuery(offerLinkQuery).
+		WithArgs(offerID, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow(status, nil))
+	mock.ExpectQuery(materialLinkQuery).
+		WithArgs(materialID, "acme").WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(active))
+}
+
+func TestUpdateOfferMaterial(t *testing.T) {
+	update := regexp.QuoteMeta(`UPDATE offer_material SET offer_id = $1, material_id = $2, quantity = $3, unit_price = $4`)
+	updated := []string{"created_at", "updated_at"}
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "failure - inactive material added to draft offer",
+			requestBody:  `{"offer_id": 1, "material_id": 3, "unit_price": 5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 2, "draft")
+				expectOfferMaterialLink(mock, 1, "draft", 3, false)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "success - line keeps its deactivated material",
+			requestBody:  `{"offer_id": 1, "material_id": 3, "quantity": 2, "unit_price": 5}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 3, "draft")
+				expectOfferMaterialLink(mock, 1, "draft", 3, false)
+				mock.ExpectQuery(update).WithArgs(1, 3, 2.0, 5.0, 7, "acme").WillReturnRows(sqlmock.NewRows(updated).AddRow(time.Now(), time.Now()))
+				expectEvent(mock, "offer", "1", models.EventOfferMaterialUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - line moved to another draft offer",
+			requestBody:  `{"offer_id": 2, "material_id": 3, "quantity": 2, "unit_price": 5}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 3, "draft")
+				expectOfferMaterialLink(mock, 2, "draft", 3, true)
+				mock.ExpectQuery(update).WithArgs(2, 3, 2.0, 5.0, 7, "acme").WillReturnRows(sqlmock.NewRows(updated).AddRow(time.Now(), time.Now()))
+				expectEvent(mock, "offer", "1", models.EventOfferMaterialRemoved)
+				expectEvent(mock, "offer", "2", models.EventOfferMaterialAdded)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - line with a deactivated material moved to another offer",
+			requestBody:  `{"offer_id": 2, "material_id": 3, "quantity": 2, "unit_price": 5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 3, "draft")
+				expectOfferMaterialLink(mock, 2, "draft", 3, false)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - line of a sent offer",
+			requestBody:  `{"offer_id": 1, "material_id": 3, "unit_price": 5}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 3, "sent")
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - line moved onto an accepted offer",
+			requestBody:  `{"offer_id": 2, "material_id": 3, "unit_price": 5}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 3, "draft")
+				mock.ExpectQuery(offerLinkQuery).
+					WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow("accepted", nil))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - offer pending approval",
+			requestBody:  `{"offer_id": 1, "material_id": 3, "unit_price": 5}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 3, "pending_approval")
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - deleted material",
+			requestBody:  `{"offer_id": 1, "material_id": 9, "unit_price": 5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOfferLine(mock, 2, "draft")
+				mock.ExpectQuery(offerLinkQuery).
+					WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow("draft", nil))
+				mock.ExpectQuery(materialLinkQuery).
+					WithArgs(9, "acme").WillReturnRows(sqlmock.NewRows([]string{"active"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - line not found",
+			requestBody:  `{"offer_id": 1, "material_id": 3, "unit_price": 5}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(offerLineQuery).WithArgs(7, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"offer_id", "material_id", "status", "owner_id"}))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("PUT", "/offer-materials/7", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "7"})
+			w := httptest.NewRecorder()
+
+			handler := UpdateOfferMaterial(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetInactiveMaterialReport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT o.id, o.name, o.status, om.id, m.id, m.name, m.deleted_at IS NOT NULL`)).
+		WithArgs(sqlmock.AnyArg(), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "status", "om_id", "m_id", "m_name", "deleted"}).
+			AddRow(1, "Roof", "draft", 10, 2, "Old steel", false).
+			AddRow(1, "Roof", "draft", 11, 3, "Old copper", true).
+			AddRow(4, "Garage", "accepted", 20, 2, "Old steel", false))
+
+	req := withTenant(httptest.NewRequest("GET", "/reports/inactive-materials", nil), "acme")
+	w := httptest.NewRecorder()
+
+	handler := GetInactiveMaterialReport(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report []models.AffectedOffer
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Len(t, report, 2)
+	assert.Len(t, report[0].Lines, 2)
+	assert.Equal(t, models.ReplaceDeleted, report[0].Lines[1].Reason)
+	assert.Equal(t, "accepted", report[1].Status)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateMaterialDeactivationReportsOffers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(updateMaterialQuery).
+		WithArgs("Steel", false, nil, "2", "acme").WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT DISTINCT o.id, o.name, o.status`)).
+		WithArgs(2, sqlmock.AnyArg(), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "status"}).AddRow(1, "Roof", "sent"))
+	expectEvent(mock, "material", "2", models.EventMaterialUpdated)
+	expectEvent(mock, "material", "2", models.EventMaterialDeactivated)
+	mock.ExpectCommit()
+
+	req := withTenant(httptest.NewRequest("PUT", "/materials/2", strings.NewReader(`{"name": "Steel", "active": false}`)), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w := httptest.NewRecorder()
+
+	handler := UpdateMaterial(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var material models.Material
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&material))
+	assert.Len(t, material.AffectedOffers, 1)
+	assert.Equal(t, "Roof", material.AffectedOffers[0].OfferName)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteOfferMaterial(t *testing.T) {
+	softDelete := regexp.QuoteMeta(`UPDATE offer_material SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2`)
+
+	testCases := []struct {
+		name         string
+		status       string
+		expectedCode int
+	}{
+		{name: "line of a draft offer", status: "draft", expectedCode: http.StatusNoContent},
+		{name: "failure - line of an accepted offer", status: "accepted", expectedCode: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			expectOfferLine(mock, 3, tc.status)
+			if tc.expectedCode == http.StatusNoContent {
+				mock.ExpectExec(softDelete).WithArgs(7, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectEvent(mock, "offer", "1", models.EventOfferMaterialRemoved)
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			req := withTenant(httptest.NewRequest("DELETE", "/offer-materials/7", nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "7"})
+			w := httptest.NewRecorder()
+
+			DeleteOfferMaterial(db).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpsertOfferMaterial(t *testing.T) {
+	preferredCost := regexp.QuoteMeta(`SELECT ms.purchase_price FROM material_supplier ms`)
+	lineExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM offer_material WHERE offer_id = $1 AND material_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)`)
+	upsert := regexp.QuoteMeta(`INSERT INTO offer_material (offer_id, material_id, quantity, unit_price, tenant_id) VALUES ($1, $2, $3, $4, $6)
+			ON CONFLICT (offer_id, material_id) WHERE deleted_at IS NULL`)
+	returned := []string{"id", "unit_price", "created_at", "updated_at", "inserted"}
+
+	testCases := []struct {
+		name          string
+		requestBody   string
+		expectedCode  int
+		expectedPrice float64
+		mockQueries   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:          "created with default price",
+			requestBody:   `{"quantity": 2}`,
+			expectedCode:  http.StatusCreated,
+			expectedPrice: 12.5,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(preferredCost).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"purchase_price"}).AddRow(10.0))
+				mock.ExpectBegin()
+				mock.ExpectQuery(lineExists).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				expectOfferMaterialLink(mock, 1, "draft", 2, true)
+				mock.ExpectQuery(upsert).WithArgs(1, 2, 2.0, 12.5, false, "acme").
+					WillReturnRows(sqlmock.NewRows(returned).AddRow(5, 12.5, time.Now(), time.Now(), true))
+				expectEvent(mock, "offer", "1", models.EventOfferMaterialAdded)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:          "updated keeps the quoted price",
+			requestBody:   `{"quantity": 3}`,
+			expectedCode:  http.StatusOK,
+			expectedPrice: 11.0,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(preferredCost).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"purchase_price"}).AddRow(10.0))
+				mock.ExpectBegin()
+				mock.ExpectQuery(lineExists).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				expectOfferMaterialLink(mock, 1, "draft", 2, false)
+				mock.ExpectQuery(upsert).WithArgs(1, 2, 3.0, 12.5, false, "acme").
+					WillReturnRows(sqlmock.NewRows(returned).AddRow(5, 11.0, time.Now(), time.Now(), false))
+				expectEvent(mock, "offer", "1", models.EventOfferMaterialUpdated)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - inactive material on draft offer",
+			requestBody:  `{"quantity": 1, "unit_price": 4}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lineExists).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				expectOfferMaterialLink(mock, 1, "draft", 2, false)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - offer already sent",
+			requestBody:  `{"quantity": 1, "unit_price": 4}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lineExists).WithArgs(1, 2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(offerLinkQuery).
+					WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow("sent", nil))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PRICE_MARKUP_PERCENT", "")
+
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("PUT", "/offers/1/materials/2", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1", "material_id": "2"})
+			w := httptest.NewRecorder()
+
+			handler := UpsertOfferMaterial(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedPrice != 0 {
+				var offerMaterial models.OfferMaterial
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&offerMaterial))
+				assert.Equal(t, tc.expectedPrice, offerMaterial.UnitPrice)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateOfferMaterialAcrossTenants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Material 2 belongs to another tenant, so the lookup in acme finds nothing.
+	mock.ExpectBegin()
+	mock.ExpectQuery(offerLinkQuery).
+		WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow("draft", nil))
+	mock.ExpectQuery(materialLinkQuery).
+		WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"active"}))
+
+	req := withTenant(httptest.NewRequest("POST", "/offer-materials", strings.NewReader(`{"offer_id": 1, "material_id": 2, "quantity": 1, "unit_price": 3}`)), "acme")
+	w := httptest.NewRecorder()
+
+	handler := CreateOfferMaterial(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "material not found")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/config"
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// offerOwnerQuery loads the owner of an offer for authorizeOfferEdit.
+const offerOwnerQuery = "SELECT owner_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL"
+
+// checkOfferOwner enforces OFFERS_OWNER_ONLY: an offer owned by someone
+// else can only be changed by principals allowed to assign offers.
+// Unassigned offers are open to everyone, so they can be claimed.
+func checkOfferOwner(ctx context.Context, owner *string) error {
+	if !config.OffersOwnerOnly() || owner == nil {
+		return nil
+	}
+	p, _ := auth.FromContext(ctx)
+	if *owner == p.ID || p.Can(auth.PermOffersAssign) {
+		return nil
+	}
+	return forbidden("forbidden: offer is owned by %s", *owner)
+}
+
+// authorizeOfferEdit runs checkOfferOwner for the offer found by query, one
+// of the owner queries above. A missing offer passes, so the caller reports
+// it as usual.
+func authorizeOfferEdit(ctx context.Context, db *sql.DB, query string, id interface{}) error {
+	if !config.OffersOwnerOnly() {
+		return nil
+	}
+	var owner *string
+	err := db.QueryRowContext(ctx, query, id, tenant.FromContext(ctx)).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return checkOfferOwner(ctx, owner)
+}
+
+// setOfferOwner reassigns an offer, or unassigns it when owner is nil.
+func setOfferOwner(ctx context.Context, db *sql.DB, offerID int, owner *string) error {
+	tenantID := tenant.FromContext(ctx)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current *string
+	err = tx.QueryRowContext(ctx, offerOwnerQuery+" FOR UPDATE", offerID, tenantID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return notFound("Offer not found")
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkOfferOwner(ctx, current); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE offer SET owner_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND tenant_id = $3", owner, offerID, tenantID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AssignOffer makes another sales rep the owner of an offer. The body is
+// {"owner_id": "..."} with the principal ID of the new owner.
+func AssignOffer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var assignment models.OfferAssignment
+		if err := json.NewDecoder(r.Body).Decode(&assignment); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if assignment.OwnerID == nil || strings.TrimSpace(*assignment.OwnerID) == "" {
+			http.Error(w, "owner_id is required", http.StatusBadRequest)
+			return
+		}
+		owner := strings.TrimSpace(*assignment.OwnerID)
+		assignment.OfferID, assignment.OwnerID = id, &owner
+
+		if err := setOfferOwner(r.Context(), db, id, assignment.OwnerID); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignment)
+	}
+}
+
+// UnassignOffer removes the owner of an offer.
+func UnassignOffer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		if err := setOfferOwner(r.Context(), db, id, nil); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
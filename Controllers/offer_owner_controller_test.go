@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// asPrincipal returns req acting for tenant acme as a principal with roles.
+func asPrincipal(req *http.Request, id string, roles ...string) *http.Request {
+	req = withTenant(req, "acme")
+	return req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{ID: id, Roles: roles}))
+}
+
+func TestGetOffersOwnerFilter(t *testing.T) {
+	testCases := []struct {
+		name  string
+		url   string
+		query string
+		args  []driver.Value
+	}{
+		{name: "mine", url: "/offers?owner=me", query: `SELECT * FROM offer WHERE deleted_at IS NULL AND tenant_id = $1 AND owner_id = $2`, args: []driver.Value{"acme", "user-1"}},
+		{name: "someone else", url: "/offers?owner=user-2", query: `SELECT * FROM offer WHERE deleted_at IS NULL AND tenant_id = $1 AND owner_id = $2`, args: []driver.Value{"acme", "user-2"}},
+		{name: "unassigned", url: "/offers?owner=none", query: `SELECT * FROM offer WHERE deleted_at IS NULL AND tenant_id = $1 AND owner_id IS NULL`, args: []driver.Value{"acme"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta(tc.query) + "$").WithArgs(tc.args...).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			req := asPrincipal(httptest.NewRequest("GET", tc.url, nil), "user-1", auth.RoleSales)
+			w := httptest.NewRecorder()
+
+			GetOffers(db).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAssignOffer(t *testing.T) {
+	lock := regexp.QuoteMeta(offerOwnerQuery + " FOR UPDATE")
+	update := regexp.QuoteMeta(`UPDATE offer SET owner_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND tenant_id = $3`)
+	owned := func(owner interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"owner_id"}).AddRow(owner)
+	}
+
+	testCases := []struct {
+		name         string
+		ownerOnly    string
+		roles        []string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - hand own offer over",
+			ownerOnly:    "true",
+			roles:        []string{auth.RoleSales},
+			requestBody:  `{"owner_id": "user-2"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lock).WithArgs(1, "acme").WillReturnRows(owned("user-1"))
+				mock.ExpectExec(update).WithArgs("user-2", 1, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - offer of another rep",
+			ownerOnly:    "true",
+			roles:        []string{auth.RoleSales},
+			requestBody:  `{"owner_id": "user-1"}`,
+			expectedCode: http.StatusForbidden,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lock).WithArgs(1, "acme").WillReturnRows(owned("user-3"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "success - admin reassigns any offer",
+			ownerOnly:    "true",
+			roles:        []string{auth.RoleAdmin},
+			requestBody:  `{"owner_id": "user-2"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lock).WithArgs(1, "acme").WillReturnRows(owned("user-3"))
+				mock.ExpectExec(update).WithArgs("user-2", 1, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "success - rule off",
+			ownerOnly:    "",
+			roles:        []string{auth.RoleSales},
+			requestBody:  `{"owner_id": "user-1"}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lock).WithArgs(1, "acme").WillReturnRows(owned("user-3"))
+				mock.ExpectExec(update).WithArgs("user-1", 1, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - offer not found",
+			roles:        []string{auth.RoleSales},
+			requestBody:  `{"owner_id": "user-2"}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lock).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"owner_id"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - owner missing",
+			roles:        []string{auth.RoleSales},
+			requestBody:  `{"owner_id": " "}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OFFERS_OWNER_ONLY", tc.ownerOnly)
+
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := asPrincipal(httptest.NewRequest("PUT", "/offers/1/owner", strings.NewReader(tc.requestBody)), "user-1", tc.roles...)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			AssignOffer(db).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateOfferOwnerOnly(t *testing.T) {
+	t.Setenv("OFFERS_OWNER_ONLY", "true")
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(offerOwnerQuery)).WithArgs(1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow("user-3"))
+
+	req := asPrincipal(httptest.NewRequest("PUT", "/offers/1", strings.NewReader(`{"name": "Roof"}`)), "user-1", auth.RoleSales)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateOffer(db).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "user-3")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateOfferOwnedByCreator(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO offer (name, tenant_id, owner_id, customer_id)`)).WithArgs("Roof", "acme", "user-1", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+	expectEvent(mock, "offer", "1", models.EventOfferCreated)
+	mock.ExpectCommit()
+
+	req := asPrincipal(httptest.NewRequest("POST", "/offers", strings.NewReader(`{"name": "Roof", "owner_id": "user-9"}`)), "user-1", auth.RoleSales)
+	w := httptest.NewRecorder()
+
+	CreateOffer(db).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "user-1", body["owner_id"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
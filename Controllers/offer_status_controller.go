@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// offerTransitions lists the statuses each offer status may move to.
+// Lines only change on drafts (see checkOfferLineStatus), so a sent offer
+// that needs changes is revised back to draft. Sending it again evaluates
+// the approval rules afresh, so an earlier approval does not carry over.
+// Accepted offers hold stock reservations until they are rejected, expire
+// or are deleted, and their lines stay final so the reservations keep
+// matching them. Offers pending approval only move when they are approved
+// or rejected.
+var offerTransitions = map[string][]string{
+	models.OfferDraft:           {models.OfferSent, models.OfferAccepted, models.OfferRejected},
+	models.OfferPendingApproval: {},
+	models.OfferSent:            {models.OfferDraft, models.OfferAccepted, models.OfferRejected, models.OfferExpired},
+	models.OfferAccepted:        {models.OfferRejected, models.OfferExpired},
+	models.OfferRejected:        {},
+	models.OfferExpired:         {},
+}
+
+func canTransition(from, to string) bool {
+	for _, status := range offerTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// checkFulfillment compares the material quantities of an offer with the
+// stock on hand less what other offers have reserved.
+func checkFulfillment(ctx context.Context, q queryer, offerID int) (models.FulfillmentCheck, error) {
+	check := models.FulfillmentCheck{OfferID: offerID, Fulfillable: true, Lines: []models.FulfillmentLine{}}
+
+	rows, err := q.QueryContext(ctx, `SELECT om.material_id, m.name, SUM(om.quantity),
+			COALESCE((SELECT SUM(sl.on_hand) FROM stock_level sl WHERE sl.material_id = om.material_id AND sl.tenant_id = om.tenant_id), 0)
+			- COALESCE((SELECT SUM(sr.quantity) FROM stock_reservation sr
+				JOIN offer o ON o.id = sr.offer_id AND o.deleted_at IS NULL
+				WHERE sr.material_id = om.material_id AND sr.tenant_id = om.tenant_id AND sr.released_at IS NULL AND sr.offer_id <> $1), 0)
+		FROM offer_material om JOIN material m ON m.id = om.material_id
+		WHERE om.offer_id = $1 AND om.tenant_id = $2 AND om.deleted_at IS NULL
+		GROUP BY om.material_id, m.name
+		ORDER BY om.material_id`, offerID, tenant.FromContext(ctx))
+	if err != nil {
+		return check, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.FulfillmentLine
+		if err := rows.Scan(&line.MaterialID, &line.MaterialName, &line.Required, &line.Available); err != nil {
+			return check, err
+		}
+		if line.Required > line.Available {
+			line.Shortfall = line.Required - line.Available
+			if line.Available < 0 {
+				line.Shortfall = line.Required
+			}
+			check.Fulfillable = false
+		}
+		check.Lines = append(check.Lines, line)
+	}
+	return check, rows.Err()
+}
+
+// reserveOfferStock reserves every line of an accepted offer. The material
+// rows are locked first so concurrent acceptances cannot both take the
+// same stock. Nothing is reserved when the check fails.
+func reserveOfferStock(ctx context.Context, tx *sql.Tx, offerID int) (models.FulfillmentCheck, error) {
+	tenantID := tenant.FromContext(ctx)
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM material
+		WHERE id IN (SELECT material_id FROM offer_material WHERE offer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL)
+		ORDER BY id FOR UPDATE`, offerID, tenantID)
+	if err != nil {
+		return models.FulfillmentCheck{}, err
+	}
+	rows.Close()
+
+	check, err := checkFulfillment(ctx, tx, offerID)
+	if err != nil || !check.Fulfillable {
+		return check, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO stock_reservation (offer_id, offer_material_id, material_id, quantity, tenant_id)
+		SELECT offer_id, id, material_id, quantity, tenant_id FROM offer_material WHERE offer_id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, offerID, tenantID)
+	return check, err
+}
+
+// releaseOfferStock releases the reservations of offers the caller has
+// already looked up in their tenant; the expiry job passes offers of all
+// tenants.
+func releaseOfferStock(ctx context.Context, tx *sql.Tx, offerIDs ...int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE stock_reservation SET released_at = CURRENT_TIMESTAMP WHERE offer_id = ANY($1) AND released_at IS NULL", pq.Array(offerIDs))
+	return err
+}
+
+// UpdateOfferStatus moves an offer to a new status. Only offers with a
+// customer can be sent or accepted; moving a sent offer to draft revises
+// it. A draft matching an approval rule is
+// not sent but put into pending_approval, answering 202 with the approval
+// request. Accepting reserves the offer's materials and fails with 409 and
+// the fulfillment check when stock is short; rejecting or expiring an
+// accepted offer releases them.
+func UpdateOfferStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var change models.OfferStatusChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := offerTransitions[change.Status]; !ok {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		change.OfferID = id
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		var current string
+		var owner *string
+		var customer *int
+		err = tx.QueryRowContext(ctx, "SELECT status, owner_id, customer_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenantID).Scan(&current, &owner, &customer)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkOfferOwner(ctx, owner); err != nil {
+			writeError(w, err)
+			return
+		}
+		if !canTransition(current, change.Status) {
+			writeError(w, conflict("cannot change offer status from %s to %s", current, change.Status))
+			return
+		}
+		if (change.Status == models.OfferSent || change.Status == models.OfferAccepted) && customer == nil {
+			writeError(w, conflict("offer has no customer and cannot be %s", change.Status))
+			return
+		}
+		if current == models.OfferDraft && (change.Status == models.OfferSent || change.Status == models.OfferAccepted) {
+			matches, err := evaluateApprovalRules(ctx, tx, id)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if len(matches) > 0 {
+				if change.Status == models.OfferAccepted {
+					writeError(w, conflict("offer needs approval; send it to request approval"))
+					return
+				}
+				request, err := requestApproval(ctx, tx, id, matches)
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				err = tx.QueryRowContext(ctx, "UPDATE offer SET status = $1, valid_until = COALESCE($2, valid_until), updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 RETURNING valid_until",
+					models.OfferPendingApproval, change.ValidUntil, id, tenantID).Scan(&change.ValidUntil)
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				err = enqueueEvent(ctx, tx, tenantID, models.EntityOffer, id, models.EventOfferStatusChanged, models.OfferStatusChangedEvent{OfferID: id, From: current, To: models.OfferPendingApproval, ValidUntil: change.ValidUntil})
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				if err := tx.Commit(); err != nil {
+					writeError(w, err)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusAccepted)
+				json.NewEncoder(w).Encode(request)
+				return
+			}
+		}
+
+		if change.Status == models.OfferAccepted {
+			check, err := reserveOfferStock(ctx, tx, id)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if !check.Fulfillable {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(check)
+				return
+			}
+		}
+		if current == models.OfferAccepted {
+			if err := releaseOfferStock(ctx, tx, id); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, "UPDATE offer SET status = $1, valid_until = COALESCE($2, valid_until), updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND tenant_id = $4 RETURNING valid_until",
+			change.Status, change.ValidUntil, id, tenantID).Scan(&change.ValidUntil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		err = enqueueEvent(ctx, tx, tenantID, models.EntityOffer, id, models.EventOfferStatusChanged, models.OfferStatusChangedEvent{OfferID: id, From: current, To: change.Status, ValidUntil: change.ValidUntil})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(change)
+	}
+}
+
+// GetOfferFulfillment says whether the offer can be fulfilled from the
+// current stock.
+func GetOfferFulfillment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant.FromContext(r.Context())).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+
+		check, err := checkFulfillment(r.Context(), db, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(check)
+	}
+}
+
+// ExpireOffers marks sent and accepted offers past their valid_until as
+// expired, releases their reservations and publishes the status changes.
+// It runs across all tenants and returns how many offers expired.
+func ExpireOffers(db *sql.DB) (int, error) {
+	ctx := tenant.WithID(context.Background(), tenant.All)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `WITH due AS (
+			SELECT id, status FROM offer
+			WHERE status IN ($2, $3) AND valid_until < CURRENT_TIMESTAMP AND deleted_at IS NULL
+			FOR UPDATE)
+		UPDATE offer o SET status = $1, updated_at = CURRENT_TIMESTAMP
+		FROM due WHERE o.id = due.id
+		RETURNING o.id, o.tenant_id, due.status, o.valid_until`, models.OfferExpired, models.OfferSent, models.OfferAccepted)
+	if err != nil {
+		return 0, err
+	}
+	ids := []int{}
+	tenants := []string{}
+	events := []models.OfferStatusChangedEvent{}
+	for rows.Next() {
+		var tenantID string
+		event := models.OfferStatusChangedEvent{To: models.OfferExpired}
+		if err := rows.Scan(&event.OfferID, &tenantID, &event.From, &event.ValidUntil); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids, tenants, events = append(ids, event.OfferID), append(tenants, tenantID), append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) > 0 {
+		if err := releaseOfferStock(ctx, tx, ids...); err != nil {
+			return 0, err
+		}
+	}
+	for i, event := range events {
+		if err := enqueueEvent(ctx, tx, tenants[i], models.EntityOffer, event.OfferID, models.EventOfferStatusChanged, event); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
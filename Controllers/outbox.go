@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"Products/config"
+	"Products/outbox"
+	"Products/tenant"
+	"Products/webhook"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// enqueueEvent stores a domain event in the outbox. Run it in the
+// transaction of the change, so the event is published if and only if the
+// change commits.
+//
+// The advisory lock on the aggregate is held until the transaction ends,
+// so events of one aggregate get their ids in commit order and the relay
+// can publish them by id.
+func enqueueEvent(ctx context.Context, q execer, tenantID, aggregate string, aggregateID int, eventType string, data interface{}) error {
+	event, err := webhook.NewEvent(eventType, tenantID, data)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `WITH lock AS (SELECT pg_advisory_xact_lock(hashtextextended($2::text || '/' || $3::text, 0)))
+		INSERT INTO outbox_event (event_id, aggregate, aggregate_id, event, payload, tenant_id)
+		SELECT $1, $2, $3, $4, $5, $6 FROM lock`,
+		event.ID, aggregate, strconv.Itoa(aggregateID), eventType, payload, tenantID)
+	return err
+}
+
+// outboxRelayLock is the advisory lock key held while claiming. Only one
+// instance claims at a time, so it sees the claims of the others.
+const outboxRelayLock = 0x6f7574626f78
+
+// claimOutboxEvents claims up to limit pending outbox events across all
+// tenants for config.OutboxClaimTimeout by moving their next attempt past
+// it. A claimed event holds back the later events of its aggregate like a
+// failed one, so no other relay publishes them out of order meanwhile.
+func claimOutboxEvents(ctx context.Context, db *sql.DB, limit int) ([]outbox.Message, map[int64]int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var claiming bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxRelayLock).Scan(&claiming); err != nil {
+		return nil, nil, err
+	}
+	if !claiming {
+		return nil, nil, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `UPDATE outbox_event SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE id IN (SELECT id FROM outbox_event o
+			WHERE published_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+			AND NOT EXISTS (SELECT 1 FROM outbox_event p
+				WHERE p.aggregate = o.aggregate AND p.aggregate_id = o.aggregate_id AND p.id < o.id
+				AND p.published_at IS NULL AND p.next_attempt_at > CURRENT_TIMESTAMP)
+			ORDER BY id LIMIT $1)
+		RETURNING id, event_id, aggregate, aggregate_id, event, payload, tenant_id, attempts, created_at`, limit, config.OutboxClaimTimeout.Seconds())
+	if err != nil {
+		return nil, nil, err
+	}
+	var messages []outbox.Message
+	attempts := map[int64]int{}
+	for rows.Next() {
+		var (
+			m outbox.Message
+			n int
+		)
+		if err := rows.Scan(&m.ID, &m.EventID, &m.Aggregate, &m.AggregateID, &m.Type, &m.Payload, &m.TenantID, &n, &m.CreatedAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		messages = append(messages, m)
+		attempts[m.ID] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	// UPDATE ... RETURNING does not keep the order of the subquery.
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, attempts, nil
+}
+
+// RelayOutbox publishes up to limit pending outbox events across all
+// tenants and returns how many it claimed. Events are claimed and the
+// claim committed before publishing, so no transaction stays open while a
+// publisher is slow. They go out in id order; when one fails, the later
+// events of its aggregate wait until a retry of it succeeds. An event
+// counts as published once the publisher accepted it, and a crash before
+// that is recorded means it is published again once its claim runs out.
+func RelayOutbox(ctx context.Context, db *sql.DB, publisher outbox.Publisher, limit int) (int, error) {
+	ctx = tenant.WithID(ctx, tenant.All)
+	messages, attempts, err := claimOutboxEvents(ctx, db, limit)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	// Publishing stops when the claim runs out, as by then another relay
+	// may publish the same events.
+	publishCtx, cancel := context.WithTimeout(ctx, config.OutboxClaimTimeout)
+	defer cancel()
+	published := []int64{}
+	released := []int64{}
+	blocked := map[string]bool{}
+	for _, m := range messages {
+		key := m.Aggregate + "/" + m.AggregateID
+		if blocked[key] || publishCtx.Err() != nil {
+			released = append(released, m.ID)
+			continue
+		}
+		if err := publisher.Publish(publishCtx, m); err != nil {
+			blocked[key] = true
+			wait := webhook.Backoff(attempts[m.ID]+1, config.OutboxBackoff(), config.OutboxMaxBackoff)
+			log.Printf("Error publishing outbox event %s, retrying in %v: %v", m.EventID, wait, err)
+			_, err = db.ExecContext(ctx, "UPDATE outbox_event SET attempts = attempts + 1, last_error = $1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2) WHERE id = $3",
+				err.Error(), wait.Seconds(), m.ID)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		published = append(published, m.ID)
+	}
+	if len(published) > 0 {
+		_, err = db.ExecContext(ctx, "UPDATE outbox_event SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = '' WHERE id = ANY($1)", pq.Array(published))
+		if err != nil {
+			return 0, err
+		}
+	}
+	// Events held back behind a failure are released right away; they stay
+	// behind it through the NOT EXISTS in claimOutboxEvents.
+	if len(released) > 0 {
+		_, err = db.ExecContext(ctx, "UPDATE outbox_event SET next_attempt_at = CURRENT_TIMESTAMP WHERE id = ANY($1) AND published_at IS NULL", pq.Array(released))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(messages), nil
+}
+
+// RunOutboxRelay calls RelayOutbox every interval until ctx is done,
+// draining the backlog in batches.
+func RunOutboxRelay(ctx context.Context, db *sql.DB, publisher outbox.Publisher, interval time.Duration) {
+	const batch = 100
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := RelayOutbox(ctx, db, publisher, batch)
+			if err != nil {
+				log.Printf("Error relaying outbox events: %v", err)
+			}
+			if err != nil || n < batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WebhookPublisher turns outbox events into deliveries for the webhook
+// subscriptions of their tenant. Repeats of an event are ignored.
+func WebhookPublisher(db *sql.DB) outbox.Publisher {
+	return outbox.PublisherFunc(func(ctx context.Context, m outbox.Message) error {
+		_, err := db.ExecContext(ctx, `INSERT INTO webhook_delivery (subscription_id, event_id, event, payload, tenant_id)
+			SELECT id, $1, $2, $3, tenant_id FROM webhook_subscription
+			WHERE tenant_id = $4 AND active AND deleted_at IS NULL AND $2 = ANY(events)
+			ON CONFLICT (subscription_id, event_id) DO NOTHING`,
+			m.EventID, m.Type, m.Payload, m.TenantID)
+		return err
+	})
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"Products/config"
+	"Products/models"
+	"Products/outbox"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var enqueueQuery = regexp.QuoteMeta(`INSERT INTO outbox_event (event_id, aggregate, aggregate_id, event, payload, tenant_id)`)
+
+// expectEvent expects an event of tenant acme to be written to the outbox.
+func expectEvent(mock sqlmock.Sqlmock, aggregate, aggregateID, eventType string) {
+	mock.ExpectExec(enqueueQuery).WithArgs(sqlmock.AnyArg(), aggregate, aggregateID, eventType, sqlmock.AnyArg(), "acme").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestRelayOutbox(t *testing.T) {
+	relayLock := regexp.QuoteMeta(`SELECT pg_try_advisory_xact_lock($1)`)
+	claim := regexp.QuoteMeta(`UPDATE outbox_event SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)`)
+	published := regexp.QuoteMeta(`UPDATE outbox_event SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = '' WHERE id = ANY($1)`)
+	retry := regexp.QuoteMeta(`UPDATE outbox_event SET attempts = attempts + 1, last_error = $1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2) WHERE id = $3`)
+	release := regexp.QuoteMeta(`UPDATE outbox_event SET next_attempt_at = CURRENT_TIMESTAMP WHERE id = ANY($1) AND published_at IS NULL`)
+	columns := []string{"id", "event_id", "aggregate", "aggregate_id", "event", "payload", "tenant_id", "attempts", "created_at"}
+	// RETURNING hands the claimed rows back in no particular order.
+	claimedRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(3, "evt_3", "offer", "1", models.EventOfferStatusChanged, []byte(`{"id": "evt_3"}`), "acme", 2, time.Now()).
+			AddRow(1, "evt_1", "offer", "1", models.EventOfferCreated, []byte(`{"id": "evt_1"}`), "acme", 0, time.Now()).
+			AddRow(2, "evt_2", "material", "2", models.EventMaterialDeactivated, []byte(`{"id": "evt_2"}`), "acme", 0, time.Now())
+	}
+	expectClaim := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(relayLock).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mock.ExpectQuery(claim).WithArgs(10, config.OutboxClaimTimeout.Seconds()).WillReturnRows(claimedRows())
+		mock.ExpectCommit()
+	}
+
+	testCases := []struct {
+		name          string
+		failing       string
+		expectedCount int
+		expectedSent  []string
+		mockQueries   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:          "publishes in order",
+			expectedCount: 3,
+			expectedSent:  []string{"evt_1", "evt_2", "evt_3"},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				mock.ExpectExec(published).WithArgs(pq.Array([]int64{1, 2, 3})).WillReturnResult(sqlmock.NewResult(0, 3))
+			},
+		},
+		{
+			name:          "a failure holds back its aggregate only",
+			failing:       "evt_1",
+			expectedCount: 3,
+			expectedSent:  []string{"evt_2"},
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				mock.ExpectExec(retry).WithArgs("broker down", 5.0, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(published).WithArgs(pq.Array([]int64{2})).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(release).WithArgs(pq.Array([]int64{3})).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:          "nothing pending",
+			expectedCount: 0,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(relayLock).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+				mock.ExpectQuery(claim).WithArgs(10, config.OutboxClaimTimeout.Seconds()).WillReturnRows(sqlmock.NewRows(columns))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:          "another instance is claiming",
+			expectedCount: 0,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(relayLock).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			bus := outbox.NewMemoryBus()
+			sent := []string{}
+			subjects := []string{}
+			bus.Subscribe("products.events.>", func(subject string, data []byte) {
+				subjects = append(subjects, subject)
+			})
+			nats := outbox.NATSPublisher{Conn: bus, Prefix: "products.events"}
+			publisher := outbox.PublisherFunc(func(ctx context.Context, m outbox.Message) error {
+				if m.EventID == tc.failing {
+					return errors.New("broker down")
+				}
+				sent = append(sent, m.EventID)
+				return nats.Publish(ctx, m)
+			})
+
+			n, err := RelayOutbox(context.Background(), db, publisher, 10)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCount, n)
+			assert.Equal(t, len(tc.expectedSent), len(subjects))
+			if len(tc.expectedSent) > 0 {
+				assert.Equal(t, tc.expectedSent, sent)
+				assert.Contains(t, subjects, nats.Subject(models.EventMaterialDeactivated))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMemoryBusSubjects(t *testing.T) {
+	bus := outbox.NewMemoryBus()
+	var offers, all int
+	unsubscribe := bus.Subscribe("products.events.offer.*", func(string, []byte) { offers++ })
+	bus.Subscribe("products.>", func(string, []byte) { all++ })
+
+	assert.NoError(t, bus.Publish("products.events.offer.created", nil))
+	assert.NoError(t, bus.Publish("products.events.offer_material.added", nil))
+	unsubscribe()
+	assert.NoError(t, bus.Publish("products.events.offer.status_changed", nil))
+
+	assert.Equal(t, 1, offers)
+	assert.Equal(t, 3, all)
+	assert.False(t, outbox.MatchSubject("products.>", "products"))
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// openOfferStatuses are the statuses of offers that may still be
+// delivered, and so care about the materials they use.
+var openOfferStatuses = []string{models.OfferDraft, models.OfferPendingApproval, models.OfferSent, models.OfferAccepted}
+
+// affectedOffers lists the open offers with a line using the material.
+func affectedOffers(ctx context.Context, db queryer, materialID int) ([]models.AffectedOffer, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT o.id, o.name, o.status
+		FROM offer o JOIN offer_material om ON om.offer_id = o.id AND om.deleted_at IS NULL
+		WHERE om.material_id = $1 AND o.tenant_id = $3 AND o.deleted_at IS NULL AND o.status = ANY($2)
+		ORDER BY o.id`, materialID, pq.Array(openOfferStatuses), tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := []models.AffectedOffer{}
+	for rows.Next() {
+		var offer models.AffectedOffer
+		if err := rows.Scan(&offer.OfferID, &offer.OfferName, &offer.Status); err != nil {
+			return nil, err
+		}
+		offers = append(offers, offer)
+	}
+	return offers, rows.Err()
+}
+
+// GetInactiveMaterialReport lists the open offers that have lines using
+// inactive or deleted materials.
+func GetInactiveMaterialReport(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), `SELECT o.id, o.name, o.status, om.id, m.id, m.name, m.deleted_at IS NOT NULL
+			FROM offer o
+			JOIN offer_material om ON om.offer_id = o.id AND om.deleted_at IS NULL
+			JOIN material m ON m.id = om.material_id
+			WHERE o.tenant_id = $2 AND o.deleted_at IS NULL AND o.status = ANY($1) AND (NOT m.active OR m.deleted_at IS NOT NULL)
+			ORDER BY o.id, om.id`, pq.Array(openOfferStatuses), tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		report := []models.AffectedOffer{}
+		for rows.Next() {
+			var (
+				offer   models.AffectedOffer
+				line    models.ReplacementProposal
+				deleted bool
+			)
+			if err := rows.Scan(&offer.OfferID, &offer.OfferName, &offer.Status, &line.OfferMaterialID, &line.MaterialID, &line.MaterialName, &deleted); err != nil {
+				writeError(w, err)
+				return
+			}
+			line.Reason = models.ReplaceInactive
+			if deleted {
+				line.Reason = models.ReplaceDeleted
+			}
+			if n := len(report); n == 0 || report[n-1].OfferID != offer.OfferID {
+				report = append(report, offer)
+			}
+			last := &report[len(report)-1]
+			last.Lines = append(last.Lines, line)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
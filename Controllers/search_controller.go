@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"Products/search"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Search answers GET /search?q=...&type=offer,material&limit=20 from the
+// given index. Offers are only searched for callers with offers:read.
+func Search(index search.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		q := strings.TrimSpace(query.Get("q"))
+		if len(search.Terms(q)) == 0 {
+			http.Error(w, "missing q", http.StatusBadRequest)
+			return
+		}
+
+		var types []string
+		if value := query.Get("type"); value != "" {
+			for _, t := range strings.Split(value, ",") {
+				t = strings.TrimSpace(t)
+				if t != models.SearchOffer && t != models.SearchMaterial {
+					http.Error(w, "invalid type", http.StatusBadRequest)
+					return
+				}
+				types = append(types, t)
+			}
+		}
+		if p, _ := auth.FromContext(r.Context()); !p.Can(auth.PermOffersRead) {
+			if slices.Contains(types, models.SearchOffer) {
+				http.Error(w, "forbidden: missing permission "+auth.PermOffersRead, http.StatusForbidden)
+				return
+			}
+			types = []string{models.SearchMaterial}
+		}
+
+		limit := defaultSearchLimit
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(parsed, maxSearchLimit)
+		}
+
+		results, err := index.Search(r.Context(), q, types, limit)
+		if err != nil {
+			log.Printf("Error searching: %v", err)
+			http.Error(w, "search error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
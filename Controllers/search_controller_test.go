@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"Products/search"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchMemoryIndex(t *testing.T) {
+	index := search.NewMemoryIndex()
+	index.Put("acme", models.SearchMaterial, 1, "Stainless steel sheet")
+	index.Put("acme", models.SearchMaterial, 2, "Steel")
+	index.Put("acme", models.SearchMaterial, 3, "Copper pipe")
+	index.Put("acme", models.SearchOffer, 1, "Steel roof for warehouse")
+	index.Put("acme", models.SearchMaterial, 4, "Steel beam")
+	index.Put("acme", models.SearchMaterial, 5, `Brass <img src=x onerror="alert(1)"> fitting`)
+	index.Put("globex", models.SearchMaterial, 6, "Steel wire")
+	index.Remove("acme", models.SearchMaterial, 4)
+
+	testCases := []struct {
+		name         string
+		url          string
+		roles        []string
+		expectedCode int
+		expectedIDs  []string
+		snippet      string
+	}{
+		{
+			name:         "prefix match across types",
+			url:          "/search?q=ste",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"material/2", "material/1", "offer/1"},
+			snippet:      "<mark>Steel</mark>",
+		},
+		{
+			name:         "all terms must match",
+			url:          "/search?q=steel+sh",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"material/1"},
+			snippet:      "Stainless <mark>steel</mark> <mark>sheet</mark>",
+		},
+		{
+			name:         "type filter",
+			url:          "/search?q=steel&type=offer",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"offer/1"},
+		},
+		{
+			name:         "limit",
+			url:          "/search?q=steel&limit=1",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"material/2"},
+		},
+		{
+			name:         "names are escaped in snippets",
+			url:          "/search?q=brass",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"material/5"},
+			snippet:      `<mark>Brass</mark> &lt;img src=x onerror=&#34;alert(1)&#34;&gt; fitting`,
+		},
+		{
+			name:         "offers need offers:read",
+			url:          "/search?q=steel",
+			roles:        []string{"supplier"},
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"material/2", "material/1"},
+		},
+		{
+			name:         "failure - offer type without offers:read",
+			url:          "/search?q=steel&type=offer",
+			roles:        []string{"supplier"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "failure - missing query",
+			url:          "/search?q=%20-",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "failure - invalid type",
+			url:          "/search?q=steel&type=customer",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			roles := tc.roles
+			if roles == nil {
+				roles = []string{auth.RoleViewer}
+			}
+			req := asPrincipal(httptest.NewRequest("GET", tc.url, nil), "user-1", roles...)
+			w := httptest.NewRecorder()
+
+			handler := Search(index)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var results []models.SearchResult
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+			ids := []string{}
+			for _, result := range results {
+				ids = append(ids, fmt.Sprintf("%s/%d", result.Type, result.ID))
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+			if tc.snippet != "" {
+				assert.Equal(t, tc.snippet, results[0].Snippet)
+			}
+		})
+	}
+}
+
+func TestSearchPostgresIndex(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`WITH q AS \(SELECT to_tsquery\('simple', \$1\) AS query\)`).
+		WithArgs("steel:* & sh:*", pq.Array([]string{"material"}), 20, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "id", "name", "rank"}).
+			AddRow("material", 1, "Steel sheet <b>", 0.06))
+
+	req := asPrincipal(httptest.NewRequest("GET", "/search?q=Steel+sh!&type=material", nil), "user-1", auth.RoleViewer)
+	w := httptest.NewRecorder()
+
+	handler := Search(search.NewPostgresIndex(db))
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var results []models.SearchResult
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+	assert.Len(t, results, 1)
+	assert.Equal(t, models.SearchMaterial, results[0].Type)
+	assert.Equal(t, "<mark>Steel</mark> <mark>sheet</mark> &lt;b&gt;", results[0].Snippet)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
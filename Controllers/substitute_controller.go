@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// replacementQuery finds the offer lines whose material is inactive or
+// deleted, each with its most preferred usable substitute.
+const replacementQuery = `SELECT om.id, om.material_id, m.name, m.deleted_at IS NOT NULL, r.id, r.name
+	FROM offer_material om
+	JOIN material m ON m.id = om.material_id
+	LEFT JOIN LATERAL (
+		SELECT s.id, s.name FROM material_substitute ms JOIN material s ON s.id = ms.substitute_id
+		WHERE ms.material_id = om.material_id AND ms.tenant_id = om.tenant_id AND ms.deleted_at IS NULL AND s.active AND s.deleted_at IS NULL
+		ORDER BY ms.priority, ms.id
+		LIMIT 1
+	) r ON true
+	WHERE om.offer_id = $1 AND om.tenant_id = $2 AND om.deleted_at IS NULL AND (NOT m.active OR m.deleted_at IS NOT NULL)
+	ORDER BY om.id`
+
+func GetMaterialSubstitutes(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT ms.id, ms.material_id, ms.substitute_id, m.name, ms.priority, ms.created_at, ms.updated_at, ms.deleted_at
+			FROM material_substitute ms JOIN material m ON m.id = ms.substitute_id
+			WHERE ms.material_id = $1 AND ms.tenant_id = $2 AND ms.deleted_at IS NULL AND m.deleted_at IS NULL
+			ORDER BY ms.priority, ms.id`, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		substitutes := []models.MaterialSubstitute{}
+		for rows.Next() {
+			var substitute models.MaterialSubstitute
+			if err := rows.Scan(&substitute.ID, &substitute.MaterialID, &substitute.SubstituteID, &substitute.SubstituteName, &substitute.Priority, &substitute.CreatedAt, &substitute.UpdatedAt, &substitute.DeletedAt); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			substitutes = append(substitutes, substitute)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(substitutes)
+	}
+}
+
+func CreateMaterialSubstitute(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		materialID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var substitute models.MaterialSubstitute
+		if err := json.NewDecoder(r.Body).Decode(&substitute); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		substitute.MaterialID = materialID
+		if substitute.SubstituteID == materialID {
+			http.Error(w, "a material cannot substitute itself", http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenant.FromContext(r.Context())
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", materialID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", substitute.SubstituteID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "substitute material not found", http.StatusBadRequest)
+			return
+		}
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM material_substitute WHERE material_id = $1 AND substitute_id = $2 AND tenant_id = $3 AND deleted_at IS NULL)", materialID, substitute.SubstituteID, tenantID).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if exists {
+			http.Error(w, "substitute already declared", http.StatusConflict)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), "INSERT INTO material_substitute (material_id, substitute_id, priority, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at",
+			materialID, substitute.SubstituteID, substitute.Priority, tenantID).
+			Scan(&substitute.ID, &substitute.CreatedAt, &substitute.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(substitute)
+	}
+}
+
+// UpdateMaterialSubstitute changes the preference order of a substitute.
+func UpdateMaterialSubstitute(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var substitute models.MaterialSubstitute
+		if err := json.NewDecoder(r.Body).Decode(&substitute); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = db.QueryRowContext(r.Context(), `UPDATE material_substitute SET priority = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL
+			RETURNING id, material_id, substitute_id, created_at, updated_at`, substitute.Priority, id, tenant.FromContext(r.Context())).
+			Scan(&substitute.ID, &substitute.MaterialID, &substitute.SubstituteID, &substitute.CreatedAt, &substitute.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Material substitute not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(substitute)
+	}
+}
+
+func DeleteMaterialSubstitute(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), `UPDATE material_substitute SET deleted_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Material substitute not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func proposeReplacements(ctx context.Context, q queryer, offerID int) ([]models.ReplacementProposal, error) {
+	rows, err := q.QueryContext(ctx, replacementQuery, offerID, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	proposals := []models.ReplacementProposal{}
+	for rows.Next() {
+		var (
+			proposal models.ReplacementProposal
+			deleted  bool
+		)
+		if err := rows.Scan(&proposal.OfferMaterialID, &proposal.MaterialID, &proposal.MaterialName, &deleted, &proposal.SubstituteID, &proposal.SubstituteName); err != nil {
+			return nil, err
+		}
+		proposal.Reason = models.ReplaceInactive
+		if deleted {
+			proposal.Reason = models.ReplaceDeleted
+		}
+		proposals = append(proposals, proposal)
+	}
+	return proposals, rows.Err()
+}
+
+// GetOfferReplacements proposes substitutes for offer lines that reference
+// inactive or deleted materials.
+func GetOfferReplacements(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant.FromContext(r.Context())).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+
+		proposals, err := proposeReplacements(r.Context(), db, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.OfferReplacements{OfferID: id, Proposals: proposals})
+	}
+}
+
+// ApplyOfferReplacements swaps in every proposed substitute in one
+// transaction. Lines without a substitute are left as they are and stay in
+// the response. Only draft and sent offers can be changed, since accepted
+// offers hold reservations for their current materials.
+func ApplyOfferReplacements(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		var status string
+		var owner *string
+		err = tx.QueryRowContext(ctx, "SELECT status, owner_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenantID).Scan(&status, &owner)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkOfferOwner(ctx, owner); err != nil {
+			writeError(w, err)
+			return
+		}
+		if status != models.OfferDraft && status != models.OfferSent {
+			http.Error(w, "only draft and sent offers can be changed", http.StatusConflict)
+			return
+		}
+
+		proposals, err := proposeReplacements(ctx, tx, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, proposal := range proposals {
+			if proposal.SubstituteID == nil {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, "UPDATE offer_material SET material_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND tenant_id = $3",
+				*proposal.SubstituteID, proposal.OfferMaterialID, tenantID)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.OfferReplacements{OfferID: id, Applied: true, Proposals: proposals})
+	}
+}
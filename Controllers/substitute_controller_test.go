@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyOfferReplacements(t *testing.T) {
+	lockOffer := regexp.QuoteMeta(`SELECT status, owner_id FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`)
+	proposals := regexp.QuoteMeta(`SELECT om.id, om.material_id, m.name, m.deleted_at IS NOT NULL, r.id, r.name`)
+	replace := regexp.QuoteMeta(`UPDATE offer_material SET material_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND tenant_id = $3`)
+	proposalColumns := []string{"id", "material_id", "name", "deleted", "substitute_id", "substitute_name"}
+
+	testCases := []struct {
+		name         string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+		unresolved   int
+	}{
+		{
+			name:         "success - substitutes applied, line without one kept",
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow("draft", nil))
+				mock.ExpectQuery(proposals).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows(proposalColumns).
+					AddRow(10, 2, "Old steel", false, 5, "New steel").
+					AddRow(11, 3, "Old copper", true, nil, nil))
+				mock.ExpectExec(replace).WithArgs(5, 10, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			unresolved: 1,
+		},
+		{
+			name:         "failure - accepted offer",
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}).AddRow("accepted", nil))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - offer not found",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockOffer).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"status", "owner_id"}))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/offers/1/replacements", nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := ApplyOfferReplacements(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				var result models.OfferReplacements
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+				assert.True(t, result.Applied)
+				unresolved := 0
+				for _, proposal := range result.Proposals {
+					if proposal.SubstituteID == nil {
+						unresolved++
+					}
+				}
+				assert.Equal(t, tc.unresolved, unresolved)
+				assert.Equal(t, models.ReplaceDeleted, result.Proposals[1].Reason)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func GetSuppliers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT "+supplierColumns+" FROM supplier WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY name, id", tenant.FromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error querying database: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		suppliers := []models.Supplier{}
+		for rows.Next() {
+			var supplier models.Supplier
+			if err := scanSupplier(rows, &supplier); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			suppliers = append(suppliers, supplier)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(suppliers)
+	}
+}
+
+func GetSupplierByID(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		var supplier models.Supplier
+		err := scanSupplier(db.QueryRowContext(r.Context(), "SELECT "+supplierColumns+" FROM supplier WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context())), &supplier)
+		if err != nil {
+			http.Error(w, "Supplier not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(supplier)
+	}
+}
+
+func CreateSupplier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var supplier models.Supplier
+		if err := json.NewDecoder(r.Body).Decode(&supplier); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		supplier.Name = strings.TrimSpace(supplier.Name)
+		if supplier.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		err := db.QueryRowContext(r.Context(), "INSERT INTO supplier (name, email, phone, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at", supplier.Name, supplier.Email, supplier.Phone, tenant.FromContext(r.Context())).
+			Scan(&supplier.ID, &supplier.CreatedAt, &supplier.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(supplier)
+	}
+}
+
+func UpdateSupplier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var supplier models.Supplier
+		if err := json.NewDecoder(r.Body).Decode(&supplier); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		supplier.ID = id
+		supplier.Name = strings.TrimSpace(supplier.Name)
+		if supplier.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE supplier SET name = $1, email = $2, phone = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND tenant_id = $5 AND deleted_at IS NULL",
+			supplier.Name, supplier.Email, supplier.Phone, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Supplier not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(supplier)
+	}
+}
+
+// DeleteSupplier soft deletes a supplier together with its material links.
+func DeleteSupplier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(ctx, "UPDATE supplier SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Supplier not found", http.StatusNotFound)
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE material_supplier SET deleted_at = CURRENT_TIMESTAMP WHERE supplier_id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenantID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+const supplierColumns = `id, name, email, phone, created_at, updated_at, deleted_at`
+
+func scanSupplier(row interface{ Scan(...interface{}) error }, s *models.Supplier) error {
+	return row.Scan(&s.ID, &s.Name, &s.Email, &s.Phone, &s.CreatedAt, &s.UpdatedAt, &s.DeletedAt)
+}
+
+const materialSupplierColumns = `ms.id, ms.material_id, ms.supplier_id, s.name, ms.supplier_sku, ms.purchase_price, ms.lead_time_days, ms.preferred, ms.created_at, ms.updated_at, ms.deleted_at`
+
+func scanMaterialSupplier(row interface{ Scan(...interface{}) error }, ms *models.MaterialSupplier) error {
+	return row.Scan(&ms.ID, &ms.MaterialID, &ms.SupplierID, &ms.SupplierName, &ms.SupplierSKU, &ms.PurchasePrice, &ms.LeadTimeDays, &ms.Preferred, &ms.CreatedAt, &ms.UpdatedAt, &ms.DeletedAt)
+}
+
+// GetMaterialSuppliers lists the sources of a material, preferred first and
+// then by price.
+func GetMaterialSuppliers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant.FromContext(r.Context())).Scan(&exists)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT `+materialSupplierColumns+`
+			FROM material_supplier ms
+			JOIN supplier s ON s.id = ms.supplier_id AND s.deleted_at IS NULL
+			WHERE ms.material_id = $1 AND ms.tenant_id = $2 AND ms.deleted_at IS NULL
+			ORDER BY ms.preferred DESC, ms.purchase_price, ms.id`, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		sources := []models.MaterialSupplier{}
+		for rows.Next() {
+			var source models.MaterialSupplier
+			if err := scanMaterialSupplier(rows, &source); err != nil {
+				log.Printf("Error scanning rows: %v", err)
+				http.Error(w, "error processing database results", http.StatusInternalServerError)
+				return
+			}
+			sources = append(sources, source)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, "error iterating over results", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sources)
+	}
+}
+
+func validateMaterialSupplier(ctx context.Context, tx *sql.Tx, source models.MaterialSupplier) error {
+	if source.PurchasePrice < 0 {
+		return badRequest("purchase_price must not be negative")
+	}
+	if source.LeadTimeDays < 0 {
+		return badRequest("lead_time_days must not be negative")
+	}
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM supplier WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", source.SupplierID, tenant.FromContext(ctx)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return badRequest("supplier not found")
+	}
+	return nil
+}
+
+// clearPreferredSupplier makes room for a new preferred source, keeping the
+// one-preferred-per-material index satisfied.
+func clearPreferredSupplier(ctx context.Context, tx *sql.Tx, materialID, exceptID int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE material_supplier SET preferred = false, updated_at = CURRENT_TIMESTAMP WHERE material_id = $1 AND id <> $2 AND tenant_id = $3 AND preferred AND deleted_at IS NULL",
+		materialID, exceptID, tenant.FromContext(ctx))
+	return err
+}
+
+// CreateMaterialSupplier links a supplier to the material in the URL.
+func CreateMaterialSupplier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		materialID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var source models.MaterialSupplier
+		if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source.MaterialID = materialID
+
+		ctx := r.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", materialID, tenant.FromContext(ctx)).Scan(&exists); err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Material not found", http.StatusNotFound)
+			return
+		}
+		if err := validateMaterialSupplier(ctx, tx, source); err != nil {
+			writeError(w, err)
+			return
+		}
+		if source.Preferred {
+			if err := clearPreferredSupplier(ctx, tx, materialID, 0); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, `INSERT INTO material_supplier (material_id, supplier_id, supplier_sku, purchase_price, lead_time_days, preferred, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at`,
+			source.MaterialID, source.SupplierID, source.SupplierSKU, source.PurchasePrice, source.LeadTimeDays, source.Preferred, tenant.FromContext(ctx)).
+			Scan(&source.ID, &source.CreatedAt, &source.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(source)
+	}
+}
+
+func UpdateMaterialSupplier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var source models.MaterialSupplier
+		if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source.ID = id
+
+		ctx := r.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		err = tx.QueryRowContext(ctx, "SELECT material_id FROM material_supplier WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(ctx)).Scan(&source.MaterialID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Material supplier not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := validateMaterialSupplier(ctx, tx, source); err != nil {
+			writeError(w, err)
+			return
+		}
+		if source.Preferred {
+			if err := clearPreferredSupplier(ctx, tx, source.MaterialID, id); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE material_supplier SET supplier_id = $1, supplier_sku = $2, purchase_price = $3, lead_time_days = $4, preferred = $5, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $6`, source.SupplierID, source.SupplierSKU, source.PurchasePrice, source.LeadTimeDays, source.Preferred, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(source)
+	}
+}
+
+func DeleteMaterialSupplier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), "UPDATE material_supplier SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.FromContext(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Material supplier not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"Products/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMaterialSuppliers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)).
+		WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT ms.id, ms.material_id, ms.supplier_id, s.name, .+ORDER BY ms.preferred DESC, ms.purchase_price, ms.id`).
+		WithArgs(1, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "material_id", "supplier_id", "name", "supplier_sku", "purchase_price", "lead_time_days", "preferred", "created_at", "updated_at", "deleted_at"}).
+			AddRow(4, 1, 2, "Steel Works", "SW-100", 8.5, 3, true, time.Now(), time.Now(), nil).
+			AddRow(5, 1, 3, "Metal Depot", "MD-7", 7.9, 14, false, time.Now(), time.Now(), nil))
+
+	req := withTenant(httptest.NewRequest("GET", "/materials/1/suppliers", nil), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	handler := GetMaterialSuppliers(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var sources []models.MaterialSupplier
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sources))
+	assert.Len(t, sources, 2)
+	assert.Equal(t, "Steel Works", sources[0].SupplierName)
+	assert.True(t, sources[0].Preferred)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateMaterialSupplier(t *testing.T) {
+	materialExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM material WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	supplierExists := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM supplier WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`)
+	clearPreferred := regexp.QuoteMeta(`UPDATE material_supplier SET preferred = false`)
+	insert := regexp.QuoteMeta(`INSERT INTO material_supplier (material_id, supplier_id, supplier_sku, purchase_price, lead_time_days, preferred, tenant_id)`)
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - preferred replaces previous preferred",
+			requestBody:  `{"supplier_id": 2, "supplier_sku": "SW-100", "purchase_price": 8.5, "lead_time_days": 3, "preferred": true}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(materialExists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(supplierExists).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectExec(clearPreferred).WithArgs(1, 0, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(insert).WithArgs(1, 2, "SW-100", 8.5, 3, true, "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(4, time.Now(), time.Now()))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - supplier not found",
+			requestBody:  `{"supplier_id": 9, "purchase_price": 8.5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(materialExists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(supplierExists).WithArgs(9, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - negative price",
+			requestBody:  `{"supplier_id": 2, "purchase_price": -1}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(materialExists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - material not found",
+			requestBody:  `{"supplier_id": 2}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(materialExists).WithArgs(1, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/materials/1/suppliers", strings.NewReader(tc.requestBody)), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := CreateMaterialSupplier(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteSupplier(t *testing.T) {
+	remove := regexp.QuoteMeta(`UPDATE supplier SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+	unlink := regexp.QuoteMeta(`UPDATE material_supplier SET deleted_at = CURRENT_TIMESTAMP WHERE supplier_id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+
+	testCases := []struct {
+		name         string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - links of the tenant removed",
+			expectedCode: http.StatusNoContent,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(remove).WithArgs(2, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(unlink).WithArgs(2, "acme").WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - supplier of another tenant",
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(remove).WithArgs(2, "acme").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("DELETE", "/suppliers/2", nil), "acme")
+			req = mux.SetURLVars(req, map[string]string{"id": "2"})
+			w := httptest.NewRecorder()
+
+			handler := DeleteSupplier(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateOfferMaterialDefaultPrice(t *testing.T) {
+	preferredCost := regexp.QuoteMeta(`SELECT ms.purchase_price FROM material_supplier ms`)
+	insert := regexp.QuoteMeta(`INSERT INTO offer_material (offer_id, material_id, quantity, unit_price, tenant_id) VALUES ($1, $2, $3, $4, $5)`)
+
+	testCases := []struct {
+		name          string
+		markup        string
+		requestBody   string
+		expectedPrice float64
+		mockQueries   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:          "preferred supplier cost plus default markup",
+			requestBody:   `{"offer_id": 1, "material_id": 2, "quantity": 3}`,
+			expectedPrice: 10.63,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(preferredCost).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"purchase_price"}).AddRow(8.5))
+			},
+		},
+		{
+			name:          "configured markup",
+			markup:        "10",
+			requestBody:   `{"offer_id": 1, "material_id": 2}`,
+			expectedPrice: 9.35,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(preferredCost).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"purchase_price"}).AddRow(8.5))
+			},
+		},
+		{
+			name:          "no preferred supplier",
+			requestBody:   `{"offer_id": 1, "material_id": 2}`,
+			expectedPrice: 0,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(preferredCost).WithArgs(2, "acme").WillReturnRows(sqlmock.NewRows([]string{"purchase_price"}))
+			},
+		},
+		{
+			name:          "explicit price wins",
+			requestBody:   `{"offer_id": 1, "material_id": 2, "unit_price": 0}`,
+			expectedPrice: 0,
+			mockQueries:   func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PRICE_MARKUP_PERCENT", tc.markup)
+
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+			mock.ExpectBegin()
+			expectOfferMaterialLink(mock, 1, "draft", 2, true)
+			mock.ExpectQuery(insert).WithArgs(1, 2, sqlmock.AnyArg(), tc.expectedPrice, "acme").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+			expectEvent(mock, "offer", "1", models.EventOfferMaterialAdded)
+			mock.ExpectCommit()
+
+			req := withTenant(httptest.NewRequest("POST", "/offer-materials", strings.NewReader(tc.requestBody)), "acme")
+			w := httptest.NewRecorder()
+
+			handler := CreateOfferMaterial(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var offerMaterial models.OfferMaterial
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&offerMaterial))
+			assert.Equal(t, tc.expectedPrice, offerMaterial.UnitPrice)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
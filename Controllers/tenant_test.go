@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/tenant"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withTenant returns req acting for tenant id, as tenant.Middleware would.
+func withTenant(req *http.Request, id string) *http.Request {
+	return req.WithContext(tenant.WithID(req.Context(), id))
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	platformAdmin := auth.Principal{ID: "ops", Roles: []string{auth.RolePlatformAdmin}}
+	tests := []struct {
+		name       string
+		principal  auth.Principal
+		header     string
+		defaultID  string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "bound principal", principal: auth.Principal{ID: "k", Tenant: "acme"}, defaultID: "default", wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "bound principal with same header", principal: auth.Principal{ID: "k", Tenant: "acme"}, header: "acme", wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "bound principal with other header", principal: auth.Principal{ID: "k", Tenant: "acme"}, header: "globex", wantStatus: http.StatusForbidden},
+		{name: "platform admin with header", principal: platformAdmin, header: "globex", defaultID: "default", wantStatus: http.StatusOK, wantTenant: "globex"},
+		{name: "platform admin default", principal: platformAdmin, defaultID: "default", wantStatus: http.StatusOK, wantTenant: "default"},
+		{name: "platform admin without tenant", principal: platformAdmin, wantStatus: http.StatusBadRequest},
+		{name: "platform admin with invalid header", principal: platformAdmin, header: "*", wantStatus: http.StatusBadRequest},
+		{name: "unbound principal", principal: auth.Principal{ID: "u", Roles: []string{auth.RoleAdmin}}, defaultID: "default", wantStatus: http.StatusForbidden},
+		{name: "unbound principal with header", principal: auth.Principal{ID: "u", Roles: []string{auth.RoleAdmin}}, header: "globex", wantStatus: http.StatusForbidden},
+		{name: "no principal", header: "globex", defaultID: "default", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/offers", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+			if tt.header != "" {
+				req.Header.Set(tenant.HeaderID, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			var got string
+			tenant.Middleware(tt.defaultID)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = tenant.FromContext(r.Context())
+			})).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantTenant, got)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"Products/config"
+	"Products/locale"
+	"Products/models"
+	"Products/tenant"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// localizedNames returns, by record ID, the name in the first locale of
+// the request's fallback chain that has a translation. Records missing
+// from the result keep their own name, which is in the default locale.
+func localizedNames(r *http.Request, db *sql.DB, entity string, ids []int) (map[int]models.Translation, error) {
+	names := map[int]models.Translation{}
+	chain := locale.Chain(r, config.NameLocale())
+	// The last locale is the default one, which needs no lookup.
+	chain = chain[:len(chain)-1]
+	if len(chain) == 0 || len(ids) == 0 {
+		return names, nil
+	}
+
+	rows, err := db.QueryContext(r.Context(), `SELECT DISTINCT ON (entity_id) entity_id, locale, name FROM name_translation
+		WHERE entity = $1 AND entity_id = ANY($2) AND locale = ANY($3)
+		ORDER BY entity_id, array_position($3::text[], locale)`, entity, pq.Array(ids), pq.Array(chain))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id          int
+			translation models.Translation
+		)
+		if err := rows.Scan(&id, &translation.Locale, &translation.Name); err != nil {
+			return nil, err
+		}
+		names[id] = translation
+	}
+	return names, rows.Err()
+}
+
+// localizeOffers replaces the names of offers with the best translation
+// for the request.
+func localizeOffers(w http.ResponseWriter, r *http.Request, db *sql.DB, offers []models.Offer) error {
+	ids := make([]int, len(offers))
+	for i, offer := range offers {
+		ids[i] = offer.ID
+	}
+	names, err := localizedNames(r, db, models.EntityOffer, ids)
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	for i := range offers {
+		offers[i].Locale = config.NameLocale()
+		if name, ok := names[offers[i].ID]; ok {
+			offers[i].Name, offers[i].Locale = name.Name, name.Locale
+		}
+	}
+	return nil
+}
+
+// localizeMaterials replaces the names of materials with the best
+// translation for the request.
+func localizeMaterials(w http.ResponseWriter, r *http.Request, db *sql.DB, materials []models.Material) error {
+	ids := make([]int, len(materials))
+	for i, material := range materials {
+		ids[i] = material.ID
+	}
+	names, err := localizedNames(r, db, models.EntityMaterial, ids)
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	for i := range materials {
+		materials[i].Locale = config.NameLocale()
+		if name, ok := names[materials[i].ID]; ok {
+			materials[i].Name, materials[i].Locale = name.Name, name.Locale
+		}
+	}
+	return nil
+}
+
+// parseTranslationLocale reads the locale in the URL, which must be one of
+// LOCALES other than the default locale; names in the default locale are
+// the record's own name.
+func parseTranslationLocale(r *http.Request) (string, error) {
+	value := mux.Vars(r)["locale"]
+	tag := locale.Normalize(value)
+	if tag == "" {
+		return "", badRequest("invalid locale %q", value)
+	}
+	if tag == config.NameLocale() {
+		return "", badRequest("names in the default locale %s are set on the record itself", tag)
+	}
+	if !slices.Contains(config.Locales(), tag) {
+		return "", badRequest("unsupported locale %q, expected one of %s", tag, strings.Join(config.Locales(), ", "))
+	}
+	return tag, nil
+}
+
+// GetTranslations lists the translated names of the material or offer in
+// the URL.
+func GetTranslations(db *sql.DB, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		if err := checkAttachmentOwner(r.Context(), db, entity, id, false); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT locale, name, updated_at FROM name_translation WHERE entity = $1 AND entity_id = $2 AND tenant_id = $3 ORDER BY locale",
+			entity, id, tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		translations := []models.Translation{}
+		for rows.Next() {
+			var translation models.Translation
+			if err := rows.Scan(&translation.Locale, &translation.Name, &translation.UpdatedAt); err != nil {
+				writeError(w, err)
+				return
+			}
+			translations = append(translations, translation)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(translations)
+	}
+}
+
+// PutTranslation sets the name of the material or offer in the URL in one
+// locale.
+func PutTranslation(db *sql.DB, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		tag, err := parseTranslationLocale(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var translation models.Translation
+		if err := json.NewDecoder(r.Body).Decode(&translation); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		translation.Name = strings.TrimSpace(translation.Name)
+		if translation.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		translation.Locale = tag
+
+		ctx := r.Context()
+		if err := checkAttachmentOwner(ctx, db, entity, id, true); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		err = db.QueryRowContext(ctx, `INSERT INTO name_translation (entity, entity_id, locale, name, tenant_id) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (entity, entity_id, locale) DO UPDATE SET name = EXCLUDED.name, updated_at = CURRENT_TIMESTAMP
+			RETURNING updated_at`, entity, id, tag, translation.Name, tenant.FromContext(ctx)).Scan(&translation.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(translation)
+	}
+}
+
+// DeleteTranslation removes the name of the material or offer in the URL
+// in one locale; it falls back along the chain again.
+func DeleteTranslation(db *sql.DB, entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		tag, err := parseTranslationLocale(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ctx := r.Context()
+		if err := checkAttachmentOwner(ctx, db, entity, id, true); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		res, err := db.ExecContext(ctx, "DELETE FROM name_translation WHERE entity = $1 AND entity_id = $2 AND locale = $3 AND tenant_id = $4",
+			entity, id, tag, tenant.FromContext(ctx))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Translation not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetMissingTranslationReport lists, per locale of LOCALES, the materials
+// and offers without a name in it. ?locale= and ?entity= narrow the
+// report to one locale or one kind of record.
+func GetMissingTranslationReport(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		locales := slices.DeleteFunc(config.Locales(), func(tag string) bool { return tag == config.NameLocale() })
+		if value := r.URL.Query().Get("locale"); value != "" {
+			tag := locale.Normalize(value)
+			if !slices.Contains(locales, tag) {
+				http.Error(w, "unsupported locale "+strconv.Quote(value), http.StatusBadRequest)
+				return
+			}
+			locales = []string{tag}
+		}
+		entities := []string{models.EntityMaterial, models.EntityOffer}
+		if value := r.URL.Query().Get("entity"); value != "" {
+			if _, ok := attachmentEntities[value]; !ok {
+				http.Error(w, "unknown entity "+strconv.Quote(value), http.StatusBadRequest)
+				return
+			}
+			entities = []string{value}
+		}
+
+		report := make([]models.MissingTranslations, len(locales))
+		byLocale := map[string]*models.MissingTranslations{}
+		for i, tag := range locales {
+			report[i] = models.MissingTranslations{Locale: tag, Missing: []models.MissingTranslation{}}
+			byLocale[tag] = &report[i]
+		}
+
+		for _, entity := range entities {
+			rows, err := db.QueryContext(r.Context(), `SELECT l.locale, e.id, e.name
+				FROM `+attachmentEntities[entity].table+` e CROSS JOIN unnest($1::text[]) AS l(locale)
+				WHERE e.tenant_id = $2 AND e.deleted_at IS NULL AND NOT EXISTS (
+					SELECT 1 FROM name_translation t WHERE t.entity = $3 AND t.entity_id = e.id AND t.locale = l.locale)
+				ORDER BY l.locale, e.id`, pq.Array(locales), tenant.FromContext(r.Context()), entity)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			for rows.Next() {
+				var (
+					tag     string
+					missing = models.MissingTranslation{Entity: entity}
+				)
+				if err := rows.Scan(&tag, &missing.EntityID, &missing.Name); err != nil {
+					rows.Close()
+					writeError(w, err)
+					return
+				}
+				byLocale[tag].Missing = append(byLocale[tag].Missing, missing)
+				byLocale[tag].Count++
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"Products/auth"
+	"Products/models"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var translationQuery = regexp.QuoteMeta(`SELECT DISTINCT ON (entity_id) entity_id, locale, name FROM name_translation`)
+
+func TestGetOfferByIDLocalized(t *testing.T) {
+	testCases := []struct {
+		name           string
+		url            string
+		acceptLanguage string
+		chain          []string
+		translation    []driver.Value
+		wantName       string
+		wantLocale     string
+	}{
+		{
+			name:           "region falls back to its language",
+			url:            "/offers/1",
+			acceptLanguage: "de-AT, fr;q=0.5",
+			chain:          []string{"de-at", "de", "fr"},
+			translation:    []driver.Value{1, "de", "Dachsanierung"},
+			wantName:       "Dachsanierung",
+			wantLocale:     "de",
+		},
+		{
+			name:           "lang parameter comes first",
+			url:            "/offers/1?lang=fr",
+			acceptLanguage: "de",
+			chain:          []string{"fr", "de"},
+			translation:    []driver.Value{1, "fr", "Rénovation du toit"},
+			wantName:       "Rénovation du toit",
+			wantLocale:     "fr",
+		},
+		{
+			name:           "untranslated keeps the default name",
+			url:            "/offers/1",
+			acceptLanguage: "it, en;q=0.8, de;q=0.5",
+			chain:          []string{"it"},
+			wantName:       "Roof renovation",
+			wantLocale:     "en",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM offer WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)).WithArgs("1", "acme").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at", "status", "valid_until", "tenant_id", "owner_id", "customer_id"}).
+					AddRow(1, "Roof renovation", time.Now(), time.Now(), nil, "draft", nil, "acme", nil, nil))
+			expectMetadata(mock, models.EntityOffer)
+			rows := sqlmock.NewRows([]string{"entity_id", "locale", "name"})
+			if tc.translation != nil {
+				rows.AddRow(tc.translation...)
+			}
+			mock.ExpectQuery(translationQuery).WithArgs(models.EntityOffer, pq.Array([]int{1}), pq.Array(tc.chain)).WillReturnRows(rows)
+
+			req := withTenant(httptest.NewRequest("GET", tc.url, nil), "acme")
+			req.Header.Set("Accept-Language", tc.acceptLanguage)
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+
+			handler := GetOfferByID(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+			var offer models.Offer
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&offer))
+			assert.Equal(t, tc.wantName, offer.Name)
+			assert.Equal(t, tc.wantLocale, offer.Locale)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPutTranslation(t *testing.T) {
+	upsert := regexp.QuoteMeta(`INSERT INTO name_translation (entity, entity_id, locale, name, tenant_id) VALUES ($1, $2, $3, $4, $5)`)
+
+	testCases := []struct {
+		name         string
+		locale       string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - locale is normalized",
+			locale:       "DE",
+			requestBody:  `{"name": " Stahlblech "}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(materialExistsQuery).WithArgs(4, "acme").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(upsert).WithArgs(models.EntityMaterial, 4, "de", "Stahlblech", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+			},
+		},
+		{
+			name:         "failure - default locale",
+			locale:       "en",
+			requestBody:  `{"name": "Steel sheet"}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - unsupported locale",
+			locale:       "it",
+			requestBody:  `{"name": "Lamiera"}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - empty name",
+			locale:       "fr",
+			requestBody:  `{"name": " "}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := asPrincipal(httptest.NewRequest("PUT", "/materials/4/translations/"+tc.locale, strings.NewReader(tc.requestBody)), "user-1", auth.RoleCatalogAdmin)
+			req = mux.SetURLVars(req, map[string]string{"id": "4", "locale": tc.locale})
+			w := httptest.NewRecorder()
+
+			handler := PutTranslation(db, models.EntityMaterial)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetMissingTranslationReport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM material e CROSS JOIN unnest($1::text[]) AS l(locale)`)).
+		WithArgs(pq.Array([]string{"de", "fr"}), "acme", models.EntityMaterial).
+		WillReturnRows(sqlmock.NewRows([]string{"locale", "id", "name"}).
+			AddRow("de", 1, "Steel sheet").
+			AddRow("fr", 1, "Steel sheet").
+			AddRow("fr", 2, "Copper pipe"))
+
+	req := withTenant(httptest.NewRequest("GET", "/reports/missing-translations?entity=material", nil), "acme")
+	w := httptest.NewRecorder()
+
+	handler := GetMissingTranslationReport(db)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report []models.MissingTranslations
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Len(t, report, 2)
+	assert.Equal(t, "de", report[0].Locale)
+	assert.Equal(t, 1, report[0].Count)
+	assert.Equal(t, "fr", report[1].Locale)
+	assert.Equal(t, 2, report[1].Count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
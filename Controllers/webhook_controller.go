@@ -0,0 +1,497 @@
+package controllers
+
+import (
+	"Products/config"
+	"Products/models"
+	"Products/tenant"
+	"Products/webhook"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// execer runs statements on a *sql.DB or inside a *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// webhookLease is how long a claimed delivery is left alone before another
+// worker may try it again, in case the claiming one died.
+const webhookLease = time.Minute
+
+// dueDelivery is a delivery claimed by DeliverWebhooks.
+type dueDelivery struct {
+	id, attempts     int
+	event, tenantID  string
+	payload          []byte
+	url, secret      string
+	subscriptionLive bool
+}
+
+// DeliverWebhooks sends up to limit due deliveries across all tenants and
+// returns how many it tried. Failures are retried with exponential backoff
+// until WEBHOOK_MAX_ATTEMPTS, after which the delivery is dead.
+func DeliverWebhooks(ctx context.Context, db *sql.DB, client *http.Client, limit int) (int, error) {
+	ctx = tenant.WithID(ctx, tenant.All)
+	rows, err := db.QueryContext(ctx, `UPDATE webhook_delivery d SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		FROM webhook_subscription s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT id FROM webhook_delivery WHERE status = $3 AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at, id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.attempts, d.event, d.tenant_id, d.payload, s.url, s.secret, s.active AND s.deleted_at IS NULL`,
+		limit, webhookLease.Seconds(), models.DeliveryPending)
+	if err != nil {
+		return 0, err
+	}
+	due := []dueDelivery{}
+	for rows.Next() {
+		var d dueDelivery
+		if err := rows.Scan(&d.id, &d.attempts, &d.event, &d.tenantID, &d.payload, &d.url, &d.secret, &d.subscriptionLive); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range due {
+		if !d.subscriptionLive {
+			_, err := db.ExecContext(ctx, "UPDATE webhook_delivery SET status = $1, last_error = $2 WHERE id = $3",
+				models.DeliveryDead, "subscription deleted or inactive", d.id)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		result := webhook.Deliver(ctx, client, d.url, d.secret, d.event, d.id, d.payload)
+		if err := recordWebhookAttempt(ctx, db, d, result); err != nil {
+			return 0, err
+		}
+	}
+	return len(due), nil
+}
+
+// recordWebhookAttempt logs an attempt and moves the delivery on: to
+// delivered, to a later retry, or to the dead letters.
+func recordWebhookAttempt(ctx context.Context, db *sql.DB, d dueDelivery, result webhook.Result) error {
+	attempt := d.attempts + 1
+	var statusCode *int
+	if result.StatusCode != 0 {
+		statusCode = &result.StatusCode
+	}
+	var message string
+	if result.Err != nil {
+		message = result.Err.Error()
+	}
+	_, err := db.ExecContext(ctx, `INSERT INTO webhook_attempt (delivery_id, attempt, status_code, error, response_body, duration_ms, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, d.id, attempt, statusCode, message, result.ResponseBody, result.Duration.Milliseconds(), d.tenantID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result.OK():
+		_, err = db.ExecContext(ctx, "UPDATE webhook_delivery SET status = $1, attempts = $2, last_error = '', delivered_at = CURRENT_TIMESTAMP WHERE id = $3",
+			models.DeliveryDelivered, attempt, d.id)
+	case attempt >= config.WebhookMaxAttempts():
+		_, err = db.ExecContext(ctx, "UPDATE webhook_delivery SET status = $1, attempts = $2, last_error = $3 WHERE id = $4",
+			models.DeliveryDead, attempt, message, d.id)
+	default:
+		wait := webhook.Backoff(attempt, config.WebhookBackoff(), config.WebhookMaxBackoff)
+		_, err = db.ExecContext(ctx, "UPDATE webhook_delivery SET attempts = $1, last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3) WHERE id = $4",
+			attempt, message, wait.Seconds(), d.id)
+	}
+	return err
+}
+
+// RunWebhookDeliveries calls DeliverWebhooks every interval until ctx is
+// done, draining the backlog in batches.
+func RunWebhookDeliveries(ctx context.Context, db *sql.DB, client *http.Client, interval time.Duration) {
+	const batch = 50
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := DeliverWebhooks(ctx, db, client, batch)
+			if err != nil {
+				log.Printf("Error delivering webhooks: %v", err)
+			}
+			if err != nil || n < batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const webhookSubscriptionColumns = `id, url, events, active, description, tenant_id, created_at, updated_at, deleted_at`
+
+func scanWebhookSubscription(row interface{ Scan(...interface{}) error }, s *models.WebhookSubscription) error {
+	return row.Scan(&s.ID, &s.URL, pq.Array(&s.Events), &s.Active, &s.Description, &s.TenantID, &s.CreatedAt, &s.UpdatedAt, &s.DeletedAt)
+}
+
+// decodeWebhookSubscription reads and checks a subscription. The URL must
+// be absolute http(s) and the events known ones.
+func decodeWebhookSubscription(r *http.Request) (models.WebhookSubscription, error) {
+	var s models.WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		return s, badRequest("%s", err.Error())
+	}
+	target, err := url.Parse(s.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return s, badRequest("url must be an absolute http or https URL")
+	}
+	if len(s.Events) == 0 {
+		return s, badRequest("events is required")
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			return s, badRequest("unknown event %q, expected one of %s", event, strings.Join(models.WebhookEvents, ", "))
+		}
+	}
+	if s.Active == nil {
+		active := true
+		s.Active = &active
+	}
+	return s, nil
+}
+
+// GetWebhookSubscriptions lists the tenant's webhook subscriptions.
+func GetWebhookSubscriptions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscription WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id",
+			tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		subscriptions := []models.WebhookSubscription{}
+		for rows.Next() {
+			var s models.WebhookSubscription
+			if err := scanWebhookSubscription(rows, &s); err != nil {
+				writeError(w, err)
+				return
+			}
+			subscriptions = append(subscriptions, s)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subscriptions)
+	}
+}
+
+// GetWebhookSubscription returns one subscription, without its secret.
+func GetWebhookSubscription(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var s models.WebhookSubscription
+		err := scanWebhookSubscription(db.QueryRowContext(r.Context(), "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscription WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL",
+			mux.Vars(r)["id"], tenant.FromContext(r.Context())), &s)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// CreateWebhookSubscription registers an endpoint for some event types.
+// The response is the only place the generated signing secret is shown.
+func CreateWebhookSubscription(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := decodeWebhookSubscription(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if s.Secret, err = webhook.NewSecret(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		s.TenantID = tenant.FromContext(r.Context())
+		err = db.QueryRowContext(r.Context(), `INSERT INTO webhook_subscription (url, events, secret, active, description, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`,
+			s.URL, pq.Array(s.Events), s.Secret, *s.Active, s.Description, s.TenantID).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// UpdateWebhookSubscription changes a subscription's URL, events,
+// description or active flag. The secret stays the same.
+func UpdateWebhookSubscription(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input, err := decodeWebhookSubscription(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		var s models.WebhookSubscription
+		err = scanWebhookSubscription(db.QueryRowContext(r.Context(), `UPDATE webhook_subscription SET url = $1, events = $2, active = $3, description = $4, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5 AND tenant_id = $6 AND deleted_at IS NULL RETURNING `+webhookSubscriptionColumns,
+			input.URL, pq.Array(input.Events), *input.Active, input.Description, mux.Vars(r)["id"], tenant.FromContext(r.Context())), &s)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// DeleteWebhookSubscription soft deletes a subscription. Its pending
+// deliveries become dead letters when they come up.
+func DeleteWebhookSubscription(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := db.ExecContext(r.Context(), "UPDATE webhook_subscription SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL",
+			mux.Vars(r)["id"], tenant.FromContext(r.Context()))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sampleEventData is what the test endpoint sends for each event type.
+var sampleEventData = map[string]interface{}{
+	models.EventOfferCreated:         models.Offer{ID: 1, Name: "Sample offer", Status: models.OfferDraft, Tags: []string{}, Attributes: map[string]interface{}{}},
+	models.EventOfferUpdated:         models.Offer{ID: 1, Name: "Sample offer", Status: models.OfferDraft, Tags: []string{}, Attributes: map[string]interface{}{}},
+	models.EventOfferDeleted:         models.OfferDeletedEvent{OfferID: 1},
+	models.EventOfferStatusChanged:   models.OfferStatusChangedEvent{OfferID: 1, From: models.OfferDraft, To: models.OfferSent},
+	models.EventMaterialCreated:      models.Material{ID: 1, Name: "Sample material", Active: true, Tags: []string{}, Attributes: map[string]interface{}{}},
+	models.EventMaterialUpdated:      models.Material{ID: 1, Name: "Sample material", Active: true, Tags: []string{}, Attributes: map[string]interface{}{}},
+	models.EventMaterialDeactivated:  models.MaterialDeactivatedEvent{MaterialID: 1, Name: "Sample material", AffectedOffers: []models.AffectedOffer{}},
+	models.EventMaterialDeleted:      models.MaterialDeletedEvent{MaterialID: 1},
+	models.EventOfferMaterialAdded:   models.OfferMaterial{ID: 1, OfferID: 1, MaterialID: 1, Quantity: 1, UnitPrice: 10},
+	models.EventOfferMaterialUpdated: models.OfferMaterial{ID: 1, OfferID: 1, MaterialID: 1, Quantity: 2, UnitPrice: 10},
+	models.EventOfferMaterialRemoved: models.OfferMaterialRemovedEvent{OfferMaterialID: 1, OfferID: 1, MaterialID: 1},
+	models.EventCustomerCreated:      models.Customer{ID: 1, Name: "Sample customer", Email: "buyer@example.com"},
+	models.EventCustomerUpdated:      models.Customer{ID: 1, Name: "Sample customer", Email: "buyer@example.com"},
+	models.EventCustomerDeleted:      models.CustomerDeletedEvent{CustomerID: 1},
+}
+
+// SendTestWebhook sends a sample event to a subscription right away and
+// reports how the receiver answered. ?event= picks the event type,
+// defaulting to the subscription's first one. Test deliveries are neither
+// retried nor logged.
+func SendTestWebhook(db *sql.DB, client *http.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		var (
+			target string
+			secret string
+			events []string
+		)
+		err := db.QueryRowContext(ctx, "SELECT url, secret, events FROM webhook_subscription WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL",
+			mux.Vars(r)["id"], tenantID).Scan(&target, &secret, pq.Array(&events))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		eventType := r.URL.Query().Get("event")
+		if eventType == "" && len(events) > 0 {
+			eventType = events[0]
+		}
+		data, ok := sampleEventData[eventType]
+		if !ok {
+			http.Error(w, "unknown event "+strconv.Quote(eventType), http.StatusBadRequest)
+			return
+		}
+		event, err := webhook.NewEvent(eventType, tenantID, data)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		result := webhook.Deliver(ctx, client, target, secret, eventType, 0, payload)
+		attempt := models.WebhookAttempt{Attempt: 1, ResponseBody: result.ResponseBody, DurationMS: result.Duration.Milliseconds(), CreatedAt: time.Now()}
+		if result.StatusCode != 0 {
+			attempt.StatusCode = &result.StatusCode
+		}
+		if result.Err != nil {
+			attempt.Error = result.Err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attempt)
+	}
+}
+
+const webhookDeliveryColumns = `id, subscription_id, event_id, event, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, d *models.WebhookDelivery) error {
+	return row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+}
+
+// GetWebhookDeliveries lists deliveries, newest first. ?status=dead lists
+// the dead letters; ?subscription= limits them to one subscription.
+func GetWebhookDeliveries(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := "SELECT " + webhookDeliveryColumns + " FROM webhook_delivery WHERE tenant_id = $1"
+		args := []interface{}{tenant.FromContext(r.Context())}
+		if status := r.URL.Query().Get("status"); status != "" {
+			if status != models.DeliveryPending && status != models.DeliveryDelivered && status != models.DeliveryDead {
+				http.Error(w, "invalid status", http.StatusBadRequest)
+				return
+			}
+			args = append(args, status)
+			query += " AND status = $" + strconv.Itoa(len(args))
+		}
+		if value := r.URL.Query().Get("subscription"); value != "" {
+			subscription, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "invalid subscription", http.StatusBadRequest)
+				return
+			}
+			args = append(args, subscription)
+			query += " AND subscription_id = $" + strconv.Itoa(len(args))
+		}
+
+		rows, err := db.QueryContext(r.Context(), query+" ORDER BY id DESC LIMIT 500", args...)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		deliveries := []models.WebhookDelivery{}
+		for rows.Next() {
+			var d models.WebhookDelivery
+			if err := scanWebhookDelivery(rows, &d); err != nil {
+				writeError(w, err)
+				return
+			}
+			deliveries = append(deliveries, d)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// GetWebhookDelivery returns a delivery with the log of its attempts.
+func GetWebhookDelivery(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenantID := tenant.FromContext(ctx)
+		var d models.WebhookDelivery
+		err := scanWebhookDelivery(db.QueryRowContext(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_delivery WHERE id = $1 AND tenant_id = $2",
+			mux.Vars(r)["id"], tenantID), &d)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		rows, err := db.QueryContext(ctx, "SELECT attempt, status_code, error, response_body, duration_ms, created_at FROM webhook_attempt WHERE delivery_id = $1 AND tenant_id = $2 ORDER BY attempt",
+			d.ID, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer rows.Close()
+		d.AttemptLog = []models.WebhookAttempt{}
+		for rows.Next() {
+			var a models.WebhookAttempt
+			if err := rows.Scan(&a.Attempt, &a.StatusCode, &a.Error, &a.ResponseBody, &a.DurationMS, &a.CreatedAt); err != nil {
+				writeError(w, err)
+				return
+			}
+			d.AttemptLog = append(d.AttemptLog, a)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// RetryWebhookDelivery puts a dead letter back in the queue with a fresh
+// set of attempts.
+func RetryWebhookDelivery(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var d models.WebhookDelivery
+		err := scanWebhookDelivery(db.QueryRowContext(r.Context(), `UPDATE webhook_delivery SET status = $1, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND tenant_id = $3 AND status = $4 RETURNING `+webhookDeliveryColumns,
+			models.DeliveryPending, mux.Vars(r)["id"], tenant.FromContext(r.Context()), models.DeliveryDead), &d)
+		if err == sql.ErrNoRows {
+			http.Error(w, "no dead delivery with this id", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"Products/models"
+	"Products/webhook"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a webhook endpoint that checks signatures and answers with
+// status.
+func receiver(t *testing.T, secret string, status int, received chan<- *http.Request) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), r.Header.Get(webhook.TimestampHeader), body, time.Minute))
+		if received != nil {
+			received <- r
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("thanks"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	testCases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 30 * time.Second},
+		{failures: 2, want: time.Minute},
+		{failures: 4, want: 4 * time.Minute},
+		{failures: 20, want: 6 * time.Hour},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, webhook.Backoff(tc.failures, 30*time.Second, 6*time.Hour))
+	}
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	claim := regexp.QuoteMeta(`UPDATE webhook_delivery d SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)`)
+	logAttempt := regexp.QuoteMeta(`INSERT INTO webhook_attempt (delivery_id, attempt, status_code, error, response_body, duration_ms, tenant_id)`)
+	claimedColumns := []string{"id", "attempts", "event", "tenant_id", "payload", "url", "secret", "live"}
+	payload := []byte(`{"id": "evt_1", "type": "offer.created", "data": {"id": 1}}`)
+
+	testCases := []struct {
+		name        string
+		status      int
+		attempts    int
+		live        bool
+		expectAfter func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "delivered",
+			status: http.StatusNoContent,
+			live:   true,
+			expectAfter: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(logAttempt).WithArgs(9, 1, http.StatusNoContent, "", "", sqlmock.AnyArg(), "acme").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_delivery SET status = $1, attempts = $2, last_error = '', delivered_at = CURRENT_TIMESTAMP`)).
+					WithArgs(models.DeliveryDelivered, 1, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:     "retried with backoff",
+			status:   http.StatusInternalServerError,
+			attempts: 2,
+			live:     true,
+			expectAfter: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(logAttempt).WithArgs(9, 3, http.StatusInternalServerError, "receiver answered 500 Internal Server Error", "thanks", sqlmock.AnyArg(), "acme").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_delivery SET attempts = $1, last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3)`)).
+					WithArgs(3, "receiver answered 500 Internal Server Error", 120.0, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:     "dead after the last attempt",
+			status:   http.StatusBadGateway,
+			attempts: 7,
+			live:     true,
+			expectAfter: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(logAttempt).WithArgs(9, 8, http.StatusBadGateway, sqlmock.AnyArg(), "thanks", sqlmock.AnyArg(), "acme").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_delivery SET status = $1, attempts = $2, last_error = $3`)).
+					WithArgs(models.DeliveryDead, 8, "receiver answered 502 Bad Gateway", 9).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "dead when the subscription is gone",
+			expectAfter: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_delivery SET status = $1, last_error = $2 WHERE id = $3`)).
+					WithArgs(models.DeliveryDead, "subscription deleted or inactive", 9).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WEBHOOK_MAX_ATTEMPTS", "")
+			t.Setenv("WEBHOOK_BACKOFF", "")
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			received := make(chan *http.Request, 1)
+			server := receiver(t, "whsec_test", tc.status, received)
+
+			mock.ExpectQuery(claim).WithArgs(10, 60.0, models.DeliveryPending).
+				WillReturnRows(sqlmock.NewRows(claimedColumns).AddRow(9, tc.attempts, models.EventOfferCreated, "acme", payload, server.URL, "whsec_test", tc.live))
+			tc.expectAfter(mock)
+
+			n, err := DeliverWebhooks(context.Background(), db, server.Client(), 10)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			if tc.live {
+				r := <-received
+				assert.Equal(t, models.EventOfferCreated, r.Header.Get(webhook.EventHeader))
+				assert.Equal(t, "9", r.Header.Get(webhook.DeliveryHeader))
+			} else {
+				assert.Len(t, received, 0)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateWebhookSubscription(t *testing.T) {
+	insert := regexp.QuoteMeta(`INSERT INTO webhook_subscription (url, events, secret, active, description, tenant_id)`)
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - secret is generated",
+			requestBody:  `{"url": "https://erp.example.com/hooks", "events": ["offer.created", "offer.status_changed"]}`,
+			expectedCode: http.StatusCreated,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(insert).
+					WithArgs("https://erp.example.com/hooks", pq.Array([]string{"offer.created", "offer.status_changed"}), sqlmock.AnyArg(), true, "", "acme").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+			},
+		},
+		{
+			name:         "failure - unknown event",
+			requestBody:  `{"url": "https://erp.example.com/hooks", "events": ["offer.archived"]}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - relative url",
+			requestBody:  `{"url": "/hooks", "events": ["offer.created"]}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := withTenant(httptest.NewRequest("POST", "/webhooks", strings.NewReader(tc.requestBody)), "acme")
+			w := httptest.NewRecorder()
+
+			handler := CreateWebhookSubscription(db)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if w.Code == http.StatusCreated {
+				var s models.WebhookSubscription
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&s))
+				assert.True(t, strings.HasPrefix(s.Secret, "whsec_"))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSampleEventData(t *testing.T) {
+	for _, event := range models.WebhookEvents {
+		assert.Contains(t, sampleEventData, event, "no sample payload for %s", event)
+	}
+	assert.Len(t, sampleEventData, len(models.WebhookEvents))
+}
+
+func TestSendTestWebhook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	received := make(chan *http.Request, 1)
+	server := receiver(t, "whsec_test", http.StatusOK, received)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT url, secret, events FROM webhook_subscription WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)).
+		WithArgs("1", "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"url", "secret", "events"}).AddRow(server.URL, "whsec_test", "{material.deactivated}"))
+
+	req := withTenant(httptest.NewRequest("POST", "/webhooks/1/test", nil), "acme")
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	handler := SendTestWebhook(db, server.Client())
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var attempt models.WebhookAttempt
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&attempt))
+	assert.Equal(t, http.StatusOK, *attempt.StatusCode)
+	assert.Equal(t, "", attempt.Error)
+	assert.Equal(t, models.EventMaterialDeactivated, (<-received).Header.Get(webhook.EventHeader))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
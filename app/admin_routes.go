@@ -0,0 +1,17 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func AdminRoutes(db *sql.DB, r *mux.Router) {
+	// Admin Routes
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.RequirePermission(auth.PermAPIKeysManage))
+	admin.HandleFunc("/api-keys", controllers.GetAPIKeys(db)).Methods("GET")
+	admin.HandleFunc("/api-keys", controllers.CreateAPIKey(db)).Methods("POST")
+	admin.HandleFunc("/api-keys/{id}", controllers.RevokeAPIKey(db)).Methods("DELETE")
+}
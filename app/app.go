@@ -1,8 +1,15 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"Products/Controllers"
+	"Products/auth"
+	"Products/config"
+	"Products/idempotency"
+	"Products/storage"
+	"Products/tenant"
 	"Products/utils"
 	"net/http"
 	"github.com/gorilla/mux"
@@ -10,9 +17,54 @@ import (
 
 func InitializeRoute(db *sql.DB) {
 	r := mux.NewRouter()
+	if cfg := config.Auth(); cfg.Disabled {
+		// Every request runs as an anonymous platform admin, for local
+		// development.
+		log.Println("WARNING: authentication is disabled")
+		r.Use(auth.Middleware(auth.AuthenticatorFunc(func(*http.Request) (auth.Principal, error) {
+			return auth.Principal{ID: "anonymous", Name: "anonymous", Roles: []string{auth.RolePlatformAdmin}}, nil
+		})))
+	} else {
+		authenticator, err := newAuthenticator(db, cfg)
+		if err != nil {
+			log.Fatalf("Error loading authentication keys: %v", err)
+		}
+		r.Use(auth.Middleware(authenticator))
+	}
+	r.Use(tenant.Middleware(config.Tenant()))
+	r.Use(idempotency.Middleware(idempotency.NewPostgresStore(db), config.IdempotencyTTL()))
 	OfferRoutes(db, r)
 	MaterialRoutes(db, r)
 	OfferMaterialRoutes(db, r)
+	CategoryRoutes(db, r)
+	AttributeRoutes(db, r)
+	SupplierRoutes(db, r)
+	CustomerRoutes(db, r)
+	ApprovalRoutes(db, r)
+	InventoryRoutes(db, r)
+	BOMRoutes(db, r)
+	SubstituteRoutes(db, r)
+	ReportRoutes(db, r)
+	SearchRoutes(db, r)
+	TranslationRoutes(db, r)
+
+	if interval := config.OutboxPollInterval(); interval > 0 {
+		go controllers.RunOutboxRelay(context.Background(), db, newOutboxPublisher(db), interval)
+	}
+
+	webhookClient := &http.Client{Timeout: config.WebhookTimeout()}
+	WebhookRoutes(db, webhookClient, r)
+	if interval := config.WebhookPollInterval(); interval > 0 {
+		go controllers.RunWebhookDeliveries(context.Background(), db, webhookClient, interval)
+	}
+
+	store, err := storage.NewLocal(config.AttachmentDir())
+	if err != nil {
+		log.Fatalf("Error opening attachment storage: %v", err)
+	}
+	AttachmentRoutes(db, store, r)
+	ImageRoutes(db, store, r)
+	AdminRoutes(db, r)
 
 	// Start the server
 	log.Fatal(http.ListenAndServe(":8003", utils.JsonContentTypeMiddleware(r))) // Running on port 8002
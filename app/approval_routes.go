@@ -0,0 +1,21 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func ApprovalRoutes(db *sql.DB, r *mux.Router) {
+	// Approval rule Routes
+	r.Handle("/approval-rules", can(auth.PermOffersRead, controllers.GetApprovalRules(db))).Methods("GET")
+	r.Handle("/approval-rules", can(auth.PermOffersApprove, controllers.CreateApprovalRule(db))).Methods("POST")
+	r.Handle("/approval-rules/{id}", can(auth.PermOffersApprove, controllers.UpdateApprovalRule(db))).Methods("PUT")
+	r.Handle("/approval-rules/{id}", can(auth.PermOffersApprove, controllers.DeleteApprovalRule(db))).Methods("DELETE")
+
+	// Offer approval Routes
+	r.Handle("/offers/{id}/approvals", can(auth.PermOffersRead, controllers.GetOfferApprovals(db))).Methods("GET")
+	r.Handle("/offers/{id}/approve", can(auth.PermOffersApprove, controllers.ApproveOffer(db))).Methods("POST")
+	r.Handle("/offers/{id}/reject", can(auth.PermOffersApprove, controllers.RejectOfferApproval(db))).Methods("POST")
+}
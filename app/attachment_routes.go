@@ -0,0 +1,24 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"Products/models"
+	"Products/storage"
+	"github.com/gorilla/mux"
+)
+
+func AttachmentRoutes(db *sql.DB, store storage.Store, r *mux.Router) {
+	// Material attachment Routes
+	r.Handle("/materials/{id}/attachments", can(auth.PermMaterialsRead, controllers.GetAttachments(db, models.EntityMaterial))).Methods("GET")
+	r.Handle("/materials/{id}/attachments", can(auth.PermMaterialsWrite, controllers.UploadAttachment(db, store, models.EntityMaterial))).Methods("POST")
+	r.Handle("/materials/{id}/attachments/{attachment_id}", can(auth.PermMaterialsRead, controllers.DownloadAttachment(db, store, models.EntityMaterial))).Methods("GET")
+	r.Handle("/materials/{id}/attachments/{attachment_id}", can(auth.PermMaterialsWrite, controllers.DeleteAttachment(db, store, models.EntityMaterial))).Methods("DELETE")
+
+	// Offer attachment Routes
+	r.Handle("/offers/{id}/attachments", can(auth.PermOffersRead, controllers.GetAttachments(db, models.EntityOffer))).Methods("GET")
+	r.Handle("/offers/{id}/attachments", can(auth.PermOffersWrite, controllers.UploadAttachment(db, store, models.EntityOffer))).Methods("POST")
+	r.Handle("/offers/{id}/attachments/{attachment_id}", can(auth.PermOffersRead, controllers.DownloadAttachment(db, store, models.EntityOffer))).Methods("GET")
+	r.Handle("/offers/{id}/attachments/{attachment_id}", can(auth.PermOffersWrite, controllers.DeleteAttachment(db, store, models.EntityOffer))).Methods("DELETE")
+}
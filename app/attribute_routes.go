@@ -0,0 +1,15 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func AttributeRoutes(db *sql.DB, r *mux.Router) {
+	// Attribute schema Routes
+	r.Handle("/attributes", can(auth.PermMaterialsRead, controllers.GetAttributeDefinitions(db))).Methods("GET")
+	r.Handle("/attributes", can(auth.PermMaterialsWrite, controllers.CreateAttributeDefinition(db))).Methods("POST")
+	r.Handle("/attributes/{id}", can(auth.PermMaterialsWrite, controllers.DeleteAttributeDefinition(db))).Methods("DELETE")
+}
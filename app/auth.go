@@ -0,0 +1,36 @@
+package app
+
+import (
+	"database/sql"
+	"Products/auth"
+	"Products/config"
+	"net/http"
+)
+
+// can guards a route with a permission.
+func can(permission string, h http.HandlerFunc) http.Handler {
+	return auth.RequirePermission(permission)(h)
+}
+
+// newAuthenticator accepts API keys and, when configured, JWTs signed with
+// the keys of a JWKS file or an HMAC secret.
+func newAuthenticator(db *sql.DB, cfg config.AuthConfig) (auth.Authenticator, error) {
+	authenticators := []auth.Authenticator{auth.NewAPIKeyAuthenticator(db)}
+
+	if cfg.JWKSFile != "" || cfg.JWTSecret != "" {
+		jwt := &auth.JWTAuthenticator{
+			Secret:   []byte(cfg.JWTSecret),
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+		}
+		if cfg.JWKSFile != "" {
+			keys, err := auth.LoadJWKS(cfg.JWKSFile)
+			if err != nil {
+				return nil, err
+			}
+			jwt.Keys = keys
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	return auth.Chain(authenticators...), nil
+}
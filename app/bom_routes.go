@@ -0,0 +1,17 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func BOMRoutes(db *sql.DB, r *mux.Router) {
+	// Bill of materials Routes
+	r.Handle("/materials/{id}/components", can(auth.PermMaterialsRead, controllers.GetMaterialComponents(db))).Methods("GET")
+	r.Handle("/materials/{id}/components", can(auth.PermMaterialsWrite, controllers.CreateMaterialComponent(db))).Methods("POST")
+	r.Handle("/material-components/{id}", can(auth.PermMaterialsWrite, controllers.UpdateMaterialComponent(db))).Methods("PUT")
+	r.Handle("/material-components/{id}", can(auth.PermMaterialsWrite, controllers.DeleteMaterialComponent(db))).Methods("DELETE")
+	r.Handle("/offers/{id}/explode", can(auth.PermOffersRead, controllers.ExplodeOffer(db))).Methods("GET")
+}
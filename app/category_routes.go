@@ -0,0 +1,17 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func CategoryRoutes(db *sql.DB, r *mux.Router) {
+	// Category Routes
+	r.Handle("/categories", can(auth.PermMaterialsRead, controllers.GetCategories(db))).Methods("GET")
+	r.Handle("/categories/{id}", can(auth.PermMaterialsRead, controllers.GetCategoryByID(db))).Methods("GET")
+	r.Handle("/categories", can(auth.PermMaterialsWrite, controllers.CreateCategory(db))).Methods("POST")
+	r.Handle("/categories/{id}", can(auth.PermMaterialsWrite, controllers.UpdateCategory(db))).Methods("PUT")
+	r.Handle("/categories/{id}", can(auth.PermMaterialsWrite, controllers.DeleteCategory(db))).Methods("DELETE")
+}
@@ -0,0 +1,24 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func CustomerRoutes(db *sql.DB, r *mux.Router) {
+	// Customer Routes
+	r.Handle("/customers", can(auth.PermCustomersRead, controllers.GetCustomers(db))).Methods("GET")
+	r.Handle("/customers/{id}", can(auth.PermCustomersRead, controllers.GetCustomerByID(db))).Methods("GET")
+	r.Handle("/customers/{id}/offers", can(auth.PermOffersRead, controllers.GetCustomerOffers(db))).Methods("GET")
+	r.Handle("/customers", can(auth.PermCustomersWrite, controllers.CreateCustomer(db))).Methods("POST")
+	r.Handle("/customers/{id}", can(auth.PermCustomersWrite, controllers.UpdateCustomer(db))).Methods("PUT")
+	r.Handle("/customers/{id}", can(auth.PermCustomersWrite, controllers.DeleteCustomer(db))).Methods("DELETE")
+
+	// Contact Routes
+	r.Handle("/customers/{id}/contacts", can(auth.PermCustomersRead, controllers.GetCustomerContacts(db))).Methods("GET")
+	r.Handle("/customers/{id}/contacts", can(auth.PermCustomersWrite, controllers.CreateContact(db))).Methods("POST")
+	r.Handle("/contacts/{id}", can(auth.PermCustomersWrite, controllers.UpdateContact(db))).Methods("PUT")
+	r.Handle("/contacts/{id}", can(auth.PermCustomersWrite, controllers.DeleteContact(db))).Methods("DELETE")
+}
@@ -0,0 +1,18 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"Products/storage"
+	"github.com/gorilla/mux"
+)
+
+func ImageRoutes(db *sql.DB, store storage.Store, r *mux.Router) {
+	// Material image Routes
+	r.Handle("/materials/{id}/images", can(auth.PermMaterialsRead, controllers.GetMaterialImages(db))).Methods("GET")
+	r.Handle("/materials/{id}/images", can(auth.PermMaterialsWrite, controllers.UploadMaterialImage(db, store))).Methods("POST")
+	r.Handle("/materials/{id}/images/{image_id}", can(auth.PermMaterialsRead, controllers.DownloadMaterialImage(db, store))).Methods("GET")
+	r.Handle("/materials/{id}/images/{image_id}/thumbnail", can(auth.PermMaterialsRead, controllers.DownloadMaterialThumbnail(db, store))).Methods("GET")
+	r.Handle("/materials/{id}/images/{image_id}", can(auth.PermMaterialsWrite, controllers.DeleteMaterialImage(db, store))).Methods("DELETE")
+}
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func InventoryRoutes(db *sql.DB, r *mux.Router) {
+	// Inventory Routes
+	r.Handle("/locations", can(auth.PermInventoryRead, controllers.GetStockLocations(db))).Methods("GET")
+	r.Handle("/locations", can(auth.PermInventoryWrite, controllers.CreateStockLocation(db))).Methods("POST")
+	r.Handle("/materials/{id}/stock", can(auth.PermInventoryRead, controllers.GetMaterialStock(db))).Methods("GET")
+	r.Handle("/materials/{id}/movements", can(auth.PermInventoryRead, controllers.GetMaterialMovements(db))).Methods("GET")
+	r.Handle("/inventory/movements", can(auth.PermInventoryWrite, controllers.CreateInventoryMovement(db))).Methods("POST")
+
+	// Offer status and reservations
+	r.Handle("/offers/{id}/status", can(auth.PermOffersWrite, controllers.UpdateOfferStatus(db))).Methods("POST")
+	r.Handle("/offers/{id}/fulfillment", can(auth.PermOffersRead, controllers.GetOfferFulfillment(db))).Methods("GET")
+}
@@ -3,14 +3,17 @@ package app
 import (
 	"database/sql"
 	"Products/Controllers"
+	"Products/auth"
 	"github.com/gorilla/mux"
 )
 
 func MaterialRoutes(db *sql.DB, r *mux.Router) {
 	// Material Routes
-	r.HandleFunc("/materials", controllers.GetMaterials(db)).Methods("GET")
-	r.HandleFunc("/materials/{id}", controllers.GetMaterialByID(db)).Methods("GET")
-	r.HandleFunc("/materials", controllers.CreateMaterial(db)).Methods("POST")
-	r.HandleFunc("/materials/{id}", controllers.UpdateMaterial(db)).Methods("PUT")
-	r.HandleFunc("/materials/{id}", controllers.DeleteMaterial(db)).Methods("DELETE")
+	r.Handle("/materials", can(auth.PermMaterialsRead, controllers.GetMaterials(db))).Methods("GET")
+	r.Handle("/materials/{id}", can(auth.PermMaterialsRead, controllers.GetMaterialByID(db))).Methods("GET")
+	r.Handle("/materials", can(auth.PermMaterialsWrite, controllers.CreateMaterial(db))).Methods("POST")
+	r.Handle("/materials/import", can(auth.PermMaterialsWrite, controllers.ImportMaterials(db))).Methods("POST")
+	r.Handle("/materials/{id}", can(auth.PermMaterialsWrite, controllers.UpdateMaterial(db))).Methods("PUT")
+	r.Handle("/materials/by-name/{name}", can(auth.PermMaterialsWrite, controllers.UpsertMaterial(db))).Methods("PUT")
+	r.Handle("/materials/{id}", can(auth.PermMaterialsWrite, controllers.DeleteMaterial(db))).Methods("DELETE")
 }
@@ -3,14 +3,16 @@ package app
 import (
 	"database/sql"
 	"Products/Controllers"
+	"Products/auth"
 	"github.com/gorilla/mux"
 )
 
 func OfferMaterialRoutes(db *sql.DB, r *mux.Router) {
 	// OfferMaterial Routes
-	r.HandleFunc("/offer-materials", controllers.GetOfferMaterials(db)).Methods("GET")
-	r.HandleFunc("/offer-materials/{id}", controllers.GetOfferMaterialByID(db)).Methods("GET")
-	r.HandleFunc("/offer-materials", controllers.CreateOfferMaterial(db)).Methods("POST")
-	r.HandleFunc("/offer-materials/{id}", controllers.UpdateOfferMaterial(db)).Methods("PUT")
-	r.HandleFunc("/offer-materials/{id}", controllers.DeleteOfferMaterial(db)).Methods("DELETE")
+	r.Handle("/offer-materials", can(auth.PermOffersRead, controllers.GetOfferMaterials(db))).Methods("GET")
+	r.Handle("/offer-materials/{id}", can(auth.PermOffersRead, controllers.GetOfferMaterialByID(db))).Methods("GET")
+	r.Handle("/offer-materials", can(auth.PermOffersWrite, controllers.CreateOfferMaterial(db))).Methods("POST")
+	r.Handle("/offer-materials/{id}", can(auth.PermOffersWrite, controllers.UpdateOfferMaterial(db))).Methods("PUT")
+	r.Handle("/offer-materials/{id}", can(auth.PermOffersWrite, controllers.DeleteOfferMaterial(db))).Methods("DELETE")
+	r.Handle("/offers/{id}/materials/{material_id}", can(auth.PermOffersWrite, controllers.UpsertOfferMaterial(db))).Methods("PUT")
 }
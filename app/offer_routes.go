@@ -3,14 +3,25 @@ package app
 import (
 	"database/sql"
 	"Products/Controllers"
+	"Products/auth"
 	"github.com/gorilla/mux"
 )
 
 func OfferRoutes(db *sql.DB, r *mux.Router) {
 	// Offer Routes
-	r.HandleFunc("/offers", controllers.GetOffers(db)).Methods("GET")
-	r.HandleFunc("/offers/{id}", controllers.GetOfferByID(db)).Methods("GET")
-	r.HandleFunc("/offers", controllers.CreateOffer(db)).Methods("POST")
-	r.HandleFunc("/offers/{id}", controllers.UpdateOffer(db)).Methods("PUT")
-	r.HandleFunc("/offers/{id}", controllers.DeleteOffer(db)).Methods("DELETE")
+	r.Handle("/offers", can(auth.PermOffersRead, controllers.GetOffers(db))).Methods("GET")
+	r.Handle("/offers/{id}", can(auth.PermOffersRead, controllers.GetOfferByID(db))).Methods("GET")
+	r.Handle("/offers/{id}/export", can(auth.PermOffersRead, controllers.ExportOffer(db))).Methods("GET")
+	r.Handle("/offers/{id}/document", can(auth.PermOffersRead, controllers.GetOfferDocument(db))).Methods("GET")
+	r.Handle("/offers", can(auth.PermOffersWrite, controllers.CreateOffer(db))).Methods("POST")
+	r.Handle("/offers/{id}", can(auth.PermOffersWrite, controllers.UpdateOffer(db))).Methods("PUT")
+	r.Handle("/offers/{id}", can(auth.PermOffersWrite, controllers.DeleteOffer(db))).Methods("DELETE")
+	r.Handle("/offers/{id}/owner", can(auth.PermOffersWrite, controllers.AssignOffer(db))).Methods("PUT")
+	r.Handle("/offers/{id}/owner", can(auth.PermOffersWrite, controllers.UnassignOffer(db))).Methods("DELETE")
+
+	// Offer comment Routes
+	r.Handle("/offers/{id}/comments", can(auth.PermOffersRead, controllers.GetOfferComments(db))).Methods("GET")
+	r.Handle("/offers/{id}/comments", can(auth.PermOffersComment, controllers.CreateOfferComment(db))).Methods("POST")
+	r.Handle("/offers/{id}/comments/{comment_id}", can(auth.PermOffersComment, controllers.UpdateOfferComment(db))).Methods("PUT")
+	r.Handle("/offers/{id}/comments/{comment_id}", can(auth.PermOffersComment, controllers.DeleteOfferComment(db))).Methods("DELETE")
 }
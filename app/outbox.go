@@ -0,0 +1,22 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/config"
+	"Products/outbox"
+)
+
+// newOutboxPublisher builds the publishers named in OUTBOX_PUBLISHERS.
+func newOutboxPublisher(db *sql.DB) outbox.Publishers {
+	publishers := outbox.Publishers{}
+	for _, name := range config.OutboxPublishers() {
+		switch name {
+		case "log":
+			publishers = append(publishers, outbox.LogPublisher{})
+		case "webhook":
+			publishers = append(publishers, controllers.WebhookPublisher(db))
+		}
+	}
+	return publishers
+}
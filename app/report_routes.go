@@ -0,0 +1,14 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func ReportRoutes(db *sql.DB, r *mux.Router) {
+	// Report Routes
+	r.Handle("/reports/inactive-materials", can(auth.PermReportsRead, controllers.GetInactiveMaterialReport(db))).Methods("GET")
+	r.Handle("/reports/missing-translations", can(auth.PermReportsRead, controllers.GetMissingTranslationReport(db))).Methods("GET")
+}
@@ -0,0 +1,14 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"Products/search"
+	"github.com/gorilla/mux"
+)
+
+func SearchRoutes(db *sql.DB, r *mux.Router) {
+	// Search Routes
+	r.Handle("/search", can(auth.PermMaterialsRead, controllers.Search(search.NewPostgresIndex(db)))).Methods("GET")
+}
@@ -0,0 +1,18 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func SubstituteRoutes(db *sql.DB, r *mux.Router) {
+	// Material substitute Routes
+	r.Handle("/materials/{id}/substitutes", can(auth.PermMaterialsRead, controllers.GetMaterialSubstitutes(db))).Methods("GET")
+	r.Handle("/materials/{id}/substitutes", can(auth.PermMaterialsWrite, controllers.CreateMaterialSubstitute(db))).Methods("POST")
+	r.Handle("/material-substitutes/{id}", can(auth.PermMaterialsWrite, controllers.UpdateMaterialSubstitute(db))).Methods("PUT")
+	r.Handle("/material-substitutes/{id}", can(auth.PermMaterialsWrite, controllers.DeleteMaterialSubstitute(db))).Methods("DELETE")
+	r.Handle("/offers/{id}/replacements", can(auth.PermOffersRead, controllers.GetOfferReplacements(db))).Methods("GET")
+	r.Handle("/offers/{id}/replacements", can(auth.PermOffersWrite, controllers.ApplyOfferReplacements(db))).Methods("POST")
+}
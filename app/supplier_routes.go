@@ -0,0 +1,23 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func SupplierRoutes(db *sql.DB, r *mux.Router) {
+	// Supplier Routes
+	r.Handle("/suppliers", can(auth.PermMaterialsRead, controllers.GetSuppliers(db))).Methods("GET")
+	r.Handle("/suppliers/{id}", can(auth.PermMaterialsRead, controllers.GetSupplierByID(db))).Methods("GET")
+	r.Handle("/suppliers", can(auth.PermMaterialsWrite, controllers.CreateSupplier(db))).Methods("POST")
+	r.Handle("/suppliers/{id}", can(auth.PermMaterialsWrite, controllers.UpdateSupplier(db))).Methods("PUT")
+	r.Handle("/suppliers/{id}", can(auth.PermMaterialsWrite, controllers.DeleteSupplier(db))).Methods("DELETE")
+
+	// Material sourcing Routes
+	r.Handle("/materials/{id}/suppliers", can(auth.PermMaterialsRead, controllers.GetMaterialSuppliers(db))).Methods("GET")
+	r.Handle("/materials/{id}/suppliers", can(auth.PermMaterialsWrite, controllers.CreateMaterialSupplier(db))).Methods("POST")
+	r.Handle("/material-suppliers/{id}", can(auth.PermMaterialsWrite, controllers.UpdateMaterialSupplier(db))).Methods("PUT")
+	r.Handle("/material-suppliers/{id}", can(auth.PermMaterialsWrite, controllers.DeleteMaterialSupplier(db))).Methods("DELETE")
+}
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"database/sql"
+	"Products/Controllers"
+	"Products/auth"
+	"Products/models"
+	"github.com/gorilla/mux"
+)
+
+func TranslationRoutes(db *sql.DB, r *mux.Router) {
+	// Material translation Routes
+	r.Handle("/materials/{id}/translations", can(auth.PermMaterialsRead, controllers.GetTranslations(db, models.EntityMaterial))).Methods("GET")
+	r.Handle("/materials/{id}/translations/{locale}", can(auth.PermMaterialsWrite, controllers.PutTranslation(db, models.EntityMaterial))).Methods("PUT")
+	r.Handle("/materials/{id}/translations/{locale}", can(auth.PermMaterialsWrite, controllers.DeleteTranslation(db, models.EntityMaterial))).Methods("DELETE")
+
+	// Offer translation Routes
+	r.Handle("/offers/{id}/translations", can(auth.PermOffersRead, controllers.GetTranslations(db, models.EntityOffer))).Methods("GET")
+	r.Handle("/offers/{id}/translations/{locale}", can(auth.PermOffersWrite, controllers.PutTranslation(db, models.EntityOffer))).Methods("PUT")
+	r.Handle("/offers/{id}/translations/{locale}", can(auth.PermOffersWrite, controllers.DeleteTranslation(db, models.EntityOffer))).Methods("DELETE")
+}
@@ -0,0 +1,24 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+	"Products/Controllers"
+	"Products/auth"
+	"github.com/gorilla/mux"
+)
+
+func WebhookRoutes(db *sql.DB, client *http.Client, r *mux.Router) {
+	// Webhook Routes
+	r.Handle("/webhooks", can(auth.PermWebhooksManage, controllers.GetWebhookSubscriptions(db))).Methods("GET")
+	r.Handle("/webhooks", can(auth.PermWebhooksManage, controllers.CreateWebhookSubscription(db))).Methods("POST")
+	r.Handle("/webhooks/{id}", can(auth.PermWebhooksManage, controllers.GetWebhookSubscription(db))).Methods("GET")
+	r.Handle("/webhooks/{id}", can(auth.PermWebhooksManage, controllers.UpdateWebhookSubscription(db))).Methods("PUT")
+	r.Handle("/webhooks/{id}", can(auth.PermWebhooksManage, controllers.DeleteWebhookSubscription(db))).Methods("DELETE")
+	r.Handle("/webhooks/{id}/test", can(auth.PermWebhooksManage, controllers.SendTestWebhook(db, client))).Methods("POST")
+
+	// Webhook delivery Routes
+	r.Handle("/webhook-deliveries", can(auth.PermWebhooksManage, controllers.GetWebhookDeliveries(db))).Methods("GET")
+	r.Handle("/webhook-deliveries/{id}", can(auth.PermWebhooksManage, controllers.GetWebhookDelivery(db))).Methods("GET")
+	r.Handle("/webhook-deliveries/{id}/retry", can(auth.PermWebhooksManage, controllers.RetryWebhookDelivery(db))).Methods("POST")
+}
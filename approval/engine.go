@@ -0,0 +1,127 @@
+// Package approval decides whether an offer needs approval before it is
+// sent, by evaluating the tenant's approval rules against the offer's
+// lines.
+package approval
+
+import (
+	"Products/models"
+	"fmt"
+	"math"
+)
+
+// Operators a rule can compare with.
+var operators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+}
+
+var metrics = map[string]bool{
+	models.MetricTotal:               true,
+	models.MetricDiscountPercent:     true,
+	models.MetricLineDiscountPercent: true,
+}
+
+// Line is an offer line as seen by the rules. ListPrice is the price the
+// line would have without a discount; 0 means unknown and the line is left
+// out of discount metrics.
+type Line struct {
+	OfferMaterialID int
+	Quantity        float64
+	UnitPrice       float64
+	ListPrice       float64
+}
+
+// DiscountPercent is how far the unit price is below the list price.
+func (l Line) DiscountPercent() float64 {
+	if l.ListPrice <= 0 {
+		return 0
+	}
+	return round((l.ListPrice - l.UnitPrice) / l.ListPrice * 100)
+}
+
+// Offer holds what the rules look at.
+type Offer struct {
+	Lines []Line
+}
+
+// Total is the sum of quantity times unit price.
+func (o Offer) Total() float64 {
+	var total float64
+	for _, line := range o.Lines {
+		total += line.Quantity * line.UnitPrice
+	}
+	return round(total)
+}
+
+// DiscountPercent is the discount of the lines with a list price, weighted
+// by quantity.
+func (o Offer) DiscountPercent() float64 {
+	var list, actual float64
+	for _, line := range o.Lines {
+		if line.ListPrice <= 0 {
+			continue
+		}
+		list += line.Quantity * line.ListPrice
+		actual += line.Quantity * line.UnitPrice
+	}
+	if list == 0 {
+		return 0
+	}
+	return round((list - actual) / list * 100)
+}
+
+// Validate reports whether a rule can be evaluated.
+func Validate(rule models.ApprovalRule) error {
+	if !metrics[rule.Metric] {
+		return fmt.Errorf("invalid metric %q", rule.Metric)
+	}
+	if operators[rule.Operator] == nil {
+		return fmt.Errorf("invalid operator %q", rule.Operator)
+	}
+	return nil
+}
+
+// Evaluate returns a match for every active rule the offer meets. Line
+// metrics match once per matching line. Invalid rules never match.
+func Evaluate(rules []models.ApprovalRule, offer Offer) []models.ApprovalMatch {
+	matches := []models.ApprovalMatch{}
+	for _, rule := range rules {
+		compare := operators[rule.Operator]
+		if !rule.Active || compare == nil {
+			continue
+		}
+		match := models.ApprovalMatch{RuleID: rule.ID, RuleName: rule.Name, Metric: rule.Metric, Operator: rule.Operator, Threshold: rule.Threshold}
+
+		switch rule.Metric {
+		case models.MetricTotal:
+			match.Value = offer.Total()
+		case models.MetricDiscountPercent:
+			match.Value = offer.DiscountPercent()
+		case models.MetricLineDiscountPercent:
+			for _, line := range offer.Lines {
+				if line.ListPrice <= 0 {
+					continue
+				}
+				if value := line.DiscountPercent(); compare(value, rule.Threshold) {
+					lineMatch := match
+					lineMatch.Value = value
+					lineMatch.OfferMaterialID = &line.OfferMaterialID
+					matches = append(matches, lineMatch)
+				}
+			}
+			continue
+		default:
+			continue
+		}
+		if compare(match.Value, rule.Threshold) {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
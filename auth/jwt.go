@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// clockSkew is tolerated when checking exp and nbf.
+const clockSkew = 30 * time.Second
+
+// JWTAuthenticator verifies "Authorization: Bearer <jwt>" tokens. HS*
+// tokens are checked against Secret and RS*/ES* tokens against the keys of
+// a JWKS; a token is never checked with a key of the other kind.
+type JWTAuthenticator struct {
+	Secret   []byte
+	Keys     map[string]crypto.PublicKey
+	Issuer   string
+	Audience string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// audience accepts both forms of the aud claim.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Name      string   `json:"name"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Roles     []string `json:"roles"`
+	Tenant    string   `json:"tenant"`
+}
+
+func invalidToken(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, fmt.Sprintf(format, args...))
+}
+
+func (j *JWTAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Principal{}, ErrNoCredentials
+	}
+	claims, err := j.verify(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if err != nil {
+		return Principal{}, err
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.Subject
+	}
+	return Principal{ID: claims.Subject, Name: name, Method: MethodJWT, Roles: claims.Roles, Tenant: claims.Tenant}, nil
+}
+
+// verify checks the signature and the registered claims of token.
+func (j *JWTAuthenticator) verify(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, invalidToken("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, invalidToken("malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, invalidToken("malformed signature")
+	}
+	if err := j.verifySignature(header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, invalidToken("malformed claims")
+	}
+	now := time.Now()
+	if claims.ExpiresAt != nil && now.Add(-clockSkew).After(time.Unix(*claims.ExpiresAt, 0)) {
+		return claims, invalidToken("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return claims, invalidToken("token not yet valid")
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return claims, invalidToken("unexpected issuer")
+	}
+	if j.Audience != "" && !containsString(claims.Audience, j.Audience) {
+		return claims, invalidToken("unexpected audience")
+	}
+	if claims.Subject == "" {
+		return claims, invalidToken("missing subject")
+	}
+	return claims, nil
+}
+
+// jwtAlgorithms maps the supported alg values to their key family and
+// hash.
+var jwtAlgorithms = map[string]struct {
+	family string
+	hash   crypto.Hash
+}{
+	"HS256": {"HS", crypto.SHA256}, "HS384": {"HS", crypto.SHA384}, "HS512": {"HS", crypto.SHA512},
+	"RS256": {"RS", crypto.SHA256}, "RS384": {"RS", crypto.SHA384}, "RS512": {"RS", crypto.SHA512},
+	"ES256": {"ES", crypto.SHA256}, "ES384": {"ES", crypto.SHA384}, "ES512": {"ES", crypto.SHA512},
+}
+
+func (j *JWTAuthenticator) verifySignature(header jwtHeader, input, signature []byte) error {
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return invalidToken("unsupported algorithm %q", header.Alg)
+	}
+
+	if alg.family == "HS" {
+		if len(j.Secret) == 0 {
+			return invalidToken("HMAC tokens are not accepted")
+		}
+		mac := hmac.New(alg.hash.New, j.Secret)
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalidToken("bad signature")
+		}
+		return nil
+	}
+
+	key, err := j.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	h := alg.hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg.family == "RS" && rsa.VerifyPKCS1v15(key, alg.hash, digest, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg.family == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return invalidToken("bad signature")
+}
+
+// key finds the JWKS key for kid. Tokens without a kid are accepted when
+// the set holds a single key.
+func (j *JWTAuthenticator) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := j.Keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(j.Keys) == 1 {
+		for _, key := range j.Keys {
+			return key, nil
+		}
+	}
+	return nil, invalidToken("unknown key %q", kid)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads the RSA and EC public keys of a JWKS file, keyed by kid.
+// Keys marked for a use other than signatures are skipped.
+func LoadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys in JWKS")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
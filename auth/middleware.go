@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials means the request carries no credentials an
+	// Authenticator understands, so the next one should be tried.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means credentials were present but rejected.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator identifies the caller of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator.
+type AuthenticatorFunc func(r *http.Request) (Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Principal, error) {
+	return f(r)
+}
+
+// Chain tries each authenticator in turn until one finds credentials.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(r)
+			if err != ErrNoCredentials {
+				return p, err
+			}
+		}
+		return Principal{}, ErrNoCredentials
+	})
+}
+
+// Middleware rejects requests that a does not authenticate with 401 and
+// passes the principal on in the request context.
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.Authenticate(r)
+			if err != nil {
+				if err != ErrNoCredentials && !errors.Is(err, ErrInvalidCredentials) {
+					log.Printf("Error authenticating request: %v", err)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer, ApiKey`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
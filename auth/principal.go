@@ -0,0 +1,44 @@
+// Package auth authenticates requests with API keys or JWT bearer tokens
+// and puts the resulting Principal into the request context.
+package auth
+
+import "context"
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller.
+type Principal struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Method string   `json:"method"`
+	Roles  []string `json:"roles"`
+	// Tenant binds the principal to one tenant. Only platform admins may
+	// leave it empty and act for any tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// HasRole reports whether the principal was granted role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,79 @@
+package auth
+
+import "net/http"
+
+const (
+	RoleAdmin        = "admin"
+	RoleViewer       = "viewer"
+	RoleSales        = "sales"
+	RoleManager      = "manager"
+	RoleCatalogAdmin = "catalog-admin"
+	// RolePlatformAdmin is an admin of the platform rather than of one
+	// tenant. Only principals with it may be unbound and pick a tenant
+	// with the X-Tenant-ID header.
+	RolePlatformAdmin = "platform-admin"
+)
+
+// Permissions checked by the routes. Reads and writes are split per area so
+// a role can see everything but only change its own part.
+const (
+	PermMaterialsRead  = "materials:read"
+	PermMaterialsWrite = "materials:write"
+	PermOffersRead     = "offers:read"
+	PermOffersWrite    = "offers:write"
+	PermOffersApprove  = "offers:approve"
+	PermOffersAssign   = "offers:assign"
+	PermOffersComment  = "offers:comment"
+	PermInventoryRead  = "inventory:read"
+	PermInventoryWrite = "inventory:write"
+	PermCustomersRead  = "customers:read"
+	PermCustomersWrite = "customers:write"
+	PermReportsRead    = "reports:read"
+	PermAPIKeysManage  = "api-keys:manage"
+	PermWebhooksManage = "webhooks:manage"
+)
+
+var readPermissions = []string{PermMaterialsRead, PermOffersRead, PermCustomersRead, PermInventoryRead, PermReportsRead}
+
+// rolePermissions grants permissions to roles. Catalog admins own materials
+// and everything describing them; sales users own offers, their lines and
+// customers; managers also approve offers. Everyone but viewers may
+// comment on offers. Platform admins are admins of whichever tenant they
+// act for. Unknown roles grant nothing.
+var rolePermissions = map[string][]string{
+	RoleViewer:       readPermissions,
+	RoleSales:        append([]string{PermOffersWrite, PermOffersComment, PermCustomersWrite}, readPermissions...),
+	RoleManager:      append([]string{PermOffersWrite, PermOffersApprove, PermOffersComment, PermCustomersWrite}, readPermissions...),
+	RoleCatalogAdmin: append([]string{PermMaterialsWrite, PermInventoryWrite, PermOffersComment}, readPermissions...),
+	RoleAdmin: append([]string{PermMaterialsWrite, PermOffersWrite, PermOffersApprove, PermOffersAssign, PermOffersComment, PermCustomersWrite, PermInventoryWrite, PermAPIKeysManage, PermWebhooksManage},
+		readPermissions...),
+	RolePlatformAdmin: append([]string{PermMaterialsWrite, PermOffersWrite, PermOffersApprove, PermOffersAssign, PermOffersComment, PermCustomersWrite, PermInventoryWrite, PermAPIKeysManage, PermWebhooksManage},
+		readPermissions...),
+}
+
+// Can reports whether any of the principal's roles grants permission.
+func (p Principal) Can(permission string) bool {
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequirePermission answers 403, naming the missing permission, to
+// principals whose roles do not grant it.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := FromContext(r.Context())
+			if !ok || !p.Can(permission) {
+				http.Error(w, "forbidden: missing permission "+permission, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
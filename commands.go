@@ -0,0 +1,121 @@
+package main
+
+import (
+	"Products/Controllers"
+	"Products/config"
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runCommand executes a CLI subcommand and returns its exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "import-materials":
+		return importMaterials(args[1:])
+	case "expire-offers":
+		return expireOffers()
+	case "create-api-key":
+		return createAPIKey(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+// importMaterials runs: import-materials [-dry-run] [-tenant id] file.csv
+func importMaterials(args []string) int {
+	fs := flag.NewFlagSet("import-materials", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report changes without writing them")
+	tenantID := fs.String("tenant", config.Tenant(), "tenant the materials belong to")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import-materials [-dry-run] [-tenant id] file.csv")
+		return 2
+	}
+	if !tenant.Valid(*tenantID) {
+		fmt.Fprintf(os.Stderr, "invalid tenant %q\n", *tenantID)
+		return 2
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	config.ConnectDB()
+	defer config.CloseDB()
+
+	ctx := tenant.WithID(context.Background(), *tenantID)
+	report, err := controllers.ImportMaterialsCSV(ctx, config.DB, file, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if report.Invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+// expireOffers runs: expire-offers. It is meant to be run periodically,
+// e.g. from cron.
+func expireOffers() int {
+	config.ConnectDB()
+	defer config.CloseDB()
+
+	expired, err := controllers.ExpireOffers(config.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "expire failed:", err)
+		return 1
+	}
+	fmt.Printf("%d offers expired\n", expired)
+	return 0
+}
+
+// createAPIKey runs: create-api-key -name X [-roles admin,...] -tenant id.
+// Only platform-admin keys are created without -tenant. It prints the key
+// once; only its hash is stored.
+func createAPIKey(args []string) int {
+	fs := flag.NewFlagSet("create-api-key", flag.ExitOnError)
+	name := fs.String("name", "", "name of the key")
+	roles := fs.String("roles", "", "comma-separated roles")
+	tenantID := fs.String("tenant", "", "tenant the key is bound to; empty only for platform-admin keys")
+	fs.Parse(args)
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "usage: create-api-key -name X [-roles admin,...] -tenant id")
+		return 2
+	}
+
+	apiKey := models.APIKey{Name: *name, Roles: []string{}}
+	if *tenantID != "" {
+		apiKey.Tenant = tenantID
+	}
+	for _, role := range strings.Split(*roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			apiKey.Roles = append(apiKey.Roles, role)
+		}
+	}
+
+	config.ConnectDB()
+	defer config.CloseDB()
+
+	apiKey, err := controllers.NewAPIKey(config.DB, apiKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create failed:", err)
+		return 1
+	}
+	fmt.Println(apiKey.Key)
+	return 0
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// AuthConfig selects how requests are authenticated. API keys are always
+// accepted; JWTs are accepted when a JWKS file or HMAC secret is set.
+type AuthConfig struct {
+	Disabled    bool
+	JWKSFile    string
+	JWTSecret   string
+	JWTIssuer   string
+	JWTAudience string
+}
+
+// Auth reads AUTH_DISABLED, AUTH_JWKS_FILE, AUTH_JWT_SECRET,
+// AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE.
+func Auth() AuthConfig {
+	disabled, _ := strconv.ParseBool(os.Getenv("AUTH_DISABLED"))
+	return AuthConfig{
+		Disabled:    disabled,
+		JWKSFile:    os.Getenv("AUTH_JWKS_FILE"),
+		JWTSecret:   os.Getenv("AUTH_JWT_SECRET"),
+		JWTIssuer:   os.Getenv("AUTH_JWT_ISSUER"),
+		JWTAudience: os.Getenv("AUTH_JWT_AUDIENCE"),
+	}
+}
@@ -2,8 +2,9 @@
 package config
 
 import (
+	"Products/tenant"
 	"database/sql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"log"
 	"os"
 )
@@ -12,9 +13,18 @@ var DB *sql.DB
 
 func ConnectDB() {
 	var err error
-	DB, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Fatal(err)
+	if TenantRLS() {
+		// The policies need app.tenant_id set on every statement.
+		connector, err := pq.NewConnector(os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		DB = sql.OpenDB(tenant.NewRLSConnector(connector))
+	} else {
+		DB, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	err = DB.Ping()
@@ -61,8 +71,495 @@ func createTables() {
             updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
             deleted_at TIMESTAMP
         );
+
+        CREATE TABLE IF NOT EXISTS category (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR NOT NULL,
+            parent_id INT REFERENCES category(id),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+
+        ALTER TABLE offer_material ADD COLUMN IF NOT EXISTS quantity NUMERIC(12, 3) NOT NULL DEFAULT 1;
+        ALTER TABLE offer_material ADD COLUMN IF NOT EXISTS unit_price NUMERIC(12, 2) NOT NULL DEFAULT 0;
+
+        ALTER TABLE material ADD COLUMN IF NOT EXISTS category_id INT REFERENCES category(id);
+        CREATE INDEX IF NOT EXISTS material_category_idx ON material (category_id);
+        CREATE INDEX IF NOT EXISTS category_parent_idx ON category (parent_id);
+
+        CREATE TABLE IF NOT EXISTS entity_tag (
+            entity VARCHAR NOT NULL,
+            entity_id INT NOT NULL,
+            tag VARCHAR NOT NULL,
+            PRIMARY KEY (entity, entity_id, tag)
+        );
+        CREATE INDEX IF NOT EXISTS entity_tag_tag_idx ON entity_tag (entity, tag);
+
+        CREATE TABLE IF NOT EXISTS attribute_definition (
+            id SERIAL PRIMARY KEY,
+            entity VARCHAR NOT NULL,
+            name VARCHAR NOT NULL,
+            type VARCHAR NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS attribute_value (
+            entity VARCHAR NOT NULL,
+            entity_id INT NOT NULL,
+            attribute_id INT NOT NULL REFERENCES attribute_definition(id),
+            string_value TEXT,
+            number_value NUMERIC,
+            bool_value BOOLEAN,
+            date_value DATE,
+            PRIMARY KEY (entity, entity_id, attribute_id)
+        );
+
+        CREATE TABLE IF NOT EXISTS supplier (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR NOT NULL,
+            email VARCHAR NOT NULL DEFAULT '',
+            phone VARCHAR NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS material_supplier (
+            id SERIAL PRIMARY KEY,
+            material_id INT NOT NULL REFERENCES material(id),
+            supplier_id INT NOT NULL REFERENCES supplier(id),
+            supplier_sku VARCHAR NOT NULL DEFAULT '',
+            purchase_price NUMERIC(12, 2) NOT NULL DEFAULT 0,
+            lead_time_days INT NOT NULL DEFAULT 0,
+            preferred BOOLEAN NOT NULL DEFAULT false,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS material_supplier_preferred_idx ON material_supplier (material_id) WHERE preferred AND deleted_at IS NULL;
+
+        ALTER TABLE offer ADD COLUMN IF NOT EXISTS status VARCHAR NOT NULL DEFAULT 'draft';
+        ALTER TABLE offer ADD COLUMN IF NOT EXISTS valid_until TIMESTAMP;
+        CREATE INDEX IF NOT EXISTS offer_status_idx ON offer (status) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS stock_location (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS stock_level (
+            material_id INT NOT NULL REFERENCES material(id),
+            location_id INT NOT NULL REFERENCES stock_location(id),
+            on_hand NUMERIC(12, 3) NOT NULL DEFAULT 0 CHECK (on_hand >= 0),
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (material_id, location_id)
+        );
+
+        CREATE TABLE IF NOT EXISTS inventory_movement (
+            id SERIAL PRIMARY KEY,
+            material_id INT NOT NULL REFERENCES material(id),
+            location_id INT NOT NULL REFERENCES stock_location(id),
+            type VARCHAR NOT NULL,
+            quantity NUMERIC(12, 3) NOT NULL,
+            note TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS inventory_movement_material_idx ON inventory_movement (material_id, created_at);
+
+        CREATE TABLE IF NOT EXISTS stock_reservation (
+            id SERIAL PRIMARY KEY,
+            offer_id INT NOT NULL REFERENCES offer(id),
+            offer_material_id INT NOT NULL REFERENCES offer_material(id),
+            material_id INT NOT NULL REFERENCES material(id),
+            quantity NUMERIC(12, 3) NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            released_at TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS stock_reservation_open_idx ON stock_reservation (material_id) WHERE released_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS material_component (
+            id SERIAL PRIMARY KEY,
+            assembly_id INT NOT NULL REFERENCES material(id),
+            component_id INT NOT NULL REFERENCES material(id),
+            quantity NUMERIC(12, 3) NOT NULL CHECK (quantity > 0),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP,
+            CHECK (assembly_id <> component_id)
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS material_component_pair_idx ON material_component (assembly_id, component_id) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS material_component_component_idx ON material_component (component_id) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS material_substitute (
+            id SERIAL PRIMARY KEY,
+            material_id INT NOT NULL REFERENCES material(id),
+            substitute_id INT NOT NULL REFERENCES material(id),
+            priority INT NOT NULL DEFAULT 0,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP,
+            CHECK (material_id <> substitute_id)
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS material_substitute_pair_idx ON material_substitute (material_id, substitute_id) WHERE deleted_at IS NULL;
+
+        ALTER TABLE offer ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE material ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE offer_material ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
     `)
 	if err != nil {
 		log.Fatal("Error creating tables:", err)
 	}
+
+	if err := dedupeUniqueKeys(DB); err != nil {
+		log.Fatal("Error removing duplicates:", err)
+	}
+
+	_, err = DB.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS material_tenant_name_idx ON material (tenant_id, LOWER(name)) WHERE deleted_at IS NULL;
+        DROP INDEX IF EXISTS material_name_idx;
+        CREATE UNIQUE INDEX IF NOT EXISTS offer_material_pair_idx ON offer_material (offer_id, material_id) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS idempotency_key (
+            key VARCHAR PRIMARY KEY,
+            request_hash VARCHAR NOT NULL,
+            status_code INT,
+            content_type VARCHAR NOT NULL DEFAULT '',
+            body BYTEA,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            expires_at TIMESTAMP NOT NULL
+        );
+        CREATE INDEX IF NOT EXISTS idempotency_key_expires_idx ON idempotency_key (expires_at);
+
+        CREATE TABLE IF NOT EXISTS api_key (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR NOT NULL,
+            key_hash VARCHAR(64) NOT NULL UNIQUE,
+            key_prefix VARCHAR NOT NULL,
+            roles TEXT[] NOT NULL DEFAULT '{}',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            expires_at TIMESTAMP,
+            revoked_at TIMESTAMP
+        );
+
+        CREATE INDEX IF NOT EXISTS offer_tenant_idx ON offer (tenant_id) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS material_tenant_idx ON material (tenant_id) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS offer_material_tenant_idx ON offer_material (tenant_id) WHERE deleted_at IS NULL;
+        CREATE UNIQUE INDEX IF NOT EXISTS offer_id_tenant_idx ON offer (id, tenant_id);
+        CREATE UNIQUE INDEX IF NOT EXISTS material_id_tenant_idx ON material (id, tenant_id);
+        DO $$
+        BEGIN
+            -- An offer line must belong to the tenant of both its offer and
+            -- its material.
+            IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'offer_material_offer_tenant_fk') THEN
+                ALTER TABLE offer_material ADD CONSTRAINT offer_material_offer_tenant_fk
+                    FOREIGN KEY (offer_id, tenant_id) REFERENCES offer (id, tenant_id);
+            END IF;
+            IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'offer_material_material_tenant_fk') THEN
+                ALTER TABLE offer_material ADD CONSTRAINT offer_material_material_tenant_fk
+                    FOREIGN KEY (material_id, tenant_id) REFERENCES material (id, tenant_id);
+            END IF;
+        END $$;
+        ALTER TABLE api_key ADD COLUMN IF NOT EXISTS tenant_id VARCHAR;
+
+        ALTER TABLE offer ADD COLUMN IF NOT EXISTS owner_id VARCHAR;
+        CREATE INDEX IF NOT EXISTS offer_owner_idx ON offer (tenant_id, owner_id) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS customer (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR NOT NULL,
+            email VARCHAR NOT NULL DEFAULT '',
+            phone VARCHAR NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS customer_id_tenant_idx ON customer (id, tenant_id);
+        CREATE INDEX IF NOT EXISTS customer_tenant_idx ON customer (tenant_id) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS contact (
+            id SERIAL PRIMARY KEY,
+            customer_id INT NOT NULL,
+            name VARCHAR NOT NULL,
+            email VARCHAR NOT NULL DEFAULT '',
+            phone VARCHAR NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP,
+            FOREIGN KEY (customer_id, tenant_id) REFERENCES customer (id, tenant_id)
+        );
+        CREATE INDEX IF NOT EXISTS contact_customer_idx ON contact (customer_id) WHERE deleted_at IS NULL;
+
+        ALTER TABLE offer ADD COLUMN IF NOT EXISTS customer_id INT;
+        CREATE INDEX IF NOT EXISTS offer_customer_idx ON offer (customer_id) WHERE deleted_at IS NULL;
+        DO $$
+        BEGIN
+            IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'offer_customer_tenant_fk') THEN
+                ALTER TABLE offer ADD CONSTRAINT offer_customer_tenant_fk
+                    FOREIGN KEY (customer_id, tenant_id) REFERENCES customer (id, tenant_id);
+            END IF;
+        END $$;
+
+        CREATE TABLE IF NOT EXISTS approval_rule (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR NOT NULL,
+            metric VARCHAR NOT NULL,
+            operator VARCHAR NOT NULL,
+            threshold NUMERIC(12, 2) NOT NULL,
+            active BOOLEAN NOT NULL DEFAULT true,
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS offer_approval (
+            id SERIAL PRIMARY KEY,
+            offer_id INT NOT NULL,
+            status VARCHAR NOT NULL DEFAULT 'pending',
+            matches JSONB NOT NULL DEFAULT '[]',
+            requested_by VARCHAR NOT NULL,
+            decided_by VARCHAR,
+            comment TEXT NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            decided_at TIMESTAMP,
+            FOREIGN KEY (offer_id, tenant_id) REFERENCES offer (id, tenant_id)
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS offer_approval_pending_idx ON offer_approval (offer_id) WHERE status = 'pending';
+
+        CREATE TABLE IF NOT EXISTS offer_comment (
+            id SERIAL PRIMARY KEY,
+            offer_id INT NOT NULL,
+            offer_material_id INT REFERENCES offer_material(id),
+            parent_id INT REFERENCES offer_comment(id),
+            author_id VARCHAR NOT NULL,
+            author_name VARCHAR NOT NULL DEFAULT '',
+            body TEXT NOT NULL,
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP,
+            FOREIGN KEY (offer_id, tenant_id) REFERENCES offer (id, tenant_id)
+        );
+        CREATE INDEX IF NOT EXISTS offer_comment_offer_idx ON offer_comment (offer_id);
+
+        CREATE TABLE IF NOT EXISTS attachment (
+            id SERIAL PRIMARY KEY,
+            entity VARCHAR NOT NULL,
+            entity_id INT NOT NULL,
+            file_name VARCHAR NOT NULL,
+            content_type VARCHAR NOT NULL,
+            size_bytes BIGINT NOT NULL,
+            checksum VARCHAR(64) NOT NULL,
+            storage_key VARCHAR NOT NULL UNIQUE,
+            uploaded_by VARCHAR NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS attachment_entity_idx ON attachment (entity, entity_id) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS material_image (
+            id SERIAL PRIMARY KEY,
+            material_id INT NOT NULL,
+            file_name VARCHAR NOT NULL,
+            content_type VARCHAR NOT NULL,
+            width INT NOT NULL,
+            height INT NOT NULL,
+            size_bytes BIGINT NOT NULL,
+            checksum VARCHAR(64) NOT NULL,
+            storage_key VARCHAR NOT NULL UNIQUE,
+            thumbnail_key VARCHAR NOT NULL UNIQUE,
+            thumbnail_type VARCHAR NOT NULL,
+            uploaded_by VARCHAR NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP,
+            FOREIGN KEY (material_id, tenant_id) REFERENCES material (id, tenant_id)
+        );
+        CREATE INDEX IF NOT EXISTS material_image_material_idx ON material_image (material_id) WHERE deleted_at IS NULL;
+
+        CREATE TABLE IF NOT EXISTS name_translation (
+            entity VARCHAR NOT NULL,
+            entity_id INT NOT NULL,
+            locale VARCHAR NOT NULL,
+            name VARCHAR NOT NULL,
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (entity, entity_id, locale)
+        );
+
+        CREATE TABLE IF NOT EXISTS webhook_subscription (
+            id SERIAL PRIMARY KEY,
+            url VARCHAR NOT NULL,
+            events TEXT[] NOT NULL,
+            secret VARCHAR NOT NULL,
+            active BOOLEAN NOT NULL DEFAULT TRUE,
+            description VARCHAR NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            deleted_at TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS webhook_delivery (
+            id SERIAL PRIMARY KEY,
+            subscription_id INT NOT NULL REFERENCES webhook_subscription(id),
+            event_id VARCHAR NOT NULL,
+            event VARCHAR NOT NULL,
+            payload JSONB NOT NULL,
+            status VARCHAR NOT NULL DEFAULT 'pending',
+            attempts INT NOT NULL DEFAULT 0,
+            next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            last_error TEXT NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            delivered_at TIMESTAMP,
+            UNIQUE (subscription_id, event_id)
+        );
+        CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+
+        CREATE TABLE IF NOT EXISTS webhook_attempt (
+            id SERIAL PRIMARY KEY,
+            delivery_id INT NOT NULL REFERENCES webhook_delivery(id),
+            attempt INT NOT NULL,
+            status_code INT,
+            error TEXT NOT NULL DEFAULT '',
+            response_body TEXT NOT NULL DEFAULT '',
+            duration_ms BIGINT NOT NULL,
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS webhook_attempt_delivery_idx ON webhook_attempt (delivery_id);
+
+        CREATE TABLE IF NOT EXISTS outbox_event (
+            id BIGSERIAL PRIMARY KEY,
+            event_id VARCHAR NOT NULL UNIQUE,
+            aggregate VARCHAR NOT NULL,
+            aggregate_id VARCHAR NOT NULL,
+            event VARCHAR NOT NULL,
+            payload JSONB NOT NULL,
+            attempts INT NOT NULL DEFAULT 0,
+            next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            last_error TEXT NOT NULL DEFAULT '',
+            tenant_id VARCHAR NOT NULL DEFAULT 'default',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            published_at TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS outbox_event_pending_idx ON outbox_event (aggregate, aggregate_id, id) WHERE published_at IS NULL;
+
+        ALTER TABLE category ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE supplier ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE stock_location ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE attribute_definition ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE material_supplier ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE stock_level ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE inventory_movement ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE stock_reservation ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE material_component ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE material_substitute ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE entity_tag ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+        ALTER TABLE attribute_value ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+
+        -- Rows written before these tables had a tenant take the tenant of
+        -- the material or entity they belong to.
+        UPDATE material_supplier x SET tenant_id = m.tenant_id FROM material m WHERE m.id = x.material_id AND x.tenant_id <> m.tenant_id;
+        UPDATE stock_level x SET tenant_id = m.tenant_id FROM material m WHERE m.id = x.material_id AND x.tenant_id <> m.tenant_id;
+        UPDATE inventory_movement x SET tenant_id = m.tenant_id FROM material m WHERE m.id = x.material_id AND x.tenant_id <> m.tenant_id;
+        UPDATE stock_reservation x SET tenant_id = m.tenant_id FROM material m WHERE m.id = x.material_id AND x.tenant_id <> m.tenant_id;
+        UPDATE material_component x SET tenant_id = m.tenant_id FROM material m WHERE m.id = x.assembly_id AND x.tenant_id <> m.tenant_id;
+        UPDATE material_substitute x SET tenant_id = m.tenant_id FROM material m WHERE m.id = x.material_id AND x.tenant_id <> m.tenant_id;
+        UPDATE entity_tag x SET tenant_id = m.tenant_id FROM material m WHERE x.entity = 'material' AND m.id = x.entity_id AND x.tenant_id <> m.tenant_id;
+        UPDATE entity_tag x SET tenant_id = o.tenant_id FROM offer o WHERE x.entity = 'offer' AND o.id = x.entity_id AND x.tenant_id <> o.tenant_id;
+        UPDATE attribute_value x SET tenant_id = m.tenant_id FROM material m WHERE x.entity = 'material' AND m.id = x.entity_id AND x.tenant_id <> m.tenant_id;
+        UPDATE attribute_value x SET tenant_id = o.tenant_id FROM offer o WHERE x.entity = 'offer' AND o.id = x.entity_id AND x.tenant_id <> o.tenant_id;
+
+        DROP INDEX IF EXISTS attribute_definition_name_idx;
+        CREATE UNIQUE INDEX IF NOT EXISTS attribute_definition_tenant_name_idx ON attribute_definition (tenant_id, entity, name) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS category_tenant_idx ON category (tenant_id) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS supplier_tenant_idx ON supplier (tenant_id) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS stock_location_tenant_idx ON stock_location (tenant_id) WHERE deleted_at IS NULL;
+        CREATE INDEX IF NOT EXISTS entity_tag_tenant_idx ON entity_tag (tenant_id, entity, tag);
+
+        CREATE INDEX IF NOT EXISTS offer_name_search_idx ON offer USING GIN (to_tsvector('simple', name));
+        CREATE INDEX IF NOT EXISTS material_name_search_idx ON material USING GIN (to_tsvector('simple', name));
+    `)
+	if err != nil {
+		log.Fatal("Error creating tables:", err)
+	}
+
+	if err := configureRowLevelSecurity(TenantRLS()); err != nil {
+		log.Fatal("Error configuring row-level security:", err)
+	}
+}
+
+// duplicateKeys soft-delete the rows that would break a unique index
+// created on startup, keeping the lowest id of each key.
+var duplicateKeys = []struct {
+	table string
+	query string
+}{
+	{"material", `UPDATE material m SET deleted_at = CURRENT_TIMESTAMP
+		WHERE m.deleted_at IS NULL AND EXISTS (SELECT 1 FROM material k
+			WHERE k.tenant_id = m.tenant_id AND LOWER(k.name) = LOWER(m.name) AND k.deleted_at IS NULL AND k.id < m.id)`},
+	{"offer_material", `UPDATE offer_material om SET deleted_at = CURRENT_TIMESTAMP
+		WHERE om.deleted_at IS NULL AND EXISTS (SELECT 1 FROM offer_material k
+			WHERE k.offer_id = om.offer_id AND k.material_id = om.material_id AND k.deleted_at IS NULL AND k.id < om.id)`},
+}
+
+// dedupeUniqueKeys runs duplicateKeys, so databases from before
+// material_tenant_name_idx and offer_material_pair_idx can get them. The
+// removed rows are logged; they stay in the table for review.
+func dedupeUniqueKeys(db *sql.DB) error {
+	for _, key := range duplicateKeys {
+		res, err := db.Exec(key.query)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			log.Printf("Soft-deleted %d duplicate %s rows", n, key.table)
+		}
+	}
+	return nil
+}
+
+// tenantTables hold a tenant_id and are isolated by the tenant policies.
+var tenantTables = []string{"offer", "material", "offer_material", "customer", "contact", "approval_rule", "offer_approval", "offer_comment", "attachment", "material_image", "name_translation", "webhook_subscription", "webhook_delivery", "webhook_attempt", "outbox_event",
+	"category", "supplier", "material_supplier", "stock_location", "stock_level", "inventory_movement", "stock_reservation", "material_component", "material_substitute", "entity_tag", "attribute_definition", "attribute_value"}
+
+// configureRowLevelSecurity turns the tenant policies on or off. The
+// policies are forced so they also apply to the table owner, which is
+// usually the role the application connects as. tenant.All lets system
+// jobs see every tenant.
+func configureRowLevelSecurity(enabled bool) error {
+	for _, table := range tenantTables {
+		statements := []string{
+			"ALTER TABLE " + table + " NO FORCE ROW LEVEL SECURITY",
+			"ALTER TABLE " + table + " DISABLE ROW LEVEL SECURITY",
+		}
+		if enabled {
+			check := "tenant_id = current_setting('" + tenant.RLSSetting + "', true) OR current_setting('" + tenant.RLSSetting + "', true) = '" + tenant.All + "'"
+			statements = []string{
+				"ALTER TABLE " + table + " ENABLE ROW LEVEL SECURITY",
+				"ALTER TABLE " + table + " FORCE ROW LEVEL SECURITY",
+				"DROP POLICY IF EXISTS tenant_isolation ON " + table,
+				"CREATE POLICY tenant_isolation ON " + table + " USING (" + check + ") WITH CHECK (" + check + ")",
+			}
+		}
+		for _, statement := range statements {
+			if _, err := DB.Exec(statement); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
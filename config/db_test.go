@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupeUniqueKeys(t *testing.T) {
+	// A row is soft-deleted when its key has a lower id, which keeps the
+	// lowest.
+	materials := regexp.QuoteMeta(`UPDATE material m SET deleted_at = CURRENT_TIMESTAMP`) + `(?s).*k\.id < m\.id`
+	offerMaterials := regexp.QuoteMeta(`UPDATE offer_material om SET deleted_at = CURRENT_TIMESTAMP`) + `(?s).*k\.id < om\.id`
+
+	testCases := []struct {
+		name        string
+		expectError bool
+		mockQueries func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "duplicates soft-deleted",
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(materials).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(offerMaterials).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+		{
+			name:        "failure - stops at the first error",
+			expectError: true,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(materials).WillReturnError(errors.New("permission denied"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			err = dedupeUniqueKeys(db)
+			assert.Equal(t, tc.expectError, err != nil)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
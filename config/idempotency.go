@@ -0,0 +1,26 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long responses to keyed POSTs are kept for
+// replay.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotencyTTL reads IDEMPOTENCY_TTL as a Go duration (e.g. "12h"),
+// falling back to DefaultIdempotencyTTL when it is unset or invalid.
+func IdempotencyTTL() time.Duration {
+	value := os.Getenv("IDEMPOTENCY_TTL")
+	if value == "" {
+		return DefaultIdempotencyTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid IDEMPOTENCY_TTL %q, using %v", value, DefaultIdempotencyTTL)
+		return DefaultIdempotencyTTL
+	}
+	return ttl
+}
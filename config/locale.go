@@ -0,0 +1,52 @@
+package config
+
+import (
+	"Products/locale"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DefaultLocale is the locale of the names stored on materials and offers
+// when DEFAULT_LOCALE is not set.
+const DefaultLocale = "en"
+
+// NameLocale reads DEFAULT_LOCALE, the locale the name column of materials
+// and offers is written in. It ends every fallback chain.
+func NameLocale() string {
+	value := os.Getenv("DEFAULT_LOCALE")
+	if value == "" {
+		return DefaultLocale
+	}
+	tag := locale.Normalize(value)
+	if tag == "" {
+		log.Printf("Invalid DEFAULT_LOCALE %q, using %v", value, DefaultLocale)
+		return DefaultLocale
+	}
+	return tag
+}
+
+// Locales reads LOCALES, the comma separated locales names are published
+// in. Translations can only be added for these, and the missing
+// translation report covers them. The default locale is always included.
+func Locales() []string {
+	value := os.Getenv("LOCALES")
+	if value == "" {
+		value = "en,de,fr"
+	}
+	locales := []string{NameLocale()}
+	for _, part := range strings.Split(value, ",") {
+		tag := locale.Normalize(part)
+		if tag == "" {
+			if strings.TrimSpace(part) != "" {
+				log.Printf("Ignoring invalid locale %q in LOCALES", part)
+			}
+			continue
+		}
+		if !slices.Contains(locales, tag) {
+			locales = append(locales, tag)
+		}
+	}
+	return locales
+}
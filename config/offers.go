@@ -0,0 +1,13 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// OffersOwnerOnly reads OFFERS_OWNER_ONLY. When true users may only change
+// offers they own or that are unassigned, unless they may assign offers.
+func OffersOwnerOnly() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("OFFERS_OWNER_ONLY"))
+	return enabled
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Outbox relay defaults. A message that fails to publish is retried after
+// DefaultOutboxBackoff, doubling per failure up to OutboxMaxBackoff; it is
+// never given up on, since later events of its aggregate wait for it. A
+// relay has OutboxClaimTimeout to publish the messages it claimed before
+// another one may claim them again.
+const (
+	DefaultOutboxPollInterval = time.Second
+	DefaultOutboxBackoff      = 5 * time.Second
+	OutboxMaxBackoff          = 10 * time.Minute
+	OutboxClaimTimeout        = time.Minute
+)
+
+// OutboxPublisherNames are the destinations OUTBOX_PUBLISHERS may list.
+// Programs with a NATS connection can add an outbox.NATSPublisher
+// themselves; none is bundled here.
+var OutboxPublisherNames = []string{"log", "webhook"}
+
+// DefaultOutboxPublishers feed the webhook deliveries.
+var DefaultOutboxPublishers = []string{"webhook"}
+
+// OutboxPublishers reads OUTBOX_PUBLISHERS, a comma-separated list of
+// destinations for domain events. Unknown names are logged and skipped.
+func OutboxPublishers() []string {
+	value := os.Getenv("OUTBOX_PUBLISHERS")
+	if value == "" {
+		return DefaultOutboxPublishers
+	}
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		if !slices.Contains(OutboxPublisherNames, name) {
+			log.Printf("Ignoring unknown outbox publisher %q, expected one of %s", name, strings.Join(OutboxPublisherNames, ", "))
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// OutboxPollInterval reads OUTBOX_POLL_INTERVAL as a Go duration, how
+// often the relay looks for pending events. "0" turns the relay off, e.g.
+// for instances that should only serve requests.
+func OutboxPollInterval() time.Duration {
+	if os.Getenv("OUTBOX_POLL_INTERVAL") == "0" {
+		return 0
+	}
+	return envDuration("OUTBOX_POLL_INTERVAL", DefaultOutboxPollInterval)
+}
+
+// OutboxBackoff reads OUTBOX_BACKOFF as a Go duration, the wait after the
+// first failed publish.
+func OutboxBackoff() time.Duration {
+	return envDuration("OUTBOX_BACKOFF", DefaultOutboxBackoff)
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// DefaultMarkupPercent is added to the preferred supplier's purchase price
+// when an offer line is created without a unit price.
+const DefaultMarkupPercent = 25.0
+
+// MarkupPercent reads PRICE_MARKUP_PERCENT, falling back to
+// DefaultMarkupPercent when it is unset or invalid.
+func MarkupPercent() float64 {
+	value := os.Getenv("PRICE_MARKUP_PERCENT")
+	if value == "" {
+		return DefaultMarkupPercent
+	}
+	markup, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid PRICE_MARKUP_PERCENT %q, using %v", value, DefaultMarkupPercent)
+		return DefaultMarkupPercent
+	}
+	return markup
+}
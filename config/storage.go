@@ -0,0 +1,53 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// DefaultAttachmentMaxBytes limits uploaded attachments to 20 MiB.
+const DefaultAttachmentMaxBytes = 20 << 20
+
+// DefaultThumbnailSize is the longest side of a material image thumbnail.
+const DefaultThumbnailSize = 256
+
+// AttachmentDir reads ATTACHMENT_DIR, the directory attachments are stored
+// in, defaulting to data/attachments.
+func AttachmentDir() string {
+	if dir := os.Getenv("ATTACHMENT_DIR"); dir != "" {
+		return dir
+	}
+	return "data/attachments"
+}
+
+// AttachmentMaxBytes reads ATTACHMENT_MAX_BYTES, falling back to
+// DefaultAttachmentMaxBytes when it is unset or invalid.
+func AttachmentMaxBytes() int64 {
+	value := os.Getenv("ATTACHMENT_MAX_BYTES")
+	if value == "" {
+		return DefaultAttachmentMaxBytes
+	}
+	max, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || max <= 0 {
+		log.Printf("Invalid ATTACHMENT_MAX_BYTES %q, using %v", value, DefaultAttachmentMaxBytes)
+		return DefaultAttachmentMaxBytes
+	}
+	return max
+}
+
+// ThumbnailSize reads THUMBNAIL_SIZE, the longest side in pixels of the
+// thumbnails made for material images, falling back to
+// DefaultThumbnailSize when it is unset or invalid.
+func ThumbnailSize() int {
+	value := os.Getenv("THUMBNAIL_SIZE")
+	if value == "" {
+		return DefaultThumbnailSize
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		log.Printf("Invalid THUMBNAIL_SIZE %q, using %v", value, DefaultThumbnailSize)
+		return DefaultThumbnailSize
+	}
+	return size
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// DefaultTenant is the tenant of rows created before multi-tenancy and of
+// requests that name none.
+const DefaultTenant = "default"
+
+// Tenant reads DEFAULT_TENANT, the tenant platform admins act for when
+// they send no X-Tenant-ID header. Setting it to "none" makes the header
+// mandatory for them.
+func Tenant() string {
+	value, ok := os.LookupEnv("DEFAULT_TENANT")
+	switch {
+	case !ok || value == "":
+		return DefaultTenant
+	case value == "none":
+		return ""
+	default:
+		return value
+	}
+}
+
+// TenantRLS reads TENANT_RLS. When true the tenant tables get row-level
+// security policies on top of the tenant conditions in every query.
+func TenantRLS() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("TENANT_RLS"))
+	return enabled
+}
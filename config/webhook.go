@@ -0,0 +1,70 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Webhook delivery defaults. A delivery is tried up to
+// DefaultWebhookMaxAttempts times, waiting DefaultWebhookBackoff after the
+// first failure and twice as long after each further one, up to
+// WebhookMaxBackoff.
+const (
+	DefaultWebhookMaxAttempts  = 8
+	DefaultWebhookBackoff      = 30 * time.Second
+	DefaultWebhookTimeout      = 10 * time.Second
+	DefaultWebhookPollInterval = 5 * time.Second
+	WebhookMaxBackoff          = 6 * time.Hour
+)
+
+// WebhookMaxAttempts reads WEBHOOK_MAX_ATTEMPTS, after which a failing
+// delivery becomes a dead letter.
+func WebhookMaxAttempts() int {
+	value := os.Getenv("WEBHOOK_MAX_ATTEMPTS")
+	if value == "" {
+		return DefaultWebhookMaxAttempts
+	}
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts <= 0 {
+		log.Printf("Invalid WEBHOOK_MAX_ATTEMPTS %q, using %v", value, DefaultWebhookMaxAttempts)
+		return DefaultWebhookMaxAttempts
+	}
+	return attempts
+}
+
+// WebhookBackoff reads WEBHOOK_BACKOFF as a Go duration, the wait after the
+// first failed attempt.
+func WebhookBackoff() time.Duration {
+	return envDuration("WEBHOOK_BACKOFF", DefaultWebhookBackoff)
+}
+
+// WebhookTimeout reads WEBHOOK_TIMEOUT as a Go duration, how long a
+// receiver has to answer.
+func WebhookTimeout() time.Duration {
+	return envDuration("WEBHOOK_TIMEOUT", DefaultWebhookTimeout)
+}
+
+// WebhookPollInterval reads WEBHOOK_POLL_INTERVAL as a Go duration, how
+// often the server looks for due deliveries. "0" turns delivery off, e.g.
+// for instances that should only serve requests.
+func WebhookPollInterval() time.Duration {
+	if os.Getenv("WEBHOOK_POLL_INTERVAL") == "0" {
+		return 0
+	}
+	return envDuration("WEBHOOK_POLL_INTERVAL", DefaultWebhookPollInterval)
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,163 @@
+package documents
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"Products/utils"
+)
+
+// Document formats accepted by RenderQuote.
+const (
+	FormatHTML = "html"
+	FormatPDF  = "pdf"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// QuoteLine is one material line on a quote.
+type QuoteLine struct {
+	Position     int
+	MaterialID   int
+	MaterialName string
+	Quantity     float64
+	UnitPrice    float64
+	Total        float64
+}
+
+// QuoteContact is a person at the customer the quote is addressed to.
+type QuoteContact struct {
+	Name  string
+	Email string
+	Phone string
+}
+
+// QuoteCustomer is the recipient of a quote.
+type QuoteCustomer struct {
+	Name     string
+	Email    string
+	Phone    string
+	Contacts []QuoteContact
+}
+
+// Quote is the data passed to the quote templates. Customer is nil when
+// the offer has no customer.
+type Quote struct {
+	Company   string
+	Currency  string
+	OfferID   int
+	OfferName string
+	Customer  *QuoteCustomer
+	CreatedAt time.Time
+	IssuedAt  time.Time
+	Lines     []QuoteLine
+	Total     float64
+}
+
+// NewQuote fills in line totals and the grand total. Company and currency
+// come from QUOTE_COMPANY_NAME and QUOTE_CURRENCY.
+func NewQuote(offerID int, offerName string, createdAt time.Time, lines []QuoteLine) Quote {
+	quote := Quote{
+		Company:   os.Getenv("QUOTE_COMPANY_NAME"),
+		Currency:  os.Getenv("QUOTE_CURRENCY"),
+		OfferID:   offerID,
+		OfferName: offerName,
+		CreatedAt: createdAt,
+		IssuedAt:  time.Now(),
+		Lines:     lines,
+	}
+	if quote.Currency == "" {
+		quote.Currency = "EUR"
+	}
+	for i := range quote.Lines {
+		quote.Lines[i].Position = i + 1
+		quote.Lines[i].Total = roundCents(quote.Lines[i].Quantity * quote.Lines[i].UnitPrice)
+		quote.Total += quote.Lines[i].Total
+	}
+	quote.Total = roundCents(quote.Total)
+	return quote
+}
+
+var templateFuncs = map[string]interface{}{
+	"money": func(v float64) string { return fmt.Sprintf("%.2f", v) },
+	"qty": func(v float64) string {
+		return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", v), "0"), ".")
+	},
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"truncate": func(n int, s string) string {
+		if r := []rune(s); len(r) > n {
+			return string(r[:n-1]) + "…"
+		}
+		return s
+	},
+}
+
+var (
+	loadOnce     sync.Once
+	htmlTemplate *htmltemplate.Template
+	textTemplate *texttemplate.Template
+	loadErr      error
+)
+
+// loadTemplates parses quote.html.tmpl and quote.txt.tmpl, preferring the
+// files in QUOTE_TEMPLATE_DIR over the built-in ones.
+func loadTemplates() error {
+	loadOnce.Do(func() {
+		read := func(name string) ([]byte, error) {
+			if dir := os.Getenv("QUOTE_TEMPLATE_DIR"); dir != "" {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if err == nil || !os.IsNotExist(err) {
+					return data, err
+				}
+			}
+			return defaultTemplates.ReadFile("templates/" + name)
+		}
+
+		var html, text []byte
+		if html, loadErr = read("quote.html.tmpl"); loadErr != nil {
+			return
+		}
+		if text, loadErr = read("quote.txt.tmpl"); loadErr != nil {
+			return
+		}
+		if htmlTemplate, loadErr = htmltemplate.New("quote.html").Funcs(templateFuncs).Parse(string(html)); loadErr != nil {
+			return
+		}
+		textTemplate, loadErr = texttemplate.New("quote.txt").Funcs(templateFuncs).Parse(string(text))
+	})
+	return loadErr
+}
+
+// RenderQuote writes the quote as HTML or as a PDF laid out from the
+// plain-text template.
+func RenderQuote(w io.Writer, format string, quote Quote) error {
+	if err := loadTemplates(); err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatHTML:
+		return htmlTemplate.Execute(w, quote)
+	case FormatPDF:
+		var text strings.Builder
+		if err := textTemplate.Execute(&text, quote); err != nil {
+			return err
+		}
+		return utils.WriteTextPDF(w, text.String())
+	}
+	return fmt.Errorf("unsupported document format %q", format)
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
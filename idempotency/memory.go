@@ -0,0 +1,63 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	rec     Record
+	expires time.Time
+}
+
+// sweepInterval is how often MemoryStore drops expired keys.
+const sweepInterval = time.Minute
+
+// MemoryStore is an in-process Store for a single instance or tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+	swept   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}, now: time.Now}
+}
+
+func (m *MemoryStore) Begin(ctx context.Context, key, requestHash string, ttl time.Duration) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if now.Sub(m.swept) >= sweepInterval {
+		for k, entry := range m.entries {
+			if !now.Before(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.swept = now
+	}
+	if entry, ok := m.entries[key]; ok && now.Before(entry.expires) {
+		return entry.rec, false, nil
+	}
+	m.entries[key] = memoryEntry{rec: Record{RequestHash: requestHash}, expires: now.Add(ttl)}
+	return Record{}, true, nil
+}
+
+func (m *MemoryStore) Complete(ctx context.Context, key string, rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[key]; ok {
+		entry.rec = rec
+		m.entries[key] = entry
+	}
+	return nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
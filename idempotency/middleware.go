@@ -0,0 +1,152 @@
+package idempotency
+
+import (
+	"Products/auth"
+	"Products/tenant"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+	maxKeyLength   = 255
+	// maxBodyBytes bounds the request body buffered for hashing.
+	maxBodyBytes = 10 << 20
+)
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// requestHash identifies a request by method, path, query and body, so a
+// key reused for anything else is detected.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Middleware makes POST requests carrying an Idempotency-Key safe to
+// retry. The first response for a key is stored for ttl and replayed for
+// later requests with the same key and body; a different body gets 422
+// and a retry while the first request is still running gets 409. Server
+// errors are not stored, so the request can be retried.
+//
+// Bodies over maxBodyBytes get 413. Multipart uploads with a key get 400:
+// they can be far larger and are never buffered, and clients pick a new
+// boundary for each attempt, so a retry could not be matched anyway.
+func Middleware(store Store, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderKey)
+			multipart := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/")
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if multipart {
+				http.Error(w, "Idempotency-Key is not supported for multipart requests", http.StatusBadRequest)
+				return
+			}
+			if len(key) > maxKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body is too large for an Idempotency-Key", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "error reading request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := requestHash(r, body)
+			// Keys are scoped to the caller and tenant, so nobody can replay
+			// another caller's response by guessing their key.
+			if id := tenant.FromContext(r.Context()); id != "" {
+				key = id + ":" + key
+			}
+			if p, ok := auth.FromContext(r.Context()); ok {
+				key = p.ID + ":" + key
+			}
+
+			rec, claimed, err := store.Begin(r.Context(), key, hash, ttl)
+			if err != nil {
+				log.Printf("Error reserving idempotency key: %v", err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				switch {
+				case rec.RequestHash != hash:
+					http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+				case rec.StatusCode == 0:
+					http.Error(w, "a request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					if rec.ContentType != "" {
+						w.Header().Set("Content-Type", rec.ContentType)
+					}
+					w.Header().Set(HeaderReplayed, "true")
+					w.WriteHeader(rec.StatusCode)
+					w.Write(rec.Body)
+				}
+				return
+			}
+
+			rw := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+
+			// The outcome is saved even when the client has gone away, or the
+			// key would stay in progress until it expires.
+			ctx := context.WithoutCancel(r.Context())
+			if rw.status >= http.StatusInternalServerError {
+				err = store.Release(ctx, key)
+			} else {
+				err = store.Complete(ctx, key, Record{
+					RequestHash: hash,
+					StatusCode:  rw.status,
+					ContentType: w.Header().Get("Content-Type"),
+					Body:        rw.body.Bytes(),
+				})
+			}
+			if err != nil {
+				log.Printf("Error saving idempotency key: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps records in the idempotency_key table, so retries
+// are recognised across instances.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Begin also purges expired keys, which keeps the table small without a
+// separate job.
+func (p *PostgresStore) Begin(ctx context.Context, key, requestHash string, ttl time.Duration) (Record, bool, error) {
+	var rec Record
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM idempotency_key WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		return rec, false, err
+	}
+
+	res, err := p.db.ExecContext(ctx, `INSERT INTO idempotency_key (key, request_hash, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+		ON CONFLICT (key) DO NOTHING`, key, requestHash, int64(ttl/time.Second))
+	if err != nil {
+		return rec, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return rec, false, err
+	}
+	if n == 1 {
+		return rec, true, nil
+	}
+
+	var status sql.NullInt64
+	err = p.db.QueryRowContext(ctx, "SELECT request_hash, status_code, content_type, body FROM idempotency_key WHERE key = $1", key).
+		Scan(&rec.RequestHash, &status, &rec.ContentType, &rec.Body)
+	if err == sql.ErrNoRows {
+		// Expired and purged by a concurrent request; claim it again.
+		return p.Begin(ctx, key, requestHash, ttl)
+	}
+	rec.StatusCode = int(status.Int64)
+	return rec, false, err
+}
+
+func (p *PostgresStore) Complete(ctx context.Context, key string, rec Record) error {
+	_, err := p.db.ExecContext(ctx, "UPDATE idempotency_key SET status_code = $1, content_type = $2, body = $3 WHERE key = $4",
+		rec.StatusCode, rec.ContentType, rec.Body, key)
+	return err
+}
+
+func (p *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM idempotency_key WHERE key = $1 AND status_code IS NULL", key)
+	return err
+}
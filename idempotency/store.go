@@ -0,0 +1,30 @@
+// Package idempotency replays the stored response when a POST is retried
+// with the same Idempotency-Key. Store is implemented on Postgres and in
+// memory.
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// Record is what is kept for a key. A zero StatusCode means the first
+// request is still being processed.
+type Record struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps idempotency records until their TTL runs out.
+type Store interface {
+	// Begin claims key for a request. When the key is already taken it
+	// returns claimed=false and the existing record.
+	Begin(ctx context.Context, key, requestHash string, ttl time.Duration) (rec Record, claimed bool, err error)
+	// Complete stores the response for a claimed key.
+	Complete(ctx context.Context, key string, rec Record) error
+	// Release forgets a claimed key so the request can be retried, e.g.
+	// after a server error.
+	Release(ctx context.Context, key string) error
+}
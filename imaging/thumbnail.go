@@ -0,0 +1,104 @@
+// Package imaging decodes uploaded images and scales them down to
+// thumbnails using only the standard library.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// MaxPixels bounds the decoded size of an image, so a small file that
+// expands to a huge bitmap is refused before it is decoded.
+const MaxPixels = 40_000_000
+
+var (
+	// ErrUnsupported is returned for data that is not a JPEG, PNG or GIF.
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images with more than MaxPixels pixels.
+	ErrTooLarge = errors.New("image dimensions too large")
+)
+
+// ContentTypes maps the formats returned by Decode to their MIME types.
+var ContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// Decode reads a JPEG, PNG or GIF image and returns it with its format.
+func Decode(data []byte) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupported
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	return img, format, nil
+}
+
+// Fit returns the size of a w x h image scaled down to fit in a box of
+// size x size, keeping its aspect ratio. Images that already fit keep
+// their size.
+func Fit(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+// Thumbnail scales src down to fit in a box of size x size. Each target
+// pixel is the average of the source pixels it covers, which keeps fine
+// detail from aliasing the way nearest-neighbour sampling would.
+func Thumbnail(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := Fit(sw, sh, size)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*sh/dh, bounds.Min.Y+(y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*sw/dw, bounds.Min.X+(x+1)*sw/dw
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			// RGBA returns premultiplied 16-bit channels, which average
+			// correctly and map straight onto image.RGBA.
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// Encode writes a thumbnail as JPEG when the original was a JPEG and as
+// PNG otherwise, so transparency survives. It returns the content type.
+func Encode(w io.Writer, img image.Image, format string) (string, error) {
+	if format == "jpeg" {
+		return ContentTypes["jpeg"], jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+	return ContentTypes["png"], png.Encode(w, img)
+}
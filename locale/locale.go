@@ -0,0 +1,102 @@
+// Package locale picks the locales a request wants names in, from ?lang=
+// and Accept-Language, as an ordered fallback chain.
+package locale
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxChain bounds the chain built from a request, so a long
+// Accept-Language header cannot blow up the translation lookup.
+const maxChain = 10
+
+var tagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// Normalize lowercases a language tag and uses "-" as separator, so
+// "de_AT" and "de-at" name the same locale. It returns "" for values that
+// are not language tags.
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if !tagPattern.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
+
+// parents returns tag followed by its shorter prefixes, e.g. "de-ch-1996",
+// "de-ch", "de".
+func parents(tag string) []string {
+	chain := []string{tag}
+	for i := strings.LastIndex(tag, "-"); i > 0; i = strings.LastIndex(tag, "-") {
+		tag = tag[:i]
+		chain = append(chain, tag)
+	}
+	return chain
+}
+
+// AcceptLanguage returns the tags of an Accept-Language header, most
+// preferred first. Wildcards, invalid tags and tags with q=0 are dropped.
+func AcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := Normalize(fields[0])
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Chain returns the locales to look names up in for r: the ?lang= locale,
+// then the Accept-Language locales, each followed by its parents, and
+// finally fallback. Nothing after fallback is tried, since every record
+// has a name in it.
+func Chain(r *http.Request, fallback string) []string {
+	var requested []string
+	if lang := Normalize(r.URL.Query().Get("lang")); lang != "" {
+		requested = append(requested, lang)
+	}
+	requested = append(requested, AcceptLanguage(r.Header.Get("Accept-Language"))...)
+
+	seen := map[string]bool{}
+	chain := []string{}
+	for _, tag := range requested {
+		for _, candidate := range parents(tag) {
+			if seen[candidate] || len(chain) == maxChain {
+				continue
+			}
+			seen[candidate] = true
+			chain = append(chain, candidate)
+			if candidate == fallback {
+				return chain
+			}
+		}
+	}
+	return append(chain, fallback)
+}
@@ -4,11 +4,16 @@ import (
 	"Products/app"
 	"log"
 	"Products/config"
+	"os"
 
 	_ "github.com/lib/pq"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	config.ConnectDB()
 	defer config.CloseDB()
 
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// APIKey is a stored API key. Only its hash is kept; Key holds the
+// plaintext in the response that creates it and nowhere else.
+type APIKey struct {
+    ID        int        `json:"id"`
+    Name      string     `json:"name"`
+    Prefix    string     `json:"prefix"`
+    Roles     []string   `json:"roles"`
+    Tenant    *string    `json:"tenant"`
+    Key       string     `json:"key,omitempty"`
+    CreatedAt time.Time  `json:"created_at"`
+    ExpiresAt *time.Time `json:"expires_at"`
+    RevokedAt *time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// Metrics an approval rule can test.
+const (
+    // MetricTotal is the offer total, quantity times unit price over all
+    // lines.
+    MetricTotal = "total"
+    // MetricDiscountPercent is the discount of the whole offer against the
+    // list prices of its lines.
+    MetricDiscountPercent = "discount_percent"
+    // MetricLineDiscountPercent is tested against every line on its own.
+    MetricLineDiscountPercent = "line_discount_percent"
+)
+
+const (
+    ApprovalPending  = "pending"
+    ApprovalApproved = "approved"
+    ApprovalRejected = "rejected"
+)
+
+// ApprovalRule makes offers that match it wait for approval before they
+// are sent, e.g. total > 10000 or line_discount_percent >= 20.
+type ApprovalRule struct {
+    ID        int        `json:"id"`
+    Name      string     `json:"name"`
+    Metric    string     `json:"metric"`
+    Operator  string     `json:"operator"`
+    Threshold float64    `json:"threshold"`
+    Active    bool       `json:"active"`
+    TenantID  string     `json:"tenant_id"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    DeletedAt *time.Time `json:"deleted_at"`
+}
+
+// ApprovalMatch is a rule an offer matched and the value that matched it.
+// OfferMaterialID is set for line metrics.
+type ApprovalMatch struct {
+    RuleID          int     `json:"rule_id"`
+    RuleName        string  `json:"rule_name"`
+    Metric          string  `json:"metric"`
+    Operator        string  `json:"operator"`
+    Threshold       float64 `json:"threshold"`
+    Value           float64 `json:"value"`
+    OfferMaterialID *int    `json:"offer_material_id,omitempty"`
+}
+
+// OfferApproval is a request to approve sending an offer and its outcome.
+type OfferApproval struct {
+    ID          int             `json:"id"`
+    OfferID     int             `json:"offer_id"`
+    Status      string          `json:"status"`
+    Matches     []ApprovalMatch `json:"matches"`
+    RequestedBy string          `json:"requested_by"`
+    DecidedBy   *string         `json:"decided_by"`
+    Comment     string          `json:"comment"`
+    CreatedAt   time.Time       `json:"created_at"`
+    DecidedAt   *time.Time      `json:"decided_at"`
+}
+
+// ApprovalDecision is the body of the approve and reject endpoints.
+type ApprovalDecision struct {
+    Comment string `json:"comment"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded to a material or an offer. Checksum is the
+// hex SHA-256 of the content and ContentType is sniffed from it. URL is
+// where the file is downloaded.
+type Attachment struct {
+    ID          int       `json:"id"`
+    Entity      string    `json:"entity"`
+    EntityID    int       `json:"entity_id"`
+    FileName    string    `json:"file_name"`
+    ContentType string    `json:"content_type"`
+    Size        int64     `json:"size"`
+    Checksum    string    `json:"checksum"`
+    URL         string    `json:"url"`
+    UploadedBy  string    `json:"uploaded_by"`
+    CreatedAt   time.Time `json:"created_at"`
+}
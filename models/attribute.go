@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Entities that can carry tags and custom attributes.
+const (
+	EntityMaterial = "material"
+	EntityOffer    = "offer"
+)
+
+// Custom attribute types.
+const (
+	AttributeString = "string"
+	AttributeNumber = "number"
+	AttributeBool   = "bool"
+	AttributeDate   = "date"
+)
+
+// AttributeDefinition is the schema for one custom attribute of an entity.
+type AttributeDefinition struct {
+	ID        int        `json:"id"`
+	Entity    string     `json:"entity"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
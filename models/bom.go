@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// MaterialComponent is one line of an assembly's bill of materials:
+// Quantity units of the component go into one unit of the assembly.
+type MaterialComponent struct {
+    ID            int        `json:"id"`
+    AssemblyID    int        `json:"assembly_id"`
+    ComponentID   int        `json:"component_id"`
+    ComponentName string     `json:"component_name,omitempty"`
+    Quantity      float64    `json:"quantity"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+    DeletedAt     *time.Time `json:"deleted_at"`
+}
+
+type ExplodedLine struct {
+    MaterialID   int     `json:"material_id"`
+    MaterialName string  `json:"material_name"`
+    Quantity     float64 `json:"quantity"`
+}
+
+// OfferExplosion lists an offer's materials broken down to leaf materials,
+// with quantities summed over every line and assembly they appear in.
+type OfferExplosion struct {
+    OfferID int            `json:"offer_id"`
+    Lines   []ExplodedLine `json:"lines"`
+}
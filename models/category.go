@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type Category struct {
+    ID        int        `json:"id"`
+    Name      string     `json:"name"`
+    ParentID  *int       `json:"parent_id"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    DeletedAt *time.Time `json:"deleted_at"`
+    Children  []Category `json:"children,omitempty"`
+}
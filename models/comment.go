@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OfferComment is a comment on an offer, or on one of its lines when
+// OfferMaterialID is set. Replies hold the comments answering it; a deleted
+// comment stays in the thread without its body while it has replies.
+type OfferComment struct {
+    ID              int            `json:"id"`
+    OfferID         int            `json:"offer_id"`
+    OfferMaterialID *int           `json:"offer_material_id"`
+    ParentID        *int           `json:"parent_id"`
+    AuthorID        string         `json:"author_id"`
+    AuthorName      string         `json:"author_name"`
+    Body            string         `json:"body"`
+    CreatedAt       time.Time      `json:"created_at"`
+    UpdatedAt       time.Time      `json:"updated_at"`
+    DeletedAt       *time.Time     `json:"deleted_at"`
+    Replies         []OfferComment `json:"replies"`
+}
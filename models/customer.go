@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// EntityCustomer is the outbox aggregate of customer events.
+const EntityCustomer = "customer"
+
+// Customer is the recipient of offers. Contacts are only loaded on the
+// single customer endpoint.
+type Customer struct {
+    ID        int        `json:"id"`
+    Name      string     `json:"name"`
+    Email     string     `json:"email"`
+    Phone     string     `json:"phone"`
+    TenantID  string     `json:"tenant_id"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    DeletedAt *time.Time `json:"deleted_at"`
+    Contacts  []Contact  `json:"contacts,omitempty"`
+}
+
+// Contact is a person at a customer.
+type Contact struct {
+    ID         int        `json:"id"`
+    CustomerID int        `json:"customer_id"`
+    Name       string     `json:"name"`
+    Email      string     `json:"email"`
+    Phone      string     `json:"phone"`
+    CreatedAt  time.Time  `json:"created_at"`
+    UpdatedAt  time.Time  `json:"updated_at"`
+    DeletedAt  *time.Time `json:"deleted_at"`
+}
+
+// OfferStatusSummary counts a customer's offers in one status. Total is
+// the sum of quantity times unit price over their lines.
+type OfferStatusSummary struct {
+    Status string  `json:"status"`
+    Count  int     `json:"count"`
+    Total  float64 `json:"total"`
+}
+
+// CustomerOffers is the response of GET /customers/{id}/offers.
+type CustomerOffers struct {
+    CustomerID int                  `json:"customer_id"`
+    Offers     []Offer              `json:"offers"`
+    Summary    []OfferStatusSummary `json:"summary"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// MaterialImage is a picture of a material. URL serves the original and
+// ThumbnailURL a copy scaled down to fit the configured thumbnail size.
+type MaterialImage struct {
+    ID           int       `json:"id"`
+    MaterialID   int       `json:"material_id"`
+    FileName     string    `json:"file_name"`
+    ContentType  string    `json:"content_type"`
+    Width        int       `json:"width"`
+    Height       int       `json:"height"`
+    Size         int64     `json:"size"`
+    Checksum     string    `json:"checksum"`
+    URL          string    `json:"url"`
+    ThumbnailURL string    `json:"thumbnail_url"`
+    UploadedBy   string    `json:"uploaded_by"`
+    CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+const (
+    MovementReceive = "receive"
+    MovementIssue   = "issue"
+    MovementAdjust  = "adjust"
+)
+
+type StockLocation struct {
+    ID        int        `json:"id"`
+    Name      string     `json:"name"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type StockLevel struct {
+    LocationID   int       `json:"location_id"`
+    LocationName string    `json:"location_name"`
+    OnHand       float64   `json:"on_hand"`
+    UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// MaterialStock sums a material's stock over all locations. Available is
+// what is on hand minus what accepted offers have reserved.
+type MaterialStock struct {
+    MaterialID int          `json:"material_id"`
+    OnHand     float64      `json:"on_hand"`
+    Reserved   float64      `json:"reserved"`
+    Available  float64      `json:"available"`
+    Locations  []StockLevel `json:"locations"`
+}
+
+// InventoryMovement changes the stock of a material at a location. Receive
+// and issue quantities are positive; an adjust quantity is the signed
+// difference.
+type InventoryMovement struct {
+    ID         int       `json:"id"`
+    MaterialID int       `json:"material_id"`
+    LocationID int       `json:"location_id"`
+    Type       string    `json:"type"`
+    Quantity   float64   `json:"quantity"`
+    Note       string    `json:"note"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+type OfferStatusChange struct {
+    OfferID    int        `json:"offer_id"`
+    Status     string     `json:"status"`
+    ValidUntil *time.Time `json:"valid_until"`
+}
+
+type FulfillmentLine struct {
+    MaterialID   int     `json:"material_id"`
+    MaterialName string  `json:"material_name"`
+    Required     float64 `json:"required"`
+    Available    float64 `json:"available"`
+    Shortfall    float64 `json:"shortfall"`
+}
+
+// FulfillmentCheck tells whether current stock covers an offer. Stock the
+// offer itself has reserved counts as available to it.
+type FulfillmentCheck struct {
+    OfferID     int               `json:"offer_id"`
+    Fulfillable bool              `json:"fulfillable"`
+    Lines       []FulfillmentLine `json:"lines"`
+}
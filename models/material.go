@@ -5,8 +5,19 @@ import "time"
 type Material struct {
     ID        int       `json:"id"`
     Name      string    `json:"name"`
+    // Locale is the locale Name is in, picked from the request's fallback
+    // chain.
+    Locale    string    `json:"locale,omitempty"`
     Active    bool      `json:"active"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt *time.Time `json:"deleted_at"`
+    CategoryID *int      `json:"category_id"`
+    TenantID   string    `json:"tenant_id"`
+    Tags       []string  `json:"tags"`
+    Attributes map[string]interface{} `json:"attributes"`
+    Attachments []Attachment `json:"attachments"`
+    Images      []MaterialImage `json:"images"`
+    // AffectedOffers is only set in the response to a deactivation.
+    AffectedOffers []AffectedOffer `json:"affected_offers,omitempty"`
 }
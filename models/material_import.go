@@ -0,0 +1,44 @@
+package models
+
+// Row outcomes reported by a material CSV import.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportInvalid = "invalid"
+)
+
+type MaterialImportRow struct {
+	Row    int    `json:"row"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// AffectedOffers lists the open offers using a material the row
+	// deactivates.
+	AffectedOffers []AffectedOffer `json:"affected_offers,omitempty"`
+}
+
+type MaterialImportReport struct {
+	DryRun         bool                `json:"dry_run"`
+	Created        int                 `json:"created"`
+	Updated        int                 `json:"updated"`
+	Skipped        int                 `json:"skipped"`
+	Invalid        int                 `json:"invalid"`
+	UnknownColumns []string            `json:"unknown_columns,omitempty"`
+	Rows           []MaterialImportRow `json:"rows"`
+}
+
+func (r *MaterialImportReport) Add(row MaterialImportRow) {
+	switch row.Action {
+	case ImportCreated:
+		r.Created++
+	case ImportUpdated:
+		r.Updated++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportInvalid:
+		r.Invalid++
+	}
+	r.Rows = append(r.Rows, row)
+}
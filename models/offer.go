@@ -2,10 +2,37 @@ package models
 
 import "time"
 
+const (
+    OfferDraft           = "draft"
+    OfferPendingApproval = "pending_approval"
+    OfferSent            = "sent"
+    OfferAccepted        = "accepted"
+    OfferRejected        = "rejected"
+    OfferExpired         = "expired"
+)
+
 type Offer struct {
     ID          int       `json:"id"`
     Name        string    `json:"name"`
+    // Locale is the locale Name is in, picked from the request's fallback
+    // chain.
+    Locale      string    `json:"locale,omitempty"`
     CreatedAt   time.Time `json:"created_at"`
     UpdatedAt   time.Time `json:"updated_at"`
     DeletedAt   *time.Time `json:"deleted_at"`
+    Status      string    `json:"status"`
+    ValidUntil  *time.Time `json:"valid_until"`
+    TenantID    string    `json:"tenant_id"`
+    OwnerID     *string   `json:"owner_id"`
+    CustomerID  *int      `json:"customer_id"`
+    Tags        []string  `json:"tags"`
+    Attributes  map[string]interface{} `json:"attributes"`
+    Attachments []Attachment `json:"attachments"`
+}
+
+// OfferAssignment is the body and response of the offer owner endpoints.
+// OwnerID is the principal ID of the sales rep, nil when unassigned.
+type OfferAssignment struct {
+    OfferID int     `json:"offer_id"`
+    OwnerID *string `json:"owner_id"`
 }
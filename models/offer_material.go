@@ -6,7 +6,10 @@ type OfferMaterial struct {
     ID           int       `json:"id"`
     OfferID      int       `json:"offer_id"`
     MaterialID   int       `json:"material_id"`
+    Quantity     float64   `json:"quantity"`
+    UnitPrice    float64   `json:"unit_price"`
     CreatedAt    time.Time `json:"created_at"`
     UpdatedAt    time.Time `json:"updated_at"`
     DeletedAt    *time.Time `json:"deleted_at"`
+    TenantID     string    `json:"tenant_id"`
 }
@@ -0,0 +1,15 @@
+package models
+
+// Search result types.
+const (
+	SearchOffer    = "offer"
+	SearchMaterial = "material"
+)
+
+type SearchResult struct {
+	Type    string  `json:"type"`
+	ID      int     `json:"id"`
+	Name    string  `json:"name"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
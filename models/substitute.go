@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+const (
+    ReplaceInactive = "inactive"
+    ReplaceDeleted  = "deleted"
+)
+
+// MaterialSubstitute declares that SubstituteID can stand in for
+// MaterialID. Lower priorities are preferred.
+type MaterialSubstitute struct {
+    ID             int        `json:"id"`
+    MaterialID     int        `json:"material_id"`
+    SubstituteID   int        `json:"substitute_id"`
+    SubstituteName string     `json:"substitute_name,omitempty"`
+    Priority       int        `json:"priority"`
+    CreatedAt      time.Time  `json:"created_at"`
+    UpdatedAt      time.Time  `json:"updated_at"`
+    DeletedAt      *time.Time `json:"deleted_at"`
+}
+
+// ReplacementProposal is an offer line whose material is no longer usable
+// and the best active substitute for it, if there is one.
+type ReplacementProposal struct {
+    OfferMaterialID int     `json:"offer_material_id"`
+    MaterialID      int     `json:"material_id"`
+    MaterialName    string  `json:"material_name"`
+    Reason          string  `json:"reason"`
+    SubstituteID    *int    `json:"substitute_id"`
+    SubstituteName  *string `json:"substitute_name"`
+}
+
+type OfferReplacements struct {
+    OfferID   int                   `json:"offer_id"`
+    Applied   bool                  `json:"applied"`
+    Proposals []ReplacementProposal `json:"proposals"`
+}
+
+// AffectedOffer is an open offer that uses an inactive or deleted
+// material, optionally with the lines concerned.
+type AffectedOffer struct {
+    OfferID   int                   `json:"offer_id"`
+    OfferName string                `json:"offer_name"`
+    Status    string                `json:"status"`
+    Lines     []ReplacementProposal `json:"lines,omitempty"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type Supplier struct {
+    ID        int        `json:"id"`
+    Name      string     `json:"name"`
+    Email     string     `json:"email"`
+    Phone     string     `json:"phone"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    DeletedAt *time.Time `json:"deleted_at"`
+}
+
+// MaterialSupplier is a source for a material. At most one supplier per
+// material is preferred; its price drives default offer pricing.
+type MaterialSupplier struct {
+    ID            int        `json:"id"`
+    MaterialID    int        `json:"material_id"`
+    SupplierID    int        `json:"supplier_id"`
+    SupplierName  string     `json:"supplier_name,omitempty"`
+    SupplierSKU   string     `json:"supplier_sku"`
+    PurchasePrice float64    `json:"purchase_price"`
+    LeadTimeDays  int        `json:"lead_time_days"`
+    Preferred     bool       `json:"preferred"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+    DeletedAt     *time.Time `json:"deleted_at"`
+}
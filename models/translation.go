@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Translation is the name of a material or offer in one locale.
+type Translation struct {
+    Locale    string    `json:"locale"`
+    Name      string    `json:"name"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MissingTranslation is a record without a name in a locale.
+type MissingTranslation struct {
+    Entity   string `json:"entity"`
+    EntityID int    `json:"entity_id"`
+    Name     string `json:"name"`
+}
+
+// MissingTranslations lists the records still to be translated into a
+// locale.
+type MissingTranslations struct {
+    Locale  string               `json:"locale"`
+    Count   int                  `json:"count"`
+    Missing []MissingTranslation `json:"missing"`
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+    "encoding/json"
+    "time"
+)
+
+// Event types webhooks can subscribe to.
+const (
+    EventOfferCreated         = "offer.created"
+    EventOfferUpdated         = "offer.updated"
+    EventOfferDeleted         = "offer.deleted"
+    EventOfferStatusChanged   = "offer.status_changed"
+    EventMaterialCreated      = "material.created"
+    EventMaterialUpdated      = "material.updated"
+    EventMaterialDeactivated  = "material.deactivated"
+    EventMaterialDeleted      = "material.deleted"
+    EventOfferMaterialAdded   = "offer_material.added"
+    EventOfferMaterialUpdated = "offer_material.updated"
+    EventOfferMaterialRemoved = "offer_material.removed"
+    EventCustomerCreated      = "customer.created"
+    EventCustomerUpdated      = "customer.updated"
+    EventCustomerDeleted      = "customer.deleted"
+)
+
+var WebhookEvents = []string{
+    EventOfferCreated, EventOfferUpdated, EventOfferDeleted, EventOfferStatusChanged,
+    EventMaterialCreated, EventMaterialUpdated, EventMaterialDeactivated, EventMaterialDeleted,
+    EventOfferMaterialAdded, EventOfferMaterialUpdated, EventOfferMaterialRemoved,
+    EventCustomerCreated, EventCustomerUpdated, EventCustomerDeleted,
+}
+
+// Delivery statuses. Pending deliveries are retried with backoff until
+// they succeed or run out of attempts and become dead letters.
+const (
+    DeliveryPending   = "pending"
+    DeliveryDelivered = "delivered"
+    DeliveryDead      = "dead"
+)
+
+// WebhookSubscription sends the events it lists to URL. Secret signs the
+// payloads; it is only shown in the response that creates it.
+type WebhookSubscription struct {
+    ID          int        `json:"id"`
+    URL         string     `json:"url"`
+    Events      []string   `json:"events"`
+    Secret      string     `json:"secret,omitempty"`
+    Active      *bool      `json:"active"`
+    Description string     `json:"description"`
+    TenantID    string     `json:"tenant_id"`
+    CreatedAt   time.Time  `json:"created_at"`
+    UpdatedAt   time.Time  `json:"updated_at"`
+    DeletedAt   *time.Time `json:"deleted_at"`
+}
+
+// WebhookDelivery is one event on its way to one subscription.
+type WebhookDelivery struct {
+    ID             int              `json:"id"`
+    SubscriptionID int              `json:"subscription_id"`
+    EventID        string           `json:"event_id"`
+    Event          string           `json:"event"`
+    Payload        json.RawMessage  `json:"payload"`
+    Status         string           `json:"status"`
+    Attempts       int              `json:"attempts"`
+    NextAttemptAt  *time.Time       `json:"next_attempt_at"`
+    LastError      string           `json:"last_error"`
+    CreatedAt      time.Time        `json:"created_at"`
+    DeliveredAt    *time.Time       `json:"delivered_at"`
+    // AttemptLog is only set when a single delivery is requested.
+    AttemptLog     []WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt logs one try at a delivery. StatusCode is nil when no
+// response arrived.
+type WebhookAttempt struct {
+    Attempt      int       `json:"attempt"`
+    StatusCode   *int      `json:"status_code"`
+    Error        string    `json:"error"`
+    ResponseBody string    `json:"response_body"`
+    DurationMS   int64     `json:"duration_ms"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// OfferStatusChangedEvent is the data of offer.status_changed.
+type OfferStatusChangedEvent struct {
+    OfferID    int        `json:"offer_id"`
+    From       string     `json:"from"`
+    To         string     `json:"to"`
+    ValidUntil *time.Time `json:"valid_until"`
+}
+
+// MaterialDeactivatedEvent is the data of material.deactivated.
+type MaterialDeactivatedEvent struct {
+    MaterialID     int             `json:"material_id"`
+    Name           string          `json:"name"`
+    AffectedOffers []AffectedOffer `json:"affected_offers"`
+}
+
+// OfferDeletedEvent is the data of offer.deleted.
+type OfferDeletedEvent struct {
+    OfferID int `json:"offer_id"`
+}
+
+// MaterialDeletedEvent is the data of material.deleted.
+type MaterialDeletedEvent struct {
+    MaterialID int `json:"material_id"`
+}
+
+// OfferMaterialRemovedEvent is the data of offer_material.removed, sent
+// when a line is deleted or moved to another offer.
+type OfferMaterialRemovedEvent struct {
+    OfferMaterialID int `json:"offer_material_id"`
+    OfferID         int `json:"offer_id"`
+    MaterialID      int `json:"material_id"`
+}
+
+// CustomerDeletedEvent is the data of customer.deleted.
+type CustomerDeletedEvent struct {
+    CustomerID int `json:"customer_id"`
+}
@@ -0,0 +1,106 @@
+package outbox
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Conn is the part of a NATS connection the publisher needs. *nats.Conn
+// satisfies it, as does MemoryBus.
+type Conn interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSPublisher publishes each message's payload on Prefix + "." + the
+// event type, e.g. "products.events.offer.created".
+type NATSPublisher struct {
+	Conn   Conn
+	Prefix string
+}
+
+// Publish sends m to the subject of its type.
+func (p NATSPublisher) Publish(ctx context.Context, m Message) error {
+	return p.Conn.Publish(p.Subject(m.Type), m.Payload)
+}
+
+// Subject returns the subject events of eventType are published on.
+func (p NATSPublisher) Subject(eventType string) string {
+	if p.Prefix == "" {
+		return eventType
+	}
+	return p.Prefix + "." + eventType
+}
+
+// MemoryBus is an in-process stand-in for a NATS server. Handlers run
+// synchronously in Publish, in subscription order, and get their own copy
+// of the data.
+type MemoryBus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   []memorySubscription
+}
+
+type memorySubscription struct {
+	id      int
+	subject string
+	handler func(subject string, data []byte)
+}
+
+// NewMemoryBus returns an empty bus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Subscribe calls handler for every message on a matching subject. As in
+// NATS, "*" matches one token and a trailing ">" the rest. The returned
+// function removes the subscription.
+func (b *MemoryBus) Subscribe(subject string, handler func(subject string, data []byte)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.subs = append(b.subs, memorySubscription{id: id, subject: subject, handler: handler})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, sub := range b.subs {
+			if sub.id == id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers data to the subscribers of subject.
+func (b *MemoryBus) Publish(subject string, data []byte) error {
+	b.mu.RLock()
+	var handlers []func(string, []byte)
+	for _, sub := range b.subs {
+		if MatchSubject(sub.subject, subject) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(subject, append([]byte(nil), data...))
+	}
+	return nil
+}
+
+// MatchSubject reports whether subject matches a NATS subscription
+// pattern.
+func MatchSubject(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" && i == len(patternTokens)-1 {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
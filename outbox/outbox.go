@@ -0,0 +1,74 @@
+// Package outbox publishes domain events that were stored in the outbox
+// table together with the change that caused them.
+//
+// The relay reads pending messages in order and hands each to a
+// Publisher. A message is only marked published once every publisher
+// accepted it, so publishers see each message at least once and must
+// tolerate duplicates, e.g. by keying on Message.EventID.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Message is one stored domain event. Payload is the JSON event envelope
+// as published to subscribers.
+type Message struct {
+	ID          int64
+	EventID     string
+	Aggregate   string
+	AggregateID string
+	Type        string
+	TenantID    string
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+// Publisher sends messages to one destination. An error makes the relay
+// try the message again later; messages of the same aggregate wait until
+// it succeeds.
+type Publisher interface {
+	Publish(ctx context.Context, m Message) error
+}
+
+// PublisherFunc adapts a function to a Publisher.
+type PublisherFunc func(ctx context.Context, m Message) error
+
+// Publish calls f.
+func (f PublisherFunc) Publish(ctx context.Context, m Message) error {
+	return f(ctx, m)
+}
+
+// Publishers sends every message to all of its publishers. It tries each
+// one even after a failure and reports the joined errors, so a broken
+// destination does not hold back the others longer than one retry.
+type Publishers []Publisher
+
+// Publish sends m to every publisher.
+func (p Publishers) Publish(ctx context.Context, m Message) error {
+	var errs []error
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogPublisher writes a line per message, for development and auditing.
+type LogPublisher struct {
+	Logger *log.Logger
+}
+
+// Publish logs m. It never fails.
+func (p LogPublisher) Publish(ctx context.Context, m Message) error {
+	logf := log.Printf
+	if p.Logger != nil {
+		logf = p.Logger.Printf
+	}
+	logf("Outbox event %s %s for %s %s (tenant %s): %s", m.EventID, m.Type, m.Aggregate, m.AggregateID, m.TenantID, m.Payload)
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package search finds offers and materials by name. Index is implemented
+// by Postgres full-text search and by an in-process index.
+package search
+
+import (
+	"Products/models"
+	"context"
+	"strings"
+	"unicode"
+)
+
+// Highlight markers wrapped around matched words in snippets.
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+// Index searches names of non-deleted offers and materials. Terms match
+// as prefixes and all terms must match. types limits the result types;
+// empty means all.
+type Index interface {
+	Search(ctx context.Context, query string, types []string, limit int) ([]models.SearchResult, error)
+}
+
+// Terms splits a query into lower-cased words, dropping punctuation.
+func Terms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Snippet returns name, HTML-escaped, with the words that match a term as
+// a prefix wrapped in the highlight markers.
+func Snippet(name string, terms []string) string {
+	words := wordSpans(name)
+	matched := make([]bool, len(words))
+	for i, w := range words {
+		word := strings.ToLower(name[w[0]:w[1]])
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				matched[i] = true
+				break
+			}
+		}
+	}
+	return highlight(name, words, matched)
+}
+
+func wantType(types []string, t string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, want := range types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package search
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type memoryKey struct {
+	Tenant string
+	Type   string
+	ID     int
+}
+
+// MemoryIndex is an in-process Index for running without Postgres. Callers
+// keep it current with Put and Remove as records change. Searches only see
+// records of the tenant in their context.
+type MemoryIndex struct {
+	mu   sync.RWMutex
+	docs map[memoryKey]string
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{docs: map[memoryKey]string{}}
+}
+
+// Put adds or replaces the name indexed for a record of a tenant.
+func (m *MemoryIndex) Put(tenantID, recordType string, id int, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs[memoryKey{tenantID, recordType, id}] = name
+}
+
+// Remove drops a record, e.g. when it is soft deleted.
+func (m *MemoryIndex) Remove(tenantID, recordType string, id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, memoryKey{tenantID, recordType, id})
+}
+
+func (m *MemoryIndex) Search(ctx context.Context, query string, types []string, limit int) ([]models.SearchResult, error) {
+	results := []models.SearchResult{}
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	m.mu.RLock()
+	for key, name := range m.docs {
+		if key.Tenant != tenantID || !wantType(types, key.Type) {
+			continue
+		}
+		words := wordSpans(name)
+		matched := make([]bool, len(words))
+		ok := true
+		for _, term := range terms {
+			found := false
+			for i, w := range words {
+				if strings.HasPrefix(strings.ToLower(name[w[0]:w[1]]), term) {
+					matched[i] = true
+					found = true
+				}
+			}
+			if !found {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		hits := 0
+		for _, hit := range matched {
+			if hit {
+				hits++
+			}
+		}
+		results = append(results, models.SearchResult{
+			Type:    key.Type,
+			ID:      key.ID,
+			Name:    name,
+			Rank:    float64(hits) / float64(len(words)),
+			Snippet: highlight(name, words, matched),
+		})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		if results[i].Type != results[j].Type {
+			return results[i].Type < results[j].Type
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// wordSpans returns the byte ranges of the words in s, split the same way
+// as Terms.
+func wordSpans(s string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range s {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(s)})
+	}
+	return spans
+}
+
+// highlight HTML-escapes s and marks the matched words. Names are user
+// input, so only the markers may come through as markup.
+func highlight(s string, words [][2]int, matched []bool) string {
+	var b strings.Builder
+	last := 0
+	for i, w := range words {
+		if !matched[i] {
+			continue
+		}
+		b.WriteString(html.EscapeString(s[last:w[0]]))
+		b.WriteString(HighlightStart)
+		b.WriteString(html.EscapeString(s[w[0]:w[1]]))
+		b.WriteString(HighlightStop)
+		last = w[1]
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+	return b.String()
+}
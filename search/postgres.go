@@ -0,0 +1,77 @@
+package search
+
+import (
+	"Products/models"
+	"Products/tenant"
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// PostgresIndex uses to_tsvector('simple', name), which is backed by the
+// GIN expression indexes created in config.createTables.
+type PostgresIndex struct {
+	db *sql.DB
+}
+
+func NewPostgresIndex(db *sql.DB) *PostgresIndex {
+	return &PostgresIndex{db: db}
+}
+
+// Snippets are built by Snippet rather than ts_headline, which would pass
+// markup in names through unescaped.
+const postgresSearchQuery = `WITH q AS (SELECT to_tsquery('simple', $1) AS query)
+	SELECT type, id, name, rank FROM (
+		SELECT 'offer' AS type, o.id, o.name,
+			ts_rank(to_tsvector('simple', o.name), q.query) AS rank
+		FROM offer o, q
+		WHERE o.deleted_at IS NULL AND o.tenant_id = $4 AND to_tsvector('simple', o.name) @@ q.query
+		UNION ALL
+		SELECT 'material', m.id, m.name,
+			ts_rank(to_tsvector('simple', m.name), q.query)
+		FROM material m, q
+		WHERE m.deleted_at IS NULL AND m.tenant_id = $4 AND to_tsvector('simple', m.name) @@ q.query
+	) results
+	WHERE cardinality($2::text[]) = 0 OR type = ANY($2::text[])
+	ORDER BY rank DESC, type, id
+	LIMIT $3`
+
+func (p *PostgresIndex) Search(ctx context.Context, query string, types []string, limit int) ([]models.SearchResult, error) {
+	results := []models.SearchResult{}
+	terms := Terms(query)
+	tsquery := PrefixQuery(query)
+	if tsquery == "" {
+		return results, nil
+	}
+	if types == nil {
+		types = []string{}
+	}
+
+	rows, err := p.db.QueryContext(ctx, postgresSearchQuery, tsquery, pq.Array(types), limit, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result models.SearchResult
+		if err := rows.Scan(&result.Type, &result.ID, &result.Name, &result.Rank); err != nil {
+			return nil, err
+		}
+		result.Snippet = Snippet(result.Name, terms)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// PrefixQuery turns free text into a tsquery where every term must match
+// as a prefix, e.g. "steel sh" becomes "steel:* & sh:*".
+func PrefixQuery(query string) string {
+	terms := Terms(query)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
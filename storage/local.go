@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Local stores blobs as files below a root directory.
+type Local struct {
+	root string
+}
+
+// NewLocal creates root if needed and stores blobs below it.
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{root: root}, nil
+}
+
+// path maps a key to a file below the root. Cleaning the key as an absolute
+// path drops any "..", so keys cannot escape the root.
+func (l *Local) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put writes to a temporary file next to the blob and renames it into
+// place, so readers never see a partial blob.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	target := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := ctx.Err(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), target)
+}
+
+func (l *Local) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+// Package storage keeps uploaded files outside the database. Store is
+// implemented by a local directory; the database only holds the keys.
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+)
+
+// ErrNotFound is returned for keys without a blob.
+var ErrNotFound = errors.New("blob not found")
+
+// Store saves blobs under slash-separated keys.
+type Store interface {
+	// Put streams r into the blob at key, replacing it, and returns the
+	// number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open returns the blob for reading. It can seek, so ranges can be
+	// served from it.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the blob. Missing blobs are not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewKey returns a fresh random key under prefix, e.g.
+// "acme/material/3f2a...".
+func NewKey(prefix ...string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return path.Join(append(prefix, hex.EncodeToString(b))...), nil
+}
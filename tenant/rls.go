@@ -0,0 +1,157 @@
+package tenant
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// RLSSetting is the Postgres setting the row-level security policies
+// compare tenant_id with.
+const RLSSetting = "app.tenant_id"
+
+// NewRLSConnector wraps a driver connector so that every statement runs
+// with app.tenant_id set to the tenant of its context. This is what the
+// row-level security policies created with TENANT_RLS=true rely on;
+// statements without a tenant in their context see no tenant rows.
+func NewRLSConnector(c driver.Connector) driver.Connector {
+	return &rlsConnector{Connector: c}
+}
+
+type rlsConnector struct {
+	driver.Connector
+}
+
+func (c *rlsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &rlsConn{Conn: conn, unknown: true}, nil
+}
+
+// rlsConn remembers the tenant last set on its session so the setting is
+// only sent when it changes. A setting changed inside a transaction is
+// undone by a rollback, so it is forgotten when the transaction ends.
+type rlsConn struct {
+	driver.Conn
+	current string
+	unknown bool
+	inTx    bool
+	setInTx bool
+}
+
+func (c *rlsConn) setTenant(ctx context.Context) error {
+	id := FromContext(ctx)
+	if !c.unknown && id == c.current {
+		return nil
+	}
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return driver.ErrSkip
+	}
+	_, err := execer.ExecContext(ctx, "SELECT set_config('"+RLSSetting+"', $1, false)", []driver.NamedValue{{Ordinal: 1, Value: id}})
+	if err != nil {
+		c.unknown = true
+		return err
+	}
+	c.current, c.unknown = id, false
+	if c.inTx {
+		c.setInTx = true
+	}
+	return nil
+}
+
+func (c *rlsConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.setTenant(ctx); err != nil {
+		return nil, err
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *rlsConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.setTenant(ctx); err != nil {
+		return nil, err
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *rlsConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.setTenant(ctx); err != nil {
+		return nil, err
+	}
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *rlsConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.setTenant(ctx); err != nil {
+		return nil, err
+	}
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.inTx, c.setInTx = true, false
+	return &rlsTx{Tx: tx, conn: c}, nil
+}
+
+func (c *rlsConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *rlsConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *rlsConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type rlsTx struct {
+	driver.Tx
+	conn *rlsConn
+}
+
+func (t *rlsTx) end() {
+	if t.conn.setInTx {
+		t.conn.unknown = true
+	}
+	t.conn.inTx, t.conn.setInTx = false, false
+}
+
+func (t *rlsTx) Commit() error {
+	defer t.end()
+	return t.Tx.Commit()
+}
+
+func (t *rlsTx) Rollback() error {
+	defer t.end()
+	return t.Tx.Rollback()
+}
@@ -0,0 +1,77 @@
+// Package tenant resolves which organization a request acts for. Offers,
+// materials and offer lines belong to exactly one tenant, and every query
+// on them is scoped to the tenant in the request context.
+package tenant
+
+import (
+	"Products/auth"
+	"context"
+	"net/http"
+	"regexp"
+)
+
+// HeaderID selects the tenant for platform admins, the only principals not
+// bound to one.
+const HeaderID = "X-Tenant-ID"
+
+// All is the tenant of system jobs that work across tenants, such as
+// expiring offers. It never comes from a request.
+const All = "*"
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type contextKey struct{}
+
+// Valid reports whether id can name a tenant.
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// WithID returns a copy of ctx acting for tenant id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of ctx, or "" when there is none, which
+// matches no rows.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware puts the request's tenant into its context. A principal bound
+// to a tenant always acts for it and gets 403 when the header names another
+// one. Unbound principals get 403 too, unless they are platform admins,
+// who choose with the header and fall back to defaultID. Without either
+// the request is rejected with 400.
+func Middleware(defaultID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(HeaderID)
+			id := defaultID
+			switch p, _ := auth.FromContext(r.Context()); {
+			case p.Tenant != "":
+				if header != "" && header != p.Tenant {
+					http.Error(w, "forbidden: principal belongs to another tenant", http.StatusForbidden)
+					return
+				}
+				id = p.Tenant
+			case !p.HasRole(auth.RolePlatformAdmin):
+				http.Error(w, "forbidden: principal is not bound to a tenant", http.StatusForbidden)
+				return
+			case header != "":
+				id = header
+			}
+
+			if id == "" {
+				http.Error(w, "missing "+HeaderID, http.StatusBadRequest)
+				return
+			}
+			if !Valid(id) {
+				http.Error(w, "invalid "+HeaderID, http.StatusBadRequest)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+		})
+	}
+}
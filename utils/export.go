@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats understood by ExportFormat.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatXLSX   = "xlsx"
+)
+
+var exportContentTypes = map[string]string{
+	FormatCSV:    "text/csv",
+	FormatNDJSON: "application/x-ndjson",
+	FormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// ExportFormat picks the export format from ?format= or, failing that, the
+// Accept header. An empty result means the caller should answer with JSON.
+func ExportFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		switch format {
+		case "json":
+			return "", nil
+		case "jsonl":
+			return FormatNDJSON, nil
+		case FormatCSV, FormatNDJSON, FormatXLSX:
+			return format, nil
+		}
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		for format, contentType := range exportContentTypes {
+			if mediaType == contentType {
+				return format, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// RowWriter streams tabular data in one of the export formats.
+type RowWriter interface {
+	WriteRow(values ...interface{}) error
+	Close() error
+}
+
+// NewRowWriter sets the download headers on w and writes the column header
+// row where the format has one.
+func NewRowWriter(w http.ResponseWriter, format, filename string, columns []string) (RowWriter, error) {
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+
+	switch format {
+	case FormatCSV:
+		cw := &csvRowWriter{w: csv.NewWriter(w)}
+		return cw, cw.w.Write(columns)
+	case FormatNDJSON:
+		return &ndjsonRowWriter{w: w, columns: columns}, nil
+	default:
+		xw, err := NewXLSXWriter(w, filename)
+		if err != nil {
+			return nil, err
+		}
+		return xw, xw.WriteRow(stringValues(columns)...)
+	}
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) WriteRow(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = FormatValue(value)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonRowWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+// WriteRow writes one JSON object per line, keeping the column order.
+func (n *ndjsonRowWriter) WriteRow(values ...interface{}) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(n.columns[i])
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(encoded)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(n.w, b.String())
+	return err
+}
+
+func (n *ndjsonRowWriter) Close() error {
+	return nil
+}
+
+// FormatValue renders a scanned column value as text for CSV and similar
+// formats. NULLs become empty strings and times use RFC 3339.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	case int:
+		return strconv.Itoa(v)
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case *bool:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatBool(*v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+func stringValues(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
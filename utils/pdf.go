@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page layout for WriteTextPDF: A4 in points, 10pt Courier.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	pdfFontSize   = 10
+	pdfLeading    = 13
+	pdfCharWidth  = 6 // Courier glyphs are 600/1000 em wide
+)
+
+// WriteTextPDF lays out plain text as a PDF using the built-in Courier
+// font, so columns line up and no font files are needed. Lines that do
+// not fit are wrapped and pages break automatically. A line consisting of
+// a single form feed forces a page break.
+func WriteTextPDF(w io.Writer, text string) error {
+	perLine := (pdfPageWidth - 2*pdfMargin) / pdfCharWidth
+	perPage := (pdfPageHeight - 2*pdfMargin) / pdfLeading
+
+	pages := [][]string{{}}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if line == "\f" {
+			pages = append(pages, []string{})
+			continue
+		}
+		for _, part := range wrapLine(line, perLine) {
+			if len(pages[len(pages)-1]) == perPage {
+				pages = append(pages, []string{})
+			}
+			pages[len(pages)-1] = append(pages[len(pages)-1], part)
+		}
+	}
+
+	// Objects 1-3 are the catalog, page tree and font; each page then
+	// takes two objects, the page and its content stream.
+	var buf bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	for i, lines := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i))
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin-pdfFontSize)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfEscape(line))
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func wrapLine(line string, width int) []string {
+	runes := []rune(strings.TrimRight(line, " \t"))
+	if len(runes) <= width {
+		return []string{string(runes)}
+	}
+	parts := []string{}
+	for len(runes) > width {
+		cut := width
+		for i := width; i > width/2; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		parts = append(parts, string(runes[:cut]))
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+	}
+	return append(parts, string(runes))
+}
+
+// winAnsiExtras are the WinAnsi characters outside Latin-1, by code.
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfEscape encodes a line as a PDF string literal in WinAnsi. Characters
+// WinAnsi lacks are replaced with '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		code, extra := winAnsiExtras[r]
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case extra:
+			fmt.Fprintf(&b, "\\%03o", code)
+		case r < 0x20:
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// XLSXWriter streams a single-sheet workbook. Rows go straight into the
+// zip entry for the sheet, so the workbook is never held in memory.
+type XLSXWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+// NewXLSXWriter writes the workbook scaffolding and opens the sheet.
+func NewXLSXWriter(w io.Writer, sheetName string) (*XLSXWriter, error) {
+	zw := zip.NewWriter(w)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(sheetTitle(sheetName)))},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return &XLSXWriter{zw: zw, sheet: sheet}, nil
+}
+
+// WriteRow appends a row. Numbers and booleans keep their cell type,
+// everything else is written as an inline string.
+func (x *XLSXWriter) WriteRow(values ...interface{}) error {
+	x.row++
+	x.sheet.WriteString(`<row r="` + strconv.Itoa(x.row) + `">`)
+	for i, value := range values {
+		ref := xlsxColumn(i) + strconv.Itoa(x.row)
+		switch v := value.(type) {
+		case int, int64, float64:
+			x.sheet.WriteString(`<c r="` + ref + `"><v>` + FormatValue(v) + `</v></c>`)
+		case *int, *float64:
+			if text := FormatValue(v); text != "" {
+				x.sheet.WriteString(`<c r="` + ref + `"><v>` + text + `</v></c>`)
+			}
+		case bool:
+			x.sheet.WriteString(`<c r="` + ref + `" t="b"><v>` + xlsxBool(v) + `</v></c>`)
+		case *bool:
+			if v != nil {
+				x.sheet.WriteString(`<c r="` + ref + `" t="b"><v>` + xlsxBool(*v) + `</v></c>`)
+			}
+		default:
+			if text := FormatValue(v); text != "" {
+				x.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t>` + xmlEscape(text) + `</t></is></c>`)
+			}
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+// Close finishes the sheet and the zip archive.
+func (x *XLSXWriter) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxColumn converts a zero-based index to a column name: 0 is A, 26 is AA.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xlsxBool(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// sheetTitle trims a sheet name to Excel's 31 character limit.
+func sheetTitle(name string) string {
+	if len(name) > 31 {
+		return name[:31]
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,161 @@
+// Package webhook signs and delivers events to subscriber endpoints.
+//
+// Every request is a JSON Event POSTed with the headers below. Receivers
+// check the signature by computing HMAC-SHA256 over "<timestamp>.<body>"
+// with the subscription's secret, as Verify does, and should reject old
+// timestamps to prevent replays.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// signaturePrefix versions the signature scheme.
+const signaturePrefix = "v1="
+
+// maxResponseBody is how much of a receiver's response is kept for the
+// delivery log.
+const maxResponseBody = 1024
+
+// Event is the body of a delivery.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	TenantID  string          `json:"tenant_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewEvent wraps data in an event with a fresh ID.
+func NewEvent(eventType, tenantID string, data interface{}) (Event, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return Event{}, err
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{ID: "evt_" + id, Type: eventType, TenantID: tenantID, CreatedAt: time.Now().UTC(), Data: encoded}, nil
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	s, err := randomHex(32)
+	return "whsec_" + s, err
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp is within
+// tolerance of now.
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return errors.New("timestamp outside tolerance")
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// Backoff returns the wait before the attempt after the given number of
+// failed attempts: base doubled per failure, capped at max.
+func Backoff(failures int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < failures && wait < max; i++ {
+		wait *= 2
+	}
+	return min(wait, max)
+}
+
+// Result is the outcome of one delivery attempt. StatusCode is 0 when no
+// response arrived.
+type Result struct {
+	StatusCode   int
+	Err          error
+	ResponseBody string
+	Duration     time.Duration
+}
+
+// OK reports whether the receiver accepted the delivery with a 2xx.
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+// Deliver POSTs body to url, signed with secret. Anything but a 2xx
+// response is an error.
+func Deliver(ctx context.Context, client *http.Client, url, secret, eventType string, deliveryID int, body []byte) Result {
+	started := time.Now()
+	result := deliver(ctx, client, url, secret, eventType, deliveryID, body)
+	result.Duration = time.Since(started)
+	return result
+}
+
+func deliver(ctx context.Context, client *http.Client, url, secret, eventType string, deliveryID int, body []byte) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Result{Err: err}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Products-Webhooks/1")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(deliveryID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	// Drain a little more so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result := Result{StatusCode: resp.StatusCode, ResponseBody: strings.ToValidUTF8(string(snippet), "")}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Err = fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return result
+}